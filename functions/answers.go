@@ -0,0 +1,152 @@
+package functions
+
+// A student's answers to a quiz, as posted by the quiz form.
+// Answers are keyed by question Id, never by position, and choices are named by choice Id: the choice's place in the
+// question's Answers at the version the student was shown.  Revisions never change, so a choice Id always names the
+// same choice.  Grading walks the stored quiz, so a question missing from the form counts as wrong, and a form that
+// names questions or choices the quiz doesn't have is rejected before anything is graded.
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const AnswerPrefix = "q." // Form keys are AnswerPrefix followed by the question Id
+
+type Answers map[string][]string // Question Id -> the choice Ids picked (in order, for ordering questions), or the response typed for a grid-in or essay
+
+type Choice struct { // An answer choice as shown on the quiz form
+	Id   string
+	Text string
+}
+
+func ChoiceID(i int) string {
+	return strconv.Itoa(i)
+}
+
+func (question Question) Choices() []Choice {
+	choices := []Choice{}
+	for i := 0; i < len(question.Answers); i++ {
+		choices = append(choices, Choice{ChoiceID(i), question.Answers[i]})
+	}
+	return choices
+}
+
+func (question Question) choiceIndex(id string) int {
+	// Index into Answers of the choice with the given Id, or -1
+	i, err := strconv.Atoi(id)
+	if err != nil || ChoiceID(i) != id || i < 0 || i >= len(question.Answers) {
+		return -1
+	}
+	return i
+}
+
+func ReadAnswers(form url.Values) Answers {
+	// The answers in a posted quiz form.  Other fields, such as the version, are left out.
+	answers := Answers{}
+	for key, values := range form {
+		if strings.HasPrefix(key, AnswerPrefix) {
+			answers[strings.TrimPrefix(key, AnswerPrefix)] = values
+		}
+	}
+	return answers
+}
+
+func (quiz Quiz) CheckAnswers(answers Answers) error {
+	// Checks posted answers against the stored quiz they are graded against.  The error says what is malformed.
+	for id, response := range answers {
+		i := quiz.FindQuestion(id)
+		if i < 0 {
+			return fmt.Errorf("there is no question %q in this quiz", id)
+		}
+		err := quiz.Questions[i].checkResponse(response)
+		if err != nil {
+			return fmt.Errorf("question %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+func (question Question) checkResponse(response []string) error {
+	switch question.Kind() {
+	case MultiSelectQuestion:
+		seen := map[string]bool{}
+		for _, id := range response {
+			if question.choiceIndex(id) < 0 {
+				return fmt.Errorf("there is no choice %q", id)
+			} else if seen[id] {
+				return fmt.Errorf("choice %q is picked twice", id)
+			}
+			seen[id] = true
+		}
+		return nil
+	case OrderingQuestion:
+		// Every place is sent, left empty if the student didn't fill it
+		if len(response) != len(question.Answers) {
+			return fmt.Errorf("expected %d places, not %d", len(question.Answers), len(response))
+		}
+		seen := map[string]bool{}
+		for _, id := range response {
+			if id == "" {
+				continue
+			} else if question.choiceIndex(id) < 0 {
+				return fmt.Errorf("there is no item %q", id)
+			} else if seen[id] {
+				return fmt.Errorf("item %q is placed twice", id)
+			}
+			seen[id] = true
+		}
+		return nil
+	}
+	if len(response) > 1 {
+		return fmt.Errorf("expected one answer, not %d", len(response))
+	} else if question.Kind() == ChoiceQuestion && len(response) == 1 && question.choiceIndex(response[0]) < 0 {
+		return fmt.Errorf("there is no choice %q", response[0])
+	}
+	return nil
+}
+
+func (quiz Quiz) CheckResponse(id string, response []string) error {
+	// Checks one answer saved on its own.  ErrNotFound if there is no such question; an empty response clears the
+	// answer, so it is always allowed.
+	i := quiz.FindQuestion(id)
+	if i < 0 {
+		return ErrNotFound
+	} else if len(response) == 0 {
+		return nil
+	}
+	return quiz.CheckAnswers(Answers{id: response})
+}
+
+// For filling saved answers back into the quiz form
+
+func (question QuizId) Picked(id string) bool {
+	for _, picked := range question.Response {
+		if picked == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (question QuizId) Typed() string {
+	if len(question.Response) == 0 {
+		return ""
+	}
+	return question.Response[0]
+}
+
+func (question QuizId) Placed(place int, id string) bool {
+	// Whether the ordering question's place (from 0) holds the item with the given id
+	return place < len(question.Response) && question.Response[place] == id
+}
+
+func singleResponse(response []string) string {
+	// The answer to a question that takes one, or "" if it was left blank
+	if len(response) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(response[0])
+}
@@ -0,0 +1,123 @@
+package functions
+
+// Quiz attempts: every quiz a logged-in student has graded is kept, with their answers, so they can look back over
+// their work.  Scores (see ScoreReport) are worked out from the attempts rather than stored on their own.
+// A logged-in student's attempt starts on the server when they open the quiz, and stays in progress, with the answers
+// saved so far, until it is graded; opening the quiz again, from any device, carries on with it.  Timed quizzes give
+// their attempts a deadline, and answers that arrive after it don't count.
+
+import (
+	"time"
+)
+
+const MaxTimeLimit = 300 // Minutes a timed quiz may allow
+
+const SubmitGrace = 30 * time.Second // How late after the deadline a timed attempt's answers still count, for slow connections
+
+type Attempt struct { // One quiz taken by one student
+	Id         string        `bson:"_id"`
+	Username   string        `bson:"username"`
+	Quiz       QuizID        `bson:"quiz,omitempty"`    // Empty for scores kept from before attempts were
+	Version    int           `bson:"version"`           // Revision it was graded against
+	Title      string        `bson:"title"`             // The quiz's title then
+	Subject    string        `bson:"subject"`           // And its subject
+	Started    time.Time     `bson:"started"`           // When the quiz was opened, or Finished if that isn't known
+	Deadline   time.Time     `bson:"deadline"`          // When time runs out, for timed quizzes; zero otherwise
+	Finished   time.Time     `bson:"finished"`          // Zero while the attempt is in progress
+	Responses  Answers       `bson:"responses"`         // While in progress, the answers saved so far
+	Graded     bool          `bson:"graded"`            // False while essays wait to be scored by hand
+	Score      float32       `bson:"score"`             // Percentage, like Submission.Score; final once Graded
+	Submission string        `bson:"submission"`        // Id of the submission holding its essays, if it has any
+	Sitting    string        `bson:"sitting,omitempty"` // For a section of a full-length test, the Sitting it belongs to
+	Policy     ScoringPolicy `bson:"policy"`            // How it was scored, so scoring it again from Responses gives the same Score
+}
+
+func NewAttemptID() string {
+	return NewQuestionID()
+}
+
+func (quiz Quiz) Timed() bool {
+	return quiz.TimeLimit > 0
+}
+
+func StartAttempt(quiz Quiz, username string, now time.Time) Attempt {
+	// A new attempt at quiz (the stored quiz shown to the student), in progress.  Timed quizzes get their deadline.
+	attempt := Attempt{
+		Username:  username,
+		Quiz:      quiz.Id,
+		Version:   quiz.Version,
+		Title:     quiz.Title,
+		Subject:   quiz.Subject,
+		Started:   now,
+		Responses: Answers{},
+	}
+	if quiz.Timed() {
+		attempt.Deadline = now.Add(time.Duration(quiz.TimeLimit) * time.Minute)
+	}
+	return attempt
+}
+
+func (attempt Attempt) Finish(answers Answers, submission Submission, now time.Time) Attempt {
+	// The attempt graded as submission, finishing now
+	attempt.Finished = now
+	attempt.Responses = answers
+	attempt.Graded = submission.Graded
+	attempt.Score = submission.Score
+	attempt.Submission = submission.Id
+	attempt.Policy = submission.Policy
+	return attempt
+}
+
+func NewAttempt(quiz Quiz, answers Answers, submission Submission, started time.Time) Attempt {
+	// An untimed attempt at quiz (the stored quiz it was graded against) finishing now.  started may be zero if it isn't known.
+	finished := time.Now()
+	if started.IsZero() || started.After(finished) {
+		started = finished
+	}
+	attempt := StartAttempt(quiz, submission.Username, started)
+	attempt.Deadline = time.Time{}
+	return attempt.Finish(answers, submission, finished)
+}
+
+func (attempt Attempt) InProgress() bool {
+	return attempt.Finished.IsZero() && !attempt.Legacy()
+}
+
+func (attempt Attempt) Timed() bool {
+	return !attempt.Deadline.IsZero()
+}
+
+func (attempt Attempt) Expired(now time.Time) bool {
+	// Whether answers arriving now are too late to count
+	return attempt.Timed() && now.After(attempt.Deadline.Add(SubmitGrace))
+}
+
+func (attempt Attempt) Late() bool {
+	// Whether time ran out before the attempt was submitted, so it was graded on its saved answers
+	return !attempt.InProgress() && attempt.Expired(attempt.Finished)
+}
+
+func (attempt Attempt) Remaining(now time.Time) time.Duration {
+	// Time left on a timed attempt, never less than zero
+	if !attempt.Timed() || now.After(attempt.Deadline) {
+		return 0
+	}
+	return attempt.Deadline.Sub(now)
+}
+
+func (attempt Attempt) Duration() time.Duration {
+	// Time taken, to the second
+	return attempt.Finished.Sub(attempt.Started).Round(time.Second)
+}
+
+func (attempt Attempt) ScoredAgainst(graded Quiz) Quiz {
+	// graded, the revision the attempt was taken against, with the policy the attempt was scored under rather than
+	// the revision's, for scoring it again or reviewing it
+	graded.Policy = attempt.Policy
+	return graded
+}
+
+func (attempt Attempt) Legacy() bool {
+	// Whether this is a score kept from before attempts were, with no quiz or answers
+	return attempt.Quiz == ""
+}
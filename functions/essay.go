@@ -0,0 +1,194 @@
+package functions
+
+// Essay (free-response) questions, graded by hand against a rubric.
+// Grading a quiz with essays saves a Submission holding the essay answers; admins and counselors score them from the
+// grading queue, and the submission's score is final once the last essay is scored.
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const MaxCriteria = 10 // Rubric rows an essay question may have
+
+type Criterion struct { // One row of a rubric
+	Name   string `bson:"name"`   // What is being judged, e.g. "Uses evidence from the passage"
+	Points int    `bson:"points"` // Most points it can earn
+}
+
+func (question Question) IsEssay() bool {
+	return question.Kind() == EssayQuestion
+}
+
+func (question Question) RubricPoints() int {
+	total := 0
+	for i := 0; i < len(question.Rubric); i++ {
+		total += question.Rubric[i].Points
+	}
+	return total
+}
+
+func (question Question) validateEssay() error {
+	if len(question.Rubric) == 0 || len(question.Rubric) > MaxCriteria {
+		return fmt.Errorf("an essay question needs 1 to %d rubric rows, not %d", MaxCriteria, len(question.Rubric))
+	}
+	for i := 0; i < len(question.Rubric); i++ {
+		if strings.TrimSpace(question.Rubric[i].Name) == "" {
+			return fmt.Errorf("rubric row %d has no description", i+1)
+		} else if question.Rubric[i].Points < 1 {
+			return fmt.Errorf("rubric row %d must be worth at least 1 point", i+1)
+		}
+	}
+	return nil
+}
+
+type EssayResponse struct { // A student's answer to one essay question, and its score once graded
+	Question string      `bson:"question"` // Id of the question
+	Number   int         `bson:"number"`   // Position in the quiz, from 1
+	Prompt   string      `bson:"prompt"`
+	Rubric   []Criterion `bson:"rubric"` // As it was when the quiz was taken
+	Response string      `bson:"response"`
+	Points   []int       `bson:"points"` // Points given for each rubric row
+	Scored   bool        `bson:"scored"`
+	Grader   string      `bson:"grader"` // Username of whoever scored it
+	Worth    float32     `bson:"worth"`  // Points it counts for in the quiz's score; 0 for essays from before weights, which count 1
+}
+
+func (essay EssayResponse) points() float32 {
+	if essay.Worth == 0 {
+		return 1
+	}
+	return essay.Worth
+}
+
+func (essay EssayResponse) Credit() float32 {
+	// Fraction of the rubric's points earned, from 0 to 1
+	total, earned := 0, 0
+	for i := 0; i < len(essay.Rubric); i++ {
+		total += essay.Rubric[i].Points
+		if i < len(essay.Points) {
+			earned += essay.Points[i]
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float32(earned) / float32(total)
+}
+
+type Submission struct { // A graded quiz with essays still to score, or all scored
+	Id        string          `bson:"_id"`
+	Quiz      QuizID          `bson:"quiz"`
+	Version   int             `bson:"version"` // Revision it was taken against
+	Title     string          `bson:"title"`   // The quiz's title then, for the grading queue
+	Username  string          `bson:"username"`
+	Created   time.Time       `bson:"created"`
+	Questions int             `bson:"questions"` // How many questions the quiz had
+	Possible  float32         `bson:"possible"`  // Points the score is out of; 0 for submissions from before weights, which are out of Questions
+	Policy    ScoringPolicy   `bson:"policy"`    // The quiz's when it was graded
+	Credit    float32         `bson:"credit"`    // Points earned on the questions graded automatically, less any penalties
+	Essays    []EssayResponse `bson:"essays"`
+	Graded    bool            `bson:"graded"`  // All essays are scored and Score is final
+	Score     float32         `bson:"score"`   // Percentage, like Quiz.Grade
+	Updates   int             `bson:"updates"` // Times it has been saved since it was submitted, so a stale copy can't overwrite a newer one
+}
+
+func NewSubmissionID() string {
+	return NewQuestionID()
+}
+
+func (submission Submission) Remaining() int {
+	// Essays still to score
+	remaining := 0
+	for i := 0; i < len(submission.Essays); i++ {
+		if !submission.Essays[i].Scored {
+			remaining++
+		}
+	}
+	return remaining
+}
+
+func (submission *Submission) total() float32 {
+	// The percentage with every essay counted as scored so far.  Penalties can't take it below 0.
+	possible := submission.Possible
+	if possible == 0 {
+		possible = float32(submission.Questions)
+	}
+	if possible == 0 {
+		return 0
+	}
+	sum := submission.Credit
+	for i := 0; i < len(submission.Essays); i++ {
+		sum += submission.Essays[i].Credit() * submission.Essays[i].points()
+	}
+	if sum < 0 {
+		return 0
+	}
+	return sum * 100 / possible
+}
+
+func (submission *Submission) ScoreEssay(i int, points []int, grader string) error {
+	// Records the points for essay i, one per rubric row, and finalizes the score if it was the last essay left
+	if submission.Graded {
+		return errors.New("this submission has already been graded")
+	} else if i < 0 || i >= len(submission.Essays) {
+		return errors.New("no such essay")
+	}
+	essay := &submission.Essays[i]
+	if len(points) != len(essay.Rubric) {
+		return fmt.Errorf("give points for each of the %d rubric rows", len(essay.Rubric))
+	}
+	for j := 0; j < len(points); j++ {
+		if points[j] < 0 || points[j] > essay.Rubric[j].Points {
+			return fmt.Errorf("%q is worth 0 to %d points", essay.Rubric[j].Name, essay.Rubric[j].Points)
+		}
+	}
+	essay.Points = points
+	essay.Scored = true
+	essay.Grader = grader
+	if submission.Remaining() == 0 {
+		submission.Graded = true
+		submission.Score = submission.total()
+	}
+	return nil
+}
+
+func (quiz Quiz) GradedAgainst(store QuizStore) (Quiz, error) {
+	// The stored quiz a submission is graded against: as it was at quiz.Version if that is set, otherwise as it is now
+	if quiz.Version > 0 {
+		revision, err := store.RetrieveRevision(quiz.Id, quiz.Version)
+		return revision.GetQuiz(), err
+	}
+	return store.RetrieveQuiz(quiz.Id)
+}
+
+func (quiz Quiz) Submit(answers Answers) Submission {
+	// Grades what can be graded automatically and collects the essays, under the quiz's Policy.  quiz is the stored
+	// quiz (see GradedAgainst) and answers have been through CheckAnswers.  With no essays the result is already Graded.
+	submission := Submission{Quiz: quiz.Id, Version: quiz.Version, Title: quiz.Title, Policy: quiz.Policy, Essays: []EssayResponse{}}
+	for i := 0; i < len(quiz.Questions); i++ {
+		question := quiz.Questions[i]
+		submission.Questions++
+		submission.Possible += quiz.Policy.Worth(question)
+		if !question.IsEssay() {
+			submission.Credit += quiz.Policy.Earned(question, answers[question.Id])
+			continue
+		}
+		submission.Essays = append(submission.Essays, EssayResponse{
+			Question: question.Id,
+			Number:   i + 1,
+			Prompt:   question.Question,
+			Rubric:   question.Rubric,
+			Response: singleResponse(answers[question.Id]),
+			Points:   []int{},
+			Worth:    quiz.Policy.Worth(question),
+		})
+	}
+	if len(submission.Essays) == 0 {
+		submission.Graded = true
+		submission.Score = submission.total()
+	}
+	return submission
+}
@@ -0,0 +1,112 @@
+package functions
+
+// Exporting a quiz, with the passages its questions refer to, as one JSON file, and importing it again here or on
+// another server.
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const ExportFormat = 1 // Bumped whenever QuizExport changes in a way older servers can't read
+
+type QuizExport struct {
+	Format   int
+	Quiz     DbQuiz
+	Passages []Passage
+}
+
+func ExportQuiz(store Store, id QuizID) (QuizExport, error) {
+	// Who wrote the quiz, when, and how often it was taken stay behind; only the content is exported
+	quiz, err := store.RetrieveQuiz(id)
+	if err != nil {
+		return QuizExport{}, err
+	}
+	passages, err := QuizPassages(store, quiz)
+	if err != nil {
+		return QuizExport{}, err
+	}
+	result := QuizExport{Format: ExportFormat, Passages: []Passage{}}
+	result.Quiz = DbQuiz{
+		Title:      quiz.Title,
+		Questions:  quiz.Questions,
+		Subject:    quiz.Subject,
+		Difficulty: quiz.Difficulty,
+		Published:  quiz.Published,
+		Review:     quiz.Review,
+		ReviewDate: quiz.ReviewDate,
+		TimeLimit:  quiz.TimeLimit,
+		Policy:     quiz.Policy,
+	}
+	for i := 0; i < len(quiz.Questions); i++ {
+		passage, ok := passages[quiz.Questions[i].Passage]
+		if ok {
+			result.Passages = append(result.Passages, passage)
+			delete(passages, passage.Id) // Each passage once
+		}
+	}
+	return result, nil
+}
+
+func (export QuizExport) Validate() error {
+	// Checks an uploaded export before anything is saved
+	if export.Format != ExportFormat {
+		return fmt.Errorf("this file is in export format %d; only format %d can be imported", export.Format, ExportFormat)
+	} else if strings.TrimSpace(export.Quiz.Title) == "" {
+		return errors.New("the quiz has no title")
+	} else if export.Quiz.TimeLimit < 0 || export.Quiz.TimeLimit > MaxTimeLimit {
+		return fmt.Errorf("the time limit must be from 0 to %d minutes", MaxTimeLimit)
+	} else if err := export.Quiz.Policy.Validate(); err != nil {
+		return err
+	}
+	included := map[string]bool{}
+	for i := 0; i < len(export.Passages); i++ {
+		err := export.Passages[i].Validate()
+		if err != nil {
+			return fmt.Errorf("passage %d: %v", i+1, err)
+		}
+		included[export.Passages[i].Id] = true
+	}
+	for i := 0; i < len(export.Quiz.Questions); i++ {
+		question := export.Quiz.Questions[i]
+		err := question.Validate()
+		if err == nil && question.Passage != "" && !included[question.Passage] {
+			err = errors.New("its passage isn't in the file")
+		}
+		if err != nil {
+			return fmt.Errorf("question %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+func ImportQuiz(store Store, export QuizExport, author string) (QuizID, error) {
+	// Saves a validated export as a new, unpublished quiz.  A passage that is already here unchanged is shared
+	// rather than copied; any other passage is saved as a new one.
+	ids := map[string]string{} // Passage ID in the file -> ID here
+	for i := 0; i < len(export.Passages); i++ {
+		passage := export.Passages[i]
+		existing, err := store.RetrievePassage(passage.Id)
+		if err == nil && existing.Title == passage.Title && existing.Text == passage.Text {
+			ids[passage.Id] = existing.Id
+			continue
+		} else if err != nil && err != ErrNotFound {
+			return "", err
+		}
+		ids[passage.Id], err = store.InsertPassage(passage)
+		if err != nil {
+			return "", err
+		}
+	}
+	quiz := export.Quiz
+	quiz.Questions = make([]Question, len(export.Quiz.Questions))
+	for i := 0; i < len(quiz.Questions); i++ {
+		quiz.Questions[i] = export.Quiz.Questions[i]
+		quiz.Questions[i].Id = NewQuestionID()
+		quiz.Questions[i].Passage = ids[quiz.Questions[i].Passage]
+	}
+	quiz.Author = author
+	quiz.Published = false
+	return store.InsertQuiz(quiz)
+}
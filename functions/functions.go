@@ -4,22 +4,23 @@ package functions
 // For the SATme server
 
 import (
-	"encoding/hex"
 	"errors"
+	"fmt"
 	"golang.org/x/crypto/bcrypt"
-	"gopkg.in/mgo.v2"
-	"gopkg.in/mgo.v2/bson"
+	"strings"
+	"time"
 )
 
-var dbstr = "127.0.0.1:27017"
 var cryptcost = 10
 
+const MinAnswers = 2 // Answer choices a question may have
+const MaxAnswers = 8
+
 type User struct { // For logging in.
-	Username   string  `schema:"username" bson:"username"`
-	Password   string  `schema:"password" bson:"-"`
-	DbPassword []byte  `bson:"password"`
-	Role       string  `schema:"role" bson:"role"`
-	MaxScore   float32 `schema:"score" bson:"score"`
+	Username   string `schema:"username" bson:"username"`
+	Password   string `schema:"password" bson:"-"`
+	DbPassword []byte `bson:"password"`
+	Role       string `schema:"role" bson:"role"`
 }
 
 type SuccessLogin struct { // Used to pass information to Create Account
@@ -30,270 +31,401 @@ type SuccessLogin struct { // Used to pass information to Create Account
 }
 
 type Question struct { // Quiz question
-	Question     string   `schema:"question" bson:"question"`
-	Answers      []string `schema:"answers" bson:"answers"`
-	AnswerChosen string   `schema:"answer"`
-	CorrectIndex int      `schema:"correct" bson:"correct"`
-	Id           string   `schema:"id" bson:"_id"`
+	Question       string      `schema:"question" bson:"question"`
+	Answers        []string    `schema:"answers" bson:"answers"`
+	CorrectIndex   int         `schema:"correct" bson:"correct"`
+	CorrectIndexes []int       `schema:"corrects" bson:"corrects"`       // Multi-select only: every correct choice
+	Id             string      `schema:"id" bson:"_id"`                  // Set once by NewQuestion or GetQuestion and kept through edits and moves
+	Type           string      `schema:"type" bson:"type"`               // ChoiceQuestion (also when empty), GridInQuestion, MultiSelectQuestion, OrderingQuestion or EssayQuestion
+	Tolerance      float64     `schema:"tolerance" bson:"tolerance"`     // Grid-ins only: how far a response may be from an accepted answer
+	Scoring        string      `schema:"scoring" bson:"scoring"`         // Multi-select and ordering only: AllOrNothing (also when empty) or PartialCredit
+	Passage        string      `schema:"passage" bson:"passage"`         // Id of the reading passage the question is about, if any
+	Rubric         []Criterion `schema:"-" bson:"rubric"`                // Essays only: what the graders score
+	Explanation    string      `schema:"explanation" bson:"explanation"` // Why the answer is right, shown when the quiz is reviewed
+	Solution       string      `schema:"solution" bson:"solution"`       // Optional worked solution, shown with the explanation
+	Weight         int         `schema:"weight" bson:"weight"`           // Points it is worth, up to MaxWeight, when the quiz's policy is Weighted; 0 counts as 1
+}
+
+// Question types.  A grid-in keeps its accepted responses in Answers and has no CorrectIndex; an ordering question
+// keeps its items in Answers in the right order; an essay has no Answers, only a Rubric.
+const ChoiceQuestion = "choice"
+const GridInQuestion = "gridin"
+const MultiSelectQuestion = "multiselect"
+const OrderingQuestion = "ordering"
+const EssayQuestion = "essay"
+
+// Scoring rules for questions with more than one part to get right
+const AllOrNothing = "all"
+const PartialCredit = "partial"
+
+func (question Question) Kind() string {
+	// Type, with questions from before there were types counting as multiple choice
+	if question.Type == "" {
+		return ChoiceQuestion
+	}
+	return question.Type
+}
+
+func (question Question) IsGridIn() bool {
+	return question.Kind() == GridInQuestion
+}
+
+func (question Question) IsMultiSelect() bool {
+	return question.Kind() == MultiSelectQuestion
+}
+
+func (question Question) IsOrdering() bool {
+	return question.Kind() == OrderingQuestion
+}
+
+func (question Question) IsCorrect(i int) bool {
+	// Whether choice i is (one of) the correct answers, for templates
+	if question.IsMultiSelect() {
+		return containsIndex(question.CorrectIndexes, i)
+	}
+	return question.Kind() == ChoiceQuestion && question.CorrectIndex == i
+}
+
+func (question Question) PartialCredit() bool {
+	return question.Scoring == PartialCredit
 }
 
 type PostQuestion struct { // for adding question
-	Title        string   `schema:"quiz"`
-	Question     string   `schema:"question"`
-	Answers      []string `schema:"answers"`
-	CorrectIndex int      `schema:"correct"`
+	Title          string   `schema:"quiz"`
+	Question       string   `schema:"question"`
+	Answers        []string `schema:"answers"`
+	CorrectIndex   int      `schema:"correct"`
+	CorrectIndexes []int    `schema:"corrects"`
+	Type           string   `schema:"type"`
+	Tolerance      float64  `schema:"tolerance"`
+	Scoring        string   `schema:"scoring"`
+	Passage        string   `schema:"passage"`
+	Criteria       []string `schema:"criteria"` // Essays only: the rubric, a row per entry in Criteria and Points
+	Points         []int    `schema:"points"`
+	Explanation    string   `schema:"explanation"`
+	Solution       string   `schema:"solution"`
+	Weight         int      `schema:"weight"`
+	Version        int      `schema:"version"` // Quiz version the admin was looking at
 }
 
 func (question PostQuestion) GetQuestion() Question {
+	// Leading and trailing spaces are trimmed, so "Paris " and "Paris" count as the same answer
+	answers := make([]string, len(question.Answers))
+	for i := 0; i < len(question.Answers); i++ {
+		answers[i] = strings.TrimSpace(question.Answers[i])
+	}
 	return Question{
-		Question:     question.Question,
-		Answers:      question.Answers,
-		CorrectIndex: question.CorrectIndex,
+		Id:             NewQuestionID(),
+		Question:       strings.TrimSpace(question.Question),
+		Answers:        answers,
+		CorrectIndex:   question.CorrectIndex,
+		CorrectIndexes: question.CorrectIndexes,
+		Type:           question.Type,
+		Tolerance:      question.Tolerance,
+		Scoring:        question.Scoring,
+		Passage:        question.Passage,
+		Rubric:         question.GetRubric(),
+		Explanation:    strings.TrimSpace(question.Explanation),
+		Solution:       strings.TrimSpace(question.Solution),
+		Weight:         question.Weight,
+	}
+}
+
+func (question PostQuestion) GetRubric() []Criterion {
+	rubric := []Criterion{}
+	for i := 0; i < len(question.Criteria) && i < len(question.Points); i++ {
+		rubric = append(rubric, Criterion{strings.TrimSpace(question.Criteria[i]), question.Points[i]})
+	}
+	return rubric
+}
+
+func (question PostQuestion) IsCorrect(i int) bool {
+	return question.GetQuestion().IsCorrect(i)
+}
+
+func (question Question) Validate() error {
+	// Checks a question before it is saved.  The error explains what is wrong in terms an admin can act on.
+	if strings.TrimSpace(question.Question) == "" {
+		return errors.New("the question text can't be empty")
+	} else if question.Weight < 0 || question.Weight > MaxWeight {
+		return fmt.Errorf("a question's weight is 0 to %d points, where 0 counts as 1", MaxWeight)
+	}
+	switch question.Kind() {
+	case ChoiceQuestion:
+		return question.validateChoices()
+	case GridInQuestion:
+		return question.validateGridIn()
+	case MultiSelectQuestion:
+		return question.validateMultiSelect()
+	case OrderingQuestion:
+		return question.validateOrdering()
+	case EssayQuestion:
+		return question.validateEssay()
 	}
+	return fmt.Errorf("unknown question type %q", question.Type)
+}
+
+func (question Question) validateChoices() error {
+	err := validateAnswerList(question.Answers, "answer choices")
+	if err != nil {
+		return err
+	}
+	if question.CorrectIndex < 0 || question.CorrectIndex >= len(question.Answers) {
+		return errors.New("pick which answer is correct")
+	}
+	return nil
+}
+
+func validateAnswerList(answers []string, what string) error {
+	// MinAnswers to MaxAnswers non-empty answers, no two the same
+	if len(answers) < MinAnswers || len(answers) > MaxAnswers {
+		return fmt.Errorf("a question needs %d to %d %s, not %d", MinAnswers, MaxAnswers, what, len(answers))
+	}
+	seen := map[string]int{}
+	for i := 0; i < len(answers); i++ {
+		answer := strings.ToLower(strings.TrimSpace(answers[i]))
+		if answer == "" {
+			return fmt.Errorf("answer %d is empty", i+1)
+		} else if j, ok := seen[answer]; ok {
+			return fmt.Errorf("answers %d and %d are the same", j+1, i+1)
+		}
+		seen[answer] = i
+	}
+	return nil
+}
+
+func (question Question) Credit(response []string) float32 {
+	// How much of the question a student's response (see Answers) earns, from 0 to 1.  Only multi-select and ordering
+	// questions with PartialCredit can earn part of it.  Essays earn nothing here; they are scored by hand (see Submission).
+	switch question.Kind() {
+	case MultiSelectQuestion:
+		return question.multiSelectCredit(response)
+	case OrderingQuestion:
+		return question.orderingCredit(response)
+	}
+	if question.Correct(singleResponse(response)) {
+		return 1
+	}
+	return 0
+}
+
+func (question Question) Correct(response string) bool {
+	// Whether a student's response is right: the Id of the correct choice, or for a grid-in a number close enough to an accepted one
+	switch question.Kind() {
+	case ChoiceQuestion:
+		return question.CorrectIndex >= 0 && question.CorrectIndex < len(question.Answers) &&
+			response == ChoiceID(question.CorrectIndex)
+	case GridInQuestion:
+		return question.acceptsNumber(response)
+	}
+	return false
 }
 
 type Quiz struct { // Quiz
-	Id        string     `schema:"id" bson:"_id"`
-	Title     string     `schema:"title" bson:"title"`
-	Questions []Question `schema:"questions" bson:"questions"`
+	Id         QuizID        `schema:"id" bson:"_id"`
+	Title      string        `schema:"title" bson:"title"`
+	Questions  []Question    `schema:"questions" bson:"questions"`
+	Subject    string        `schema:"subject" bson:"subject"`
+	Difficulty string        `schema:"difficulty" bson:"difficulty"` // "easy", "medium" or "hard"
+	Author     string        `schema:"-" bson:"author"`              // Username of the admin who created it
+	Published  bool          `schema:"published" bson:"published"`   // Only published quizzes are listed for students
+	Created    time.Time     `schema:"-" bson:"created"`
+	Attempts   int           `schema:"-" bson:"attempts"`      // Times graded; used to sort by popularity
+	Version    int           `schema:"version" bson:"version"` // Goes up by one with every change; writes must name the version they started from
+	Review     string        `schema:"review" bson:"review"`   // When students who have taken it may see answers and explanations: ReviewAfterAttempt (also when empty), ReviewAfterDue or ReviewNever
+	ReviewDate time.Time     `schema:"-" bson:"review_date"`   // The due date, for ReviewAfterDue
+	TimeLimit  int           `schema:"-" bson:"time_limit"`    // Minutes allowed, up to MaxTimeLimit; 0 for untimed
+	Policy     ScoringPolicy `schema:"-" bson:"policy"`        // How Grade scores answers
 }
 
 type QuizId struct { // For TmplQuiz
 	Question Question
 	Index    int
+	Choices  []Choice // In the order shown, which for ordering questions is shuffled
+	Response []string // The student's saved answer, when an attempt is resumed
 }
 
 type TmplQuiz struct { // Quiz for templates
-	Id        string
+	Id        QuizID
 	Title     string
 	Questions []QuizId
+	Sections  []TmplSection // Questions again, grouped by passage
+	Version   int           // Sent back with the answers so they're graded against the questions shown
+	Attempt   string        // The student's attempt in progress, also sent back; empty for students who aren't logged in
+	Resumed   bool          // Whether the attempt already has saved answers, filled into the form
+	Timed     bool          // Whether the attempt has a deadline
+	Remaining int           // And the seconds left until it
+	Section   string        // For a section of a full-length test, which one it is
+	Policy    ScoringPolicy // So students know how they will be scored before they guess
+}
+
+type TmplSection struct { // Consecutive questions about the same passage, or about none
+	Passage   *Passage
+	Questions []QuizId
 }
 
 type DbQuiz struct { // Quiz without ID
-	Title     string     `bson:"title"`
-	Questions []Question `bson:"questions"`
+	Title      string        `bson:"title"`
+	Questions  []Question    `bson:"questions"`
+	Subject    string        `bson:"subject"`
+	Difficulty string        `bson:"difficulty"`
+	Author     string        `bson:"author"`
+	Published  bool          `bson:"published"`
+	Created    time.Time     `bson:"created"`
+	Attempts   int           `bson:"attempts"`
+	Version    int           `bson:"version"`
+	Review     string        `bson:"review"`
+	ReviewDate time.Time     `bson:"review_date"`
+	TimeLimit  int           `bson:"time_limit"`
+	Policy     ScoringPolicy `bson:"policy"`
 }
 
-func (quiz Quiz) GetTmplQuiz() TmplQuiz {
+func (quiz Quiz) GetTmplQuiz(passages map[string]Passage, responses Answers) TmplQuiz {
+	// passages are the ones the questions refer to, from QuizPassages; responses are the answers saved so far, if any
 	result := *new(TmplQuiz)
 	result.Id = quiz.Id
 	result.Title = quiz.Title
+	result.Version = quiz.Version
+	result.Policy = quiz.Policy
 	for i := 0; i < len(quiz.Questions); i++ {
-		result.Questions = append(result.Questions, QuizId{quiz.Questions[i], i})
+		question := QuizId{quiz.Questions[i], i, quiz.Questions[i].Choices(), responses[quiz.Questions[i].Id]}
+		if question.Question.IsOrdering() {
+			question.Choices = shuffled(question.Choices) // Shown in their right order, the question would answer itself
+		}
+		result.Questions = append(result.Questions, question)
+		if i == 0 || question.Question.Passage != quiz.Questions[i-1].Passage {
+			section := TmplSection{}
+			if passage, ok := passages[question.Question.Passage]; ok {
+				section.Passage = &passage
+			}
+			result.Sections = append(result.Sections, section)
+		}
+		last := &result.Sections[len(result.Sections)-1]
+		last.Questions = append(last.Questions, question)
 	}
 	return result
 }
 
 func NewQuiz(title string) DbQuiz {
-	return DbQuiz{title, []Question{}}
-}
-
-func NewQuestion(question string, answers []string, correct int) Question {
-	return Question{Question: question, Answers: answers, CorrectIndex: correct}
+	return DbQuiz{Title: title, Questions: []Question{}, Published: true}
 }
 
-func RetrieveQuiz(target string) (Quiz, error) {
-	// Retrieves quiz with the given ID
-	db, err := mgo.Dial(dbstr)
-	defer db.Close()
-	if err != nil {
-		return *new(Quiz), err
+func (quiz Quiz) GetDbQuiz() DbQuiz {
+	return DbQuiz{
+		Title:      quiz.Title,
+		Questions:  quiz.Questions,
+		Subject:    quiz.Subject,
+		Difficulty: quiz.Difficulty,
+		Author:     quiz.Author,
+		Published:  quiz.Published,
+		Created:    quiz.Created,
+		Attempts:   quiz.Attempts,
+		Version:    quiz.Version,
+		Review:     quiz.Review,
+		ReviewDate: quiz.ReviewDate,
+		TimeLimit:  quiz.TimeLimit,
+		Policy:     quiz.Policy,
 	}
-	c := db.DB("server").C("quiz")
-	result := new(Quiz)
-	// var bsonTarget bson.ObjectId = bson.ObjectId(target)
-	/* if bson.IsObjectIdHex(target) {
-		bsonTarget = bson.ObjectIdHex(target)
-	} else {
-		bsonTarget = bson.ObjectId(target)
-	} */
-	if bson.IsObjectIdHex(target) {
-		target = bson.ObjectIdHex(target).String()
-	} else {
-		target = bson.ObjectId(target).String()
-	}
-	err = c.Find(bson.M{"_id": target}).One(&result)
-	if err != nil {
-		return *new(Quiz), err
-	}
-	(*result).Id = hex.EncodeToString([]byte((*result).Id))
-	return *result, nil
-}
-
-func UpdateQuiz(quiz Quiz) error {
-	db, err := mgo.Dial(dbstr)
-	defer db.Close()
-	if err != nil {
-		return err
-	}
-	c := db.DB("server").C("quiz")
-	err = c.Update(bson.M{"_id": bson.ObjectIdHex(quiz.Id).String()}, &quiz)
-	return err
 }
 
-func AddQuestion(id string, question Question) error {
-	quiz, err := RetrieveQuiz(id)
-	if err != nil {
-		return err
+func (quiz DbQuiz) GetQuiz(id QuizID) Quiz {
+	return Quiz{
+		Id:         id,
+		Title:      quiz.Title,
+		Questions:  quiz.Questions,
+		Subject:    quiz.Subject,
+		Difficulty: quiz.Difficulty,
+		Author:     quiz.Author,
+		Published:  quiz.Published,
+		Created:    quiz.Created,
+		Attempts:   quiz.Attempts,
+		Version:    quiz.Version,
+		Review:     quiz.Review,
+		ReviewDate: quiz.ReviewDate,
+		TimeLimit:  quiz.TimeLimit,
+		Policy:     quiz.Policy,
 	}
-	quiz.Questions = append(quiz.Questions, question)
-	return UpdateQuiz(quiz)
 }
 
-func InsertQuiz(quiz DbQuiz) error {
-	db, err := mgo.Dial(dbstr)
-	defer db.Close()
-	if err != nil {
-		return err
-	}
-	c := db.DB("server").C("quiz")
-	err = c.Insert(&quiz)
-	return err
-}
-
-func RetrieveQuizzes(title string) ([]Quiz, error) {
-	// Retrieves all quizzes from the database
-	db, err := mgo.Dial(dbstr)
-	defer db.Close()
-	if err != nil {
-		return []Quiz{}, err
-	}
-	c := db.DB("server").C("quiz")
-	var dbresult *mgo.Iter
-	if title != "" {
-		dbresult = c.Find(bson.M{"title": title}).Limit(10).Iter()
-	} else {
-		dbresult = c.Find(nil).Limit(10).Iter()
-	}
-	var result []Quiz
-	err = dbresult.All(&result)
-	if err != nil {
-		return []Quiz{}, err
-	}
-	return result, nil
+func NewQuestion(question string, answers []string, correct int) Question {
+	return Question{Id: NewQuestionID(), Question: question, Answers: answers, CorrectIndex: correct}
 }
 
-func (quiz Quiz) Grade() (float32, error) {
-	// Grades a quiz
-	db, err := mgo.Dial(dbstr)
-	defer db.Close()
-	if err != nil {
-		return 0.0, err
-	}
-	c := db.DB("server").C("quiz")
-	compare := new(Quiz)
-	err = c.Find(bson.M{"_id": bson.ObjectId(quiz.Id)}).One(&compare)
-	if err != nil {
-		return 0.0, err
-	}
-	var sum float32 = 0.0
-	var total float32 = 0.0
+func (quiz Quiz) FindQuestion(id string) int {
+	// Index of the question with the given ID, or -1
 	for i := 0; i < len(quiz.Questions); i++ {
-		if quiz.Questions[i].AnswerChosen == compare.Questions[i].Answers[compare.Questions[i].CorrectIndex] {
-			sum += 1.0
+		if quiz.Questions[i].Id == id {
+			return i
 		}
-		total += 1.0
-	}
-	if total == 0.0 {
-		return 0, nil
 	}
-	return sum * 100 / total, nil
+	return -1
 }
 
-func DeleteAccount(user User) error {
-	db, err := mgo.Dial(dbstr)
-	defer db.Close()
-	if err != nil {
-		return err
+func (quiz *Quiz) ReplaceQuestion(question Question) error {
+	// Swaps in question for the one with the same ID, keeping its place
+	i := quiz.FindQuestion(question.Id)
+	if i < 0 {
+		return ErrNotFound
 	}
-	c := db.DB("server").C("users")
-	err = c.Remove(bson.M{"username": user.Username})
-	return err
+	quiz.Questions[i] = question
+	return nil
 }
 
-func UpdateScore(user User) error {
-	db, err := mgo.Dial(dbstr)
-	defer db.Close()
-	if err != nil {
-		return err
-	}
-	c := db.DB("server").C("users")
-	result := new(User)
-	err = c.Find(bson.M{"username": user.Username}).One(&result)
-	if err != nil {
-		return err
-	}
-	if result.MaxScore < user.MaxScore {
-		err = c.Update(bson.M{"username": user.Username}, &user)
-		return err
-	} else {
-		return nil
+func (quiz *Quiz) RemoveQuestion(id string) error {
+	i := quiz.FindQuestion(id)
+	if i < 0 {
+		return ErrNotFound
 	}
+	quiz.Questions = append(quiz.Questions[:i:i], quiz.Questions[i+1:]...)
+	return nil
 }
 
-func CreateAccount(user User) error {
-	db, err := mgo.Dial(dbstr)
-	defer db.Close()
-	if err != nil {
-		return err
+func (quiz *Quiz) MoveQuestion(id string, by int) error {
+	// Moves a question by places (negative for earlier), stopping at either end
+	i := quiz.FindQuestion(id)
+	if i < 0 {
+		return ErrNotFound
 	}
-	c := db.DB("server").C("users")
-	result := new(User)
-	err = c.Find(bson.M{"username": user.Username}).One(&result)
-	if err != nil && err.Error() != "not found" {
-		return err
-	} else if err == nil {
-		return errors.New("user already exists")
-	} else {
-		password_bytestr, err := bcrypt.GenerateFromPassword([]byte(user.Password), cryptcost) // Note: excessive costs may cause unreasonable delays on the client-side while the password is hashed server-side.  15 is reasonable (3-5 seconds), 20 is excessive.
-		if err != nil {
-			return err
-		}
-		user.DbPassword = password_bytestr
-		err = c.Insert(&user)
-		return err
+	to := i + by
+	if to < 0 {
+		to = 0
+	} else if to >= len(quiz.Questions) {
+		to = len(quiz.Questions) - 1
+	}
+	question := quiz.Questions[i]
+	for ; i < to; i++ {
+		quiz.Questions[i] = quiz.Questions[i+1]
+	}
+	for ; i > to; i-- {
+		quiz.Questions[i] = quiz.Questions[i-1]
 	}
+	quiz.Questions[to] = question
+	return nil
 }
 
-func UpdateScoreUsername(username string, score float32) error {
-	user, err := GetUser(username)
-	if err != nil {
-		return err
-	}
-	user.MaxScore = score
-	return UpdateScore(user)
+func (quiz Quiz) Grade(answers Answers) float32 {
+	// Grades answers checked by CheckAnswers against quiz, the stored quiz (see GradedAgainst), under its Policy.
+	// Essays count for nothing; use Submit to keep them for grading by hand.
+	submission := quiz.Submit(answers)
+	return submission.total()
 }
 
-func GetUser(username string) (User, error) {
-	db, err := mgo.Dial(dbstr)
-	defer db.Close()
+func HashPassword(user User) (User, error) {
+	// Fills in DbPassword from the plaintext Password
+	password_bytestr, err := bcrypt.GenerateFromPassword([]byte(user.Password), cryptcost) // Note: excessive costs may cause unreasonable delays on the client-side while the password is hashed server-side.  15 is reasonable (3-5 seconds), 20 is excessive.
 	if err != nil {
-		return User{}, err
+		return user, err
 	}
-	c := db.DB("server").C("users")
-	result := new(User)
-	err = c.Find(bson.M{"username": username}).One(result)
-	return *result, err
+	user.DbPassword = password_bytestr
+	return user, nil
 }
 
-func CheckLogin(user User) (User, error) {
-	db, err := mgo.Dial(dbstr)
-	defer db.Close()
-	if err != nil {
-		return User{}, err
-	}
-	c := db.DB("server").C("users")
-	dbresult := new(User)
-	err = c.Find(bson.M{"username": user.Username}).One(dbresult)
-	if err != nil && err.Error() != "not found" {
-		return User{}, err
-	} else if err != nil {
-		return User{}, errors.New("login failed")
-	}
+func CheckPassword(user User, dbresult User) (User, error) {
+	// Compares a login attempt against the stored account.  Returns the user with their role on success.
 	if dbresult.Username == user.Username && bcrypt.CompareHashAndPassword(dbresult.DbPassword, []byte(user.Password)) == nil {
 		user.Role = dbresult.Role
 		return user, nil
 	} else {
-		return User{}, errors.New("login failed")
+		return User{}, ErrLoginFailed
 	}
 }
@@ -0,0 +1,69 @@
+package functions
+
+// Grid-in (student-produced response) questions, where the student types a number instead of picking a choice.
+// Like the SAT, a response may be an integer, a decimal or a fraction, and equivalent forms are graded the same:
+// "3/4", ".75" and "0.750" all match an accepted answer of 0.75.
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const gridInEpsilon = 1e-9 // Added to every question's Tolerance, so a response that differs from an accepted answer only by floating point rounding still matches when the Tolerance is 0
+
+func ParseNumber(s string) (float64, bool) {
+	// Reads an integer, decimal or fraction such as "-3/4".  Exponents, spaces inside the number and mixed numbers
+	// ("3 1/2") aren't accepted.
+	s = strings.TrimSpace(s)
+	if s == "" || strings.Trim(s, "0123456789./-") != "" {
+		return 0, false
+	}
+	parts := strings.Split(s, "/")
+	if len(parts) > 2 {
+		return 0, false
+	}
+	numerator, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return 0, false
+	}
+	if len(parts) == 1 {
+		return numerator, true
+	}
+	denominator, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil || denominator == 0 || strings.HasPrefix(parts[1], "-") {
+		return 0, false
+	}
+	return numerator / denominator, true
+}
+
+func (question Question) validateGridIn() error {
+	if len(question.Answers) < 1 || len(question.Answers) > MaxAnswers {
+		return fmt.Errorf("a grid-in question needs 1 to %d accepted answers, not %d", MaxAnswers, len(question.Answers))
+	}
+	for i := 0; i < len(question.Answers); i++ {
+		if _, ok := ParseNumber(question.Answers[i]); !ok {
+			return fmt.Errorf("accepted answer %d (%q) isn't a number, decimal or fraction", i+1, question.Answers[i])
+		}
+	}
+	if question.Tolerance < 0 || math.IsNaN(question.Tolerance) || math.IsInf(question.Tolerance, 0) {
+		return errors.New("the tolerance must be zero or a positive number")
+	}
+	return nil
+}
+
+func (question Question) acceptsNumber(response string) bool {
+	value, ok := ParseNumber(response)
+	if !ok {
+		return false
+	}
+	for i := 0; i < len(question.Answers); i++ {
+		accepted, ok := ParseNumber(question.Answers[i])
+		if ok && math.Abs(value-accepted) <= question.Tolerance+gridInEpsilon {
+			return true
+		}
+	}
+	return false
+}
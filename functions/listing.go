@@ -0,0 +1,129 @@
+package functions
+
+// Paging, sorting and filtering for quiz listings (/quizzes and /admin).
+// The query is read straight from the URL, so every backend gets it through Normalize first.
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+const DefaultPerPage = 10
+const MaxPerPage = 100
+
+type QuizQuery struct { // Which quizzes to list.  Empty filters match everything.
+	Title      string `schema:"title"`      // Exact title
+	Subject    string `schema:"subject"`    // Exact subject
+	Difficulty string `schema:"difficulty"` // Exact difficulty
+	Author     string `schema:"author"`     // Username of the creator
+	Published  string `schema:"published"`  // "yes", "no", or "" for both
+	Sort       string `schema:"sort"`       // "title", "created" (newest first) or "popular" (most attempts first)
+	Page       int    `schema:"page"`       // Starting at 1
+	PerPage    int    `schema:"per_page"`
+}
+
+func (query QuizQuery) Normalize() QuizQuery {
+	// Fills in defaults and clamps out-of-range values
+	if query.Sort != "created" && query.Sort != "popular" {
+		query.Sort = "title"
+	}
+	if query.Published != "yes" && query.Published != "no" {
+		query.Published = ""
+	}
+	if query.Page < 1 {
+		query.Page = 1
+	}
+	if query.PerPage < 1 {
+		query.PerPage = DefaultPerPage
+	} else if query.PerPage > MaxPerPage {
+		query.PerPage = MaxPerPage
+	}
+	return query
+}
+
+func (query QuizQuery) Skip() int {
+	return (query.Page - 1) * query.PerPage
+}
+
+func (query QuizQuery) Matches(quiz Quiz) bool {
+	// For backends that filter in Go rather than in the database
+	return (query.Title == "" || quiz.Title == query.Title) &&
+		(query.Subject == "" || quiz.Subject == query.Subject) &&
+		(query.Difficulty == "" || quiz.Difficulty == query.Difficulty) &&
+		(query.Author == "" || quiz.Author == query.Author) &&
+		(query.Published == "" || quiz.Published == (query.Published == "yes"))
+}
+
+type quizSorter struct { // sort.Interface over quizzes for a QuizQuery.Sort value
+	quizzes []Quiz
+	by      string
+}
+
+func (s quizSorter) Len() int      { return len(s.quizzes) }
+func (s quizSorter) Swap(i, j int) { s.quizzes[i], s.quizzes[j] = s.quizzes[j], s.quizzes[i] }
+func (s quizSorter) Less(i, j int) bool {
+	a, b := s.quizzes[i], s.quizzes[j]
+	switch s.by {
+	case "created":
+		return a.Created.After(b.Created)
+	case "popular":
+		if a.Attempts != b.Attempts {
+			return a.Attempts > b.Attempts
+		}
+	}
+	return a.Title < b.Title
+}
+
+func SortQuizzes(quizzes []Quiz, by string) {
+	// Stable, so ties keep insertion order like the databases do
+	sort.Stable(quizSorter{quizzes, by})
+}
+
+type QuizPage struct { // One page of a listing, for templates
+	Query   QuizQuery
+	Quizzes []Quiz
+	Total   int // Matching quizzes across all pages
+}
+
+func (page QuizPage) Pages() int {
+	if page.Total == 0 {
+		return 1
+	}
+	return (page.Total + page.Query.PerPage - 1) / page.Query.PerPage
+}
+
+func (page QuizPage) HasPrev() bool {
+	return page.Query.Page > 1
+}
+
+func (page QuizPage) HasNext() bool {
+	return page.Query.Page < page.Pages()
+}
+
+func (page QuizPage) Link(path string, n int) string {
+	// URL for page n of the same listing
+	values := url.Values{}
+	query := page.Query
+	for key, value := range map[string]string{
+		"title": query.Title, "subject": query.Subject, "difficulty": query.Difficulty,
+		"author": query.Author, "published": query.Published, "sort": query.Sort,
+	} {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+	if query.PerPage != DefaultPerPage {
+		values.Set("per_page", strconv.Itoa(query.PerPage))
+	}
+	values.Set("page", strconv.Itoa(n))
+	return path + "?" + values.Encode()
+}
+
+func (page QuizPage) PrevLink(path string) string {
+	return page.Link(path, page.Query.Page-1)
+}
+
+func (page QuizPage) NextLink(path string) string {
+	return page.Link(path, page.Query.Page+1)
+}
@@ -0,0 +1,680 @@
+package functions
+
+// In-memory backend for the Store interface.
+// Nothing is persisted: it is meant for tests and local development without a running mongod.
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type MemoryStore struct { // Store held entirely in process memory.  Safe for concurrent use.
+	mutex     sync.RWMutex
+	quizzes   map[QuizID]Quiz
+	order     []QuizID // Quiz IDs in insertion order, so listings come back like Mongo's natural order
+	users     map[string]User
+	index     *SearchIndex
+	revisions map[QuizID][]Revision // revisions[id][v-1] is version v
+	passages  map[string]Passage
+	submitted []Submission // Oldest first
+	attempts  []Attempt    // Oldest first
+	tests     map[string]Test
+	sittings  []Sitting          // Oldest first
+	scales    map[string][]Scale // scales[test][v-1] is version v
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		quizzes:   map[QuizID]Quiz{},
+		order:     []QuizID{},
+		users:     map[string]User{},
+		index:     NewSearchIndex(),
+		revisions: map[QuizID][]Revision{},
+		passages:  map[string]Passage{},
+		tests:     map[string]Test{},
+		scales:    map[string][]Scale{},
+	}
+}
+
+func copyQuiz(quiz Quiz) Quiz {
+	// Copies the question slices so callers can't modify stored quizzes through them
+	questions := make([]Question, len(quiz.Questions))
+	for i := 0; i < len(quiz.Questions); i++ {
+		questions[i] = quiz.Questions[i]
+		questions[i].Answers = append([]string{}, quiz.Questions[i].Answers...)
+		questions[i].CorrectIndexes = append([]int{}, quiz.Questions[i].CorrectIndexes...)
+		questions[i].Rubric = append([]Criterion{}, quiz.Questions[i].Rubric...)
+	}
+	quiz.Questions = questions
+	return quiz
+}
+
+func (store *MemoryStore) RetrieveQuiz(target QuizID) (Quiz, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	quiz, ok := store.quizzes[target]
+	if !ok {
+		return Quiz{}, ErrNotFound
+	}
+	return copyQuiz(quiz), nil
+}
+
+func (store *MemoryStore) RetrieveQuizzes(query QuizQuery) (QuizPage, error) {
+	query = query.Normalize()
+	store.mutex.RLock()
+	matches := []Quiz{}
+	for i := 0; i < len(store.order); i++ {
+		quiz := store.quizzes[store.order[i]]
+		if query.Matches(quiz) {
+			matches = append(matches, quiz)
+		}
+	}
+	store.mutex.RUnlock()
+	SortQuizzes(matches, query.Sort)
+	result := []Quiz{}
+	for i := query.Skip(); i < len(matches) && len(result) < query.PerPage; i++ {
+		result = append(result, copyQuiz(matches[i]))
+	}
+	return QuizPage{Query: query, Quizzes: result, Total: len(matches)}, nil
+}
+
+func (store *MemoryStore) InsertQuiz(quiz DbQuiz) (QuizID, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	id := NewQuizID()
+	quiz.Created = time.Now()
+	quiz.Attempts = 0
+	quiz.Version = 1
+	store.quizzes[id] = copyQuiz(quiz.GetQuiz(id))
+	store.order = append(store.order, id)
+	store.index.Update(store.quizzes[id])
+	store.snapshot(id)
+	return id, nil
+}
+
+func (store *MemoryStore) UpdateQuiz(quiz Quiz) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	old, ok := store.quizzes[quiz.Id]
+	if !ok {
+		return ErrNotFound
+	} else if old.Version != quiz.Version {
+		return ErrConflict
+	}
+	quiz.Version++
+	quiz.Author = old.Author
+	quiz.Created = old.Created
+	quiz.Attempts = old.Attempts
+	store.quizzes[quiz.Id] = copyQuiz(quiz)
+	store.index.Update(store.quizzes[quiz.Id])
+	store.snapshot(quiz.Id)
+	return nil
+}
+
+func (store *MemoryStore) CountAttempt(id QuizID, version int) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	quiz, ok := store.quizzes[id]
+	if !ok {
+		return ErrNotFound
+	}
+	quiz.Attempts++
+	store.quizzes[id] = quiz
+	if version > 0 && version <= len(store.revisions[id]) {
+		store.revisions[id][version-1].Attempts++
+	}
+	return nil
+}
+
+func (store *MemoryStore) AddQuestion(id QuizID, version int, question Question) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	quiz, ok := store.quizzes[id]
+	if !ok {
+		return ErrNotFound
+	} else if quiz.Version != version {
+		return ErrConflict
+	}
+	quiz.Version++
+	quiz.Questions = append(quiz.Questions, question)
+	store.quizzes[id] = copyQuiz(quiz)
+	store.index.Update(store.quizzes[id])
+	store.snapshot(id)
+	return nil
+}
+
+func (store *MemoryStore) DeleteQuiz(id QuizID, version int) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	quiz, ok := store.quizzes[id]
+	if !ok {
+		return ErrNotFound
+	} else if quiz.Version != version {
+		return ErrConflict
+	}
+	delete(store.quizzes, id)
+	graded := map[int]bool{} // Revisions attempts were graded against; the rest are blanked so revisions[id][v-1] stays version v
+	for i := 0; i < len(store.attempts); i++ {
+		if store.attempts[i].Quiz == id {
+			graded[store.attempts[i].Version] = true
+		}
+	}
+	for i := 0; i < len(store.revisions[id]); i++ {
+		if !graded[i+1] {
+			store.revisions[id][i] = Revision{}
+		}
+	}
+	for i := 0; i < len(store.order); i++ {
+		if store.order[i] == id {
+			store.order = append(store.order[:i:i], store.order[i+1:]...)
+			break
+		}
+	}
+	store.index.Remove(id)
+	return nil
+}
+
+func (store *MemoryStore) snapshot(id QuizID) {
+	// Saves the stored quiz as its newest revision.  The caller must hold the write lock.
+	store.revisions[id] = append(store.revisions[id], NewRevision(copyQuiz(store.quizzes[id])))
+}
+
+func (store *MemoryStore) RetrieveRevisions(id QuizID) ([]Revision, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	if _, ok := store.quizzes[id]; !ok {
+		return nil, ErrNotFound
+	}
+	result := []Revision{}
+	for i := 0; i < len(store.revisions[id]); i++ {
+		result = append(result, copyRevision(store.revisions[id][i]))
+	}
+	return result, nil
+}
+
+func (store *MemoryStore) RetrieveRevision(id QuizID, version int) (Revision, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	if version < 1 || version > len(store.revisions[id]) || store.revisions[id][version-1].Version != version {
+		return Revision{}, ErrNotFound
+	}
+	return copyRevision(store.revisions[id][version-1]), nil
+}
+
+func copyRevision(revision Revision) Revision {
+	revision.Questions = copyQuiz(Quiz{Questions: revision.Questions}).Questions
+	return revision
+}
+
+func (store *MemoryStore) InsertPassage(passage Passage) (string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	passage.Id = NewPassageID()
+	passage.Created = time.Now()
+	store.passages[passage.Id] = passage
+	return passage.Id, nil
+}
+
+func (store *MemoryStore) RetrievePassage(id string) (Passage, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	passage, ok := store.passages[id]
+	if !ok {
+		return Passage{}, ErrNotFound
+	}
+	return passage, nil
+}
+
+func (store *MemoryStore) RetrievePassages() ([]Passage, error) {
+	store.mutex.RLock()
+	result := []Passage{}
+	for _, passage := range store.passages {
+		result = append(result, passage)
+	}
+	store.mutex.RUnlock()
+	SortPassages(result)
+	return result, nil
+}
+
+func (store *MemoryStore) UpdatePassage(passage Passage) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	old, ok := store.passages[passage.Id]
+	if !ok {
+		return ErrNotFound
+	}
+	old.Title = passage.Title
+	old.Text = passage.Text
+	store.passages[passage.Id] = old
+	return nil
+}
+
+func (store *MemoryStore) InsertSubmission(submission Submission) (string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	submission.Id = NewSubmissionID()
+	submission.Created = time.Now()
+	submission.Updates = 0
+	store.submitted = append(store.submitted, copySubmission(submission))
+	return submission.Id, nil
+}
+
+func copySubmission(submission Submission) Submission {
+	essays := make([]EssayResponse, len(submission.Essays))
+	for i := 0; i < len(essays); i++ {
+		essays[i] = submission.Essays[i]
+		essays[i].Rubric = append([]Criterion{}, submission.Essays[i].Rubric...)
+		essays[i].Points = append([]int{}, submission.Essays[i].Points...)
+	}
+	submission.Essays = essays
+	return submission
+}
+
+func (store *MemoryStore) RetrieveSubmission(id string) (Submission, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	for i := 0; i < len(store.submitted); i++ {
+		if store.submitted[i].Id == id {
+			return copySubmission(store.submitted[i]), nil
+		}
+	}
+	return Submission{}, ErrNotFound
+}
+
+func (store *MemoryStore) RetrievePendingSubmissions() ([]Submission, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	result := []Submission{}
+	for i := 0; i < len(store.submitted); i++ {
+		if !store.submitted[i].Graded {
+			result = append(result, copySubmission(store.submitted[i]))
+		}
+	}
+	return result, nil
+}
+
+func (store *MemoryStore) UpdateSubmission(submission Submission) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for i := 0; i < len(store.submitted); i++ {
+		if store.submitted[i].Id != submission.Id {
+			continue
+		} else if store.submitted[i].Graded || store.submitted[i].Updates != submission.Updates {
+			return ErrConflict
+		}
+		submission.Created = store.submitted[i].Created
+		submission.Updates++
+		store.submitted[i] = copySubmission(submission)
+		return nil
+	}
+	return ErrNotFound
+}
+
+func (store *MemoryStore) SearchQuizzes(query SearchQuery) ([]SearchResult, error) {
+	return store.index.Search(query), nil
+}
+
+func (store *MemoryStore) CreateAccount(user User) error {
+	// Hashing happens before taking the lock; bcrypt is slow and would otherwise block every other request
+	hashed, err := HashPassword(user)
+	if err != nil {
+		return err
+	}
+	hashed.Password = ""
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if _, ok := store.users[user.Username]; ok {
+		return ErrUserExists
+	}
+	store.users[user.Username] = hashed
+	return nil
+}
+
+func (store *MemoryStore) CheckLogin(user User) (User, error) {
+	store.mutex.RLock()
+	dbresult, ok := store.users[user.Username]
+	store.mutex.RUnlock()
+	if !ok {
+		return User{}, ErrLoginFailed
+	}
+	return CheckPassword(user, dbresult)
+}
+
+func (store *MemoryStore) GetUser(username string) (User, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	user, ok := store.users[username]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+func (store *MemoryStore) DeleteAccount(user User) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if _, ok := store.users[user.Username]; !ok {
+		return ErrNotFound
+	}
+	delete(store.users, user.Username)
+	kept := []Attempt{}
+	for i := 0; i < len(store.attempts); i++ {
+		if store.attempts[i].Username != user.Username {
+			kept = append(kept, store.attempts[i])
+		}
+	}
+	store.attempts = kept
+	sittings := []Sitting{}
+	for i := 0; i < len(store.sittings); i++ {
+		if store.sittings[i].Username != user.Username {
+			sittings = append(sittings, store.sittings[i])
+		}
+	}
+	store.sittings = sittings
+	return nil
+}
+
+func (store *MemoryStore) InsertAttempt(attempt Attempt) (string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if _, ok := store.users[attempt.Username]; !ok {
+		return "", ErrNotFound
+	}
+	attempt.Id = NewAttemptID()
+	store.attempts = append(store.attempts, copyAttempt(attempt))
+	return attempt.Id, nil
+}
+
+func copyAttempt(attempt Attempt) Attempt {
+	responses := Answers{}
+	for id, response := range attempt.Responses {
+		responses[id] = append([]string{}, response...)
+	}
+	attempt.Responses = responses
+	return attempt
+}
+
+func (store *MemoryStore) userAttempts(username string) []Attempt {
+	// Most recently finished first, then those in progress.  The caller holds the lock.
+	result := []Attempt{}
+	for i := len(store.attempts) - 1; i >= 0; i-- {
+		if store.attempts[i].Username == username {
+			result = append(result, copyAttempt(store.attempts[i]))
+		}
+	}
+	sort.Stable(attemptSorter(result))
+	return result
+}
+
+type attemptSorter []Attempt // By Finished, newest first; attempts in progress have none, so they come last
+
+func (s attemptSorter) Len() int           { return len(s) }
+func (s attemptSorter) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s attemptSorter) Less(i, j int) bool { return s[i].Finished.After(s[j].Finished) }
+
+func (store *MemoryStore) RetrieveAttempt(id string) (Attempt, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	for i := 0; i < len(store.attempts); i++ {
+		if store.attempts[i].Id == id {
+			return copyAttempt(store.attempts[i]), nil
+		}
+	}
+	return Attempt{}, ErrNotFound
+}
+
+func (store *MemoryStore) RetrieveAttempts(username string) ([]Attempt, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	return store.userAttempts(username), nil
+}
+
+func (store *MemoryStore) RetrieveOpenAttempt(username string, quiz QuizID) (Attempt, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	for i := len(store.attempts) - 1; i >= 0; i-- {
+		attempt := store.attempts[i]
+		if attempt.Username == username && attempt.Quiz == quiz && attempt.Sitting == "" && attempt.InProgress() {
+			return copyAttempt(attempt), nil
+		}
+	}
+	return Attempt{}, ErrNotFound
+}
+
+func (store *MemoryStore) SaveResponse(id string, question string, response []string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for i := 0; i < len(store.attempts); i++ {
+		stored := &store.attempts[i]
+		if stored.Id != id {
+			continue
+		} else if !stored.InProgress() {
+			return ErrConflict
+		} else if len(response) == 0 {
+			delete(stored.Responses, question)
+		} else {
+			stored.Responses[question] = append([]string{}, response...)
+		}
+		return nil
+	}
+	return ErrNotFound
+}
+
+func (store *MemoryStore) UpdateAttempt(attempt Attempt) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for i := 0; i < len(store.attempts); i++ {
+		stored := &store.attempts[i]
+		if stored.Id != attempt.Id {
+			continue
+		} else if !stored.InProgress() {
+			return ErrConflict
+		}
+		updated := copyAttempt(attempt)
+		stored.Responses = updated.Responses
+		stored.Finished = attempt.Finished
+		stored.Graded = attempt.Graded
+		stored.Score = attempt.Score
+		stored.Submission = attempt.Submission
+		stored.Policy = attempt.Policy
+		return nil
+	}
+	return ErrNotFound
+}
+
+func (store *MemoryStore) FinishAttempt(submission string, score float32) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for i := 0; i < len(store.attempts); i++ {
+		if submission != "" && store.attempts[i].Submission == submission {
+			store.attempts[i].Graded = true
+			store.attempts[i].Score = score
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func copyTest(test Test) Test {
+	test.Sections = append([]TestSection{}, test.Sections...)
+	return test
+}
+
+func (store *MemoryStore) InsertTest(test Test) (string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	test.Id = NewTestID()
+	test.Created = time.Now()
+	test.Version = 1
+	store.tests[test.Id] = copyTest(test)
+	return test.Id, nil
+}
+
+func (store *MemoryStore) RetrieveTest(id string) (Test, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	test, ok := store.tests[id]
+	if !ok {
+		return Test{}, ErrNotFound
+	}
+	return copyTest(test), nil
+}
+
+func (store *MemoryStore) RetrieveTests() ([]Test, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	result := []Test{}
+	for _, test := range store.tests {
+		result = append(result, copyTest(test))
+	}
+	sort.Sort(testSorter(result))
+	return result, nil
+}
+
+type testSorter []Test // By title, then Id so equal titles keep an order
+
+func (s testSorter) Len() int      { return len(s) }
+func (s testSorter) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s testSorter) Less(i, j int) bool {
+	return s[i].Title < s[j].Title || (s[i].Title == s[j].Title && s[i].Id < s[j].Id)
+}
+
+func (store *MemoryStore) UpdateTest(test Test) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	old, ok := store.tests[test.Id]
+	if !ok {
+		return ErrNotFound
+	} else if old.Version != test.Version {
+		return ErrConflict
+	}
+	old.Title = test.Title
+	old.Sections = test.Sections
+	old.Published = test.Published
+	old.Version++
+	store.tests[test.Id] = copyTest(old)
+	return nil
+}
+
+func copySitting(sitting Sitting) Sitting {
+	sitting.Sections = append([]TestSection{}, sitting.Sections...)
+	sitting.Attempts = append([]string{}, sitting.Attempts...)
+	return sitting
+}
+
+func (store *MemoryStore) InsertSitting(sitting Sitting) (string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if _, ok := store.users[sitting.Username]; !ok {
+		return "", ErrNotFound
+	}
+	sitting.Id = NewSittingID()
+	store.sittings = append(store.sittings, copySitting(sitting))
+	return sitting.Id, nil
+}
+
+func (store *MemoryStore) RetrieveSitting(id string) (Sitting, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	for i := 0; i < len(store.sittings); i++ {
+		if store.sittings[i].Id == id {
+			return copySitting(store.sittings[i]), nil
+		}
+	}
+	return Sitting{}, ErrNotFound
+}
+
+func (store *MemoryStore) RetrieveSittings(username string) ([]Sitting, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	result := []Sitting{}
+	for i := len(store.sittings) - 1; i >= 0; i-- {
+		if store.sittings[i].Username == username {
+			result = append(result, copySitting(store.sittings[i]))
+		}
+	}
+	return result, nil
+}
+
+func (store *MemoryStore) UpdateSitting(sitting Sitting, current int) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for i := 0; i < len(store.sittings); i++ {
+		stored := &store.sittings[i]
+		if stored.Id != sitting.Id {
+			continue
+		} else if stored.Current != current {
+			return ErrConflict
+		}
+		stored.Attempts = append([]string{}, sitting.Attempts...)
+		stored.Current = sitting.Current
+		stored.Finished = sitting.Finished
+		stored.Scale = sitting.Scale
+		return nil
+	}
+	return ErrNotFound
+}
+
+func (store *MemoryStore) BeginSection(sitting Sitting, attempt string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for i := 0; i < len(store.sittings); i++ {
+		stored := &store.sittings[i]
+		if stored.Id != sitting.Id {
+			continue
+		} else if stored.Current != sitting.Current || len(stored.Attempts) != stored.Current {
+			return ErrConflict
+		}
+		stored.Attempts = append(append([]string{}, stored.Attempts...), attempt)
+		return nil
+	}
+	return ErrNotFound
+}
+
+func copyScale(scale Scale) Scale {
+	tables := make([]Conversion, len(scale.Tables))
+	for i, table := range scale.Tables {
+		table.Sections = append([]int{}, table.Sections...)
+		table.Scaled = append([]int{}, table.Scaled...)
+		tables[i] = table
+	}
+	scale.Tables = tables
+	return scale
+}
+
+func (store *MemoryStore) InsertScale(scale Scale) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if _, ok := store.tests[scale.Test]; !ok {
+		return ErrNotFound
+	} else if scale.Version != len(store.scales[scale.Test])+1 {
+		return ErrConflict
+	}
+	scale.Created = time.Now()
+	store.scales[scale.Test] = append(store.scales[scale.Test], copyScale(scale))
+	return nil
+}
+
+func (store *MemoryStore) RetrieveScale(test string, version int) (Scale, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	scales := store.scales[test]
+	if version == 0 {
+		version = len(scales)
+	}
+	if version < 1 || version > len(scales) {
+		return Scale{}, ErrNotFound
+	}
+	return copyScale(scales[version-1]), nil
+}
+
+func (store *MemoryStore) RetrieveScales(test string) ([]Scale, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	result := []Scale{}
+	for _, scale := range store.scales[test] {
+		result = append(result, copyScale(scale))
+	}
+	return result, nil
+}
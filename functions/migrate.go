@@ -0,0 +1,636 @@
+package functions
+
+// Versioned migrations for the "server" MongoDB database.
+// The version the data is at is kept in server.schema; Migrate walks it up or down one migration at a time.
+
+import (
+	"fmt"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"io"
+	"strings"
+	"time"
+)
+
+type Migration struct { // One reversible change to the data
+	Description string
+	Up          func(m *Migrator) error
+	Down        func(m *Migrator) error
+}
+
+type Migrator struct { // Passed to each migration step
+	DB     *mgo.Database
+	DryRun bool // If set, steps must only report what they would do
+	Out    io.Writer
+}
+
+func (m *Migrator) Logf(format string, args ...interface{}) {
+	if m.DryRun {
+		format = "[dry run] " + format
+	}
+	fmt.Fprintf(m.Out, format+"\n", args...)
+}
+
+// Migration i takes the data from version i to version i+1.  Only ever append to this list.
+var mongoMigrations = []Migration{
+	{"store every quiz _id as an ObjectId", normalizeQuizIds, denormalizeQuizIds},
+	{"unique index on users.username", addUsernameIndex, dropUsernameIndex},
+	{"quiz listing fields and indexes", addListingFields, removeListingFields},
+	{"text index for quiz search", addSearchIndex, dropSearchIndex},
+	{"quiz version numbers", addQuizVersions, removeQuizVersions},
+	{"quiz revision history", addRevisions, dropRevisions},
+	{"stable question IDs", addQuestionIds, removeQuestionIds},
+	{"index for the essay grading queue", addGradingIndex, dropGradingIndex},
+	{"quiz attempts, starting from each user's best score", addAttempts, dropAttempts},
+	{"the quiz's subject on each attempt", addAttemptSubjects, removeAttemptSubjects},
+	{"index for students' test sittings", addSittingIndex, dropSittingIndex},
+	{"unique index on each test's conversion table versions", addScaleIndex, dropScaleIndex},
+	{"submission update counts", addSubmissionUpdates, removeSubmissionUpdates},
+}
+
+func LatestSchemaVersion() int {
+	return len(mongoMigrations)
+}
+
+type schemaVersion struct { // Document in server.schema
+	Id      string    `bson:"_id"`
+	Version int       `bson:"version"`
+	Updated time.Time `bson:"updated"`
+}
+
+func (store *MongoStore) SchemaVersion() (int, error) {
+	// Version the data is at.  A database that has never been migrated is at version 0.
+	db := store.copy()
+	defer db.Close()
+	result := schemaVersion{}
+	err := db.DB("server").C("schema").FindId("server").One(&result)
+	if err == mgo.ErrNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, mongoError(err)
+	}
+	return result.Version, nil
+}
+
+func (store *MongoStore) Migrate(target int, dryRun bool, out io.Writer) error {
+	// Applies Up (or Down) steps until the data is at the target version, recording the version after each step
+	if target < 0 || target > len(mongoMigrations) {
+		return fmt.Errorf("no schema version %d (latest is %d)", target, len(mongoMigrations))
+	}
+	current, err := store.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	db := store.copy()
+	defer db.Close()
+	m := &Migrator{DB: db.DB("server"), DryRun: dryRun, Out: out}
+	if current == target {
+		m.Logf("schema is at version %d, nothing to do", current)
+		return nil
+	} else if dryRun {
+		m.Logf("each step reports on the data as it is now, not as the steps before it would leave it")
+	}
+	for current != target {
+		next := current + 1
+		step := mongoMigrations[current].Up
+		description := mongoMigrations[current].Description
+		if target < current {
+			next = current - 1
+			step = mongoMigrations[next].Down
+			description = "undo " + mongoMigrations[next].Description
+		}
+		m.Logf("version %d -> %d: %s", current, next, description)
+		err = step(m)
+		if err != nil {
+			return fmt.Errorf("migrating to version %d: %v", next, err)
+		}
+		if !dryRun {
+			_, err = m.DB.C("schema").UpsertId("server", schemaVersion{"server", next, time.Now()})
+			if err != nil {
+				return mongoError(err)
+			}
+		}
+		current = next
+	}
+	return nil
+}
+
+/* MIGRATIONS */
+
+func legacyQuizId(id interface{}) (bson.ObjectId, bool) {
+	// Recovers the ObjectId from the forms quiz IDs have been stored in: the ObjectId.String() text,
+	// a hex string, or the 12 raw bytes as a string
+	s, ok := id.(string)
+	if !ok {
+		return "", false
+	}
+	if strings.HasPrefix(s, `ObjectIdHex("`) && strings.HasSuffix(s, `")`) {
+		s = s[len(`ObjectIdHex("`) : len(s)-len(`")`)]
+	}
+	if bson.IsObjectIdHex(s) {
+		return bson.ObjectIdHex(s), true
+	} else if len(s) == 12 {
+		return bson.ObjectId(s), true
+	}
+	return "", false
+}
+
+func moveDocument(m *Migrator, c *mgo.Collection, doc bson.M, newId interface{}) error {
+	// _id can't be updated in place, so the document is inserted under the new ID and the old one removed
+	oldId := doc["_id"]
+	m.Logf("%s: %#v -> %#v", c.Name, oldId, newId)
+	if m.DryRun {
+		return nil
+	}
+	doc["_id"] = newId
+	err := c.Insert(doc)
+	if mgo.IsDup(err) {
+		m.Logf("%s: %#v already exists, leaving %#v for manual cleanup", c.Name, newId, oldId)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return c.RemoveId(oldId)
+}
+
+func normalizeQuizIds(m *Migrator) error {
+	c := m.DB.C("quiz")
+	var docs []bson.M
+	err := c.Find(bson.M{"_id": bson.M{"$not": bson.M{"$type": 7}}}).All(&docs) // 7 is the BSON ObjectId type
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(docs); i++ {
+		id, ok := legacyQuizId(docs[i]["_id"])
+		if !ok {
+			m.Logf("quiz: can't make an ObjectId from %#v, skipping", docs[i]["_id"])
+			continue
+		}
+		err = moveDocument(m, c, docs[i], id)
+		if err != nil {
+			return err
+		}
+	}
+	m.Logf("quiz: %d documents with legacy IDs", len(docs))
+	return nil
+}
+
+// Later steps only touch quizzes whose _id is an ObjectId.  Any other is one normalizeQuizIds couldn't convert, or in
+// a dry run one it would have moved, and is reported instead.
+var objectIdType = bson.M{"$type": 7}
+
+func skipLegacyQuizzes(m *Migrator) error {
+	n, err := m.DB.C("quiz").Find(bson.M{"_id": bson.M{"$not": objectIdType}}).Count()
+	if err == nil && n > 0 {
+		m.Logf("quiz: skipping %d documents whose _id isn't an ObjectId", n)
+	}
+	return err
+}
+
+func denormalizeQuizIds(m *Migrator) error {
+	// Back to the ObjectId.String() text that RetrieveQuiz used to look up
+	c := m.DB.C("quiz")
+	var docs []bson.M
+	err := c.Find(bson.M{"_id": bson.M{"$type": 7}}).All(&docs)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(docs); i++ {
+		err = moveDocument(m, c, docs[i], docs[i]["_id"].(bson.ObjectId).String())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addUsernameIndex(m *Migrator) error {
+	// CreateAccount checks for an existing user before inserting, which two requests can both pass
+	var dups []bson.M
+	err := m.DB.C("users").Pipe([]bson.M{
+		{"$group": bson.M{"_id": "$username", "count": bson.M{"$sum": 1}}},
+		{"$match": bson.M{"count": bson.M{"$gt": 1}}},
+	}).All(&dups)
+	if err != nil {
+		return err
+	}
+	if len(dups) > 0 {
+		for i := 0; i < len(dups); i++ {
+			m.Logf("users: username %v is used by %v accounts", dups[i]["_id"], dups[i]["count"])
+		}
+		return fmt.Errorf("duplicate usernames must be removed before the index can be built")
+	}
+	m.Logf("users: creating unique index on username")
+	if m.DryRun {
+		return nil
+	}
+	return m.DB.C("users").EnsureIndex(mgo.Index{Key: []string{"username"}, Unique: true, Name: "username_unique"})
+}
+
+func dropUsernameIndex(m *Migrator) error {
+	m.Logf("users: dropping unique index on username")
+	if m.DryRun {
+		return nil
+	}
+	return m.DB.C("users").DropIndexName("username_unique")
+}
+
+var listingIndexes = []mgo.Index{
+	{Key: []string{"title"}, Name: "listing_title"},
+	{Key: []string{"-created"}, Name: "listing_created"},
+	{Key: []string{"-attempts", "title"}, Name: "listing_popular"},
+}
+
+func addListingFields(m *Migrator) error {
+	// Quizzes from before listings could be filtered: published, no subject/difficulty/author, created when their ObjectId was
+	c := m.DB.C("quiz")
+	var docs []struct {
+		Id bson.ObjectId `bson:"_id"`
+	}
+	err := c.Find(bson.M{"created": bson.M{"$exists": false}, "_id": objectIdType}).Select(bson.M{"_id": 1}).All(&docs)
+	if err == nil {
+		err = skipLegacyQuizzes(m)
+	}
+	if err != nil {
+		return err
+	}
+	m.Logf("quiz: %d documents without listing fields", len(docs))
+	for i := 0; i < len(docs) && !m.DryRun; i++ {
+		err = c.UpdateId(docs[i].Id, bson.M{"$set": bson.M{
+			"subject": "", "difficulty": "", "author": "", "published": true, "attempts": 0,
+			"created": docs[i].Id.Time(),
+		}})
+		if err != nil {
+			return err
+		}
+	}
+	for i := 0; i < len(listingIndexes); i++ {
+		m.Logf("quiz: creating index %s", listingIndexes[i].Name)
+		if !m.DryRun {
+			err = c.EnsureIndex(listingIndexes[i])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func removeListingFields(m *Migrator) error {
+	c := m.DB.C("quiz")
+	for i := 0; i < len(listingIndexes); i++ {
+		m.Logf("quiz: dropping index %s", listingIndexes[i].Name)
+		if !m.DryRun {
+			err := c.DropIndexName(listingIndexes[i].Name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	m.Logf("quiz: removing listing fields")
+	if m.DryRun {
+		return nil
+	}
+	_, err := c.UpdateAll(nil, bson.M{"$unset": bson.M{
+		"subject": "", "difficulty": "", "author": "", "published": "", "attempts": "", "created": "",
+	}})
+	return err
+}
+
+func addSearchIndex(m *Migrator) error {
+	// Weighted like SearchIndex: titles, then questions, then answers
+	m.Logf("quiz: creating text index on title, questions.question and questions.answers")
+	if m.DryRun {
+		return nil
+	}
+	return m.DB.C("quiz").EnsureIndex(mgo.Index{
+		Key:     []string{"$text:title", "$text:questions.question", "$text:questions.answers"},
+		Name:    "quiz_search",
+		Weights: map[string]int{"title": 3, "questions.question": 2, "questions.answers": 1},
+	})
+}
+
+func dropSearchIndex(m *Migrator) error {
+	m.Logf("quiz: dropping text index")
+	if m.DryRun {
+		return nil
+	}
+	return m.DB.C("quiz").DropIndexName("quiz_search")
+}
+
+func addQuizVersions(m *Migrator) error {
+	// Existing quizzes start at version 1, the same as newly inserted ones
+	c := m.DB.C("quiz")
+	filter := bson.M{"version": bson.M{"$exists": false}}
+	n, err := c.Find(filter).Count()
+	if err != nil {
+		return err
+	}
+	m.Logf("quiz: setting version 1 on %d documents", n)
+	if m.DryRun {
+		return nil
+	}
+	_, err = c.UpdateAll(filter, bson.M{"$set": bson.M{"version": 1}})
+	return err
+}
+
+func removeQuizVersions(m *Migrator) error {
+	m.Logf("quiz: removing version numbers")
+	if m.DryRun {
+		return nil
+	}
+	_, err := m.DB.C("quiz").UpdateAll(nil, bson.M{"$unset": bson.M{"version": ""}})
+	return err
+}
+
+func addRevisions(m *Migrator) error {
+	// Each quiz starts its history with its current version
+	c := m.DB.C("quiz_revisions")
+	m.Logf("quiz_revisions: creating unique index on quiz and version")
+	if !m.DryRun {
+		err := c.EnsureIndex(mgo.Index{Key: []string{"quiz", "version"}, Unique: true, Name: "revision_unique"})
+		if err != nil {
+			return err
+		}
+	}
+	var quizzes []Quiz
+	err := m.DB.C("quiz").Find(bson.M{"_id": objectIdType}).All(&quizzes)
+	if err == nil {
+		err = skipLegacyQuizzes(m)
+	}
+	if err != nil {
+		return err
+	}
+	count := 0
+	for i := 0; i < len(quizzes); i++ {
+		n, err := c.Find(bson.M{"quiz": quizzes[i].Id.ObjectId(), "version": quizzes[i].Version}).Count()
+		if err != nil {
+			return err
+		} else if n > 0 {
+			continue
+		}
+		count++
+		if !m.DryRun {
+			revision := NewRevision(quizzes[i])
+			revision.Created = quizzes[i].Created
+			revision.Attempts = quizzes[i].Attempts
+			err = c.Insert(revision)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	m.Logf("quiz_revisions: saving the current version of %d quizzes", count)
+	return nil
+}
+
+func dropRevisions(m *Migrator) error {
+	m.Logf("quiz_revisions: dropping collection")
+	if m.DryRun {
+		return nil
+	}
+	return m.DB.C("quiz_revisions").DropCollection()
+}
+
+func addQuestionIds(m *Migrator) error {
+	// Gives every question without an _id a new one.  A question in a revision shares the ID of the question
+	// at the same place in the quiz if its text is unchanged.
+	var quizzes []Quiz
+	err := m.DB.C("quiz").Find(bson.M{"_id": objectIdType}).All(&quizzes)
+	if err == nil {
+		err = skipLegacyQuizzes(m)
+	}
+	if err != nil {
+		return err
+	}
+	count := 0
+	for i := 0; i < len(quizzes); i++ {
+		changed := false
+		for j := 0; j < len(quizzes[i].Questions); j++ {
+			if quizzes[i].Questions[j].Id == "" {
+				quizzes[i].Questions[j].Id = NewQuestionID()
+				changed = true
+				count++
+			}
+		}
+		if changed && !m.DryRun {
+			err = m.DB.C("quiz").UpdateId(quizzes[i].Id.ObjectId(), bson.M{"$set": bson.M{"questions": quizzes[i].Questions}})
+			if err != nil {
+				return err
+			}
+		}
+		var revisions []Revision
+		err = m.DB.C("quiz_revisions").Find(bson.M{"quiz": quizzes[i].Id.ObjectId()}).All(&revisions)
+		if err != nil {
+			return err
+		}
+		for j := 0; j < len(revisions); j++ {
+			changed = false
+			for k := 0; k < len(revisions[j].Questions); k++ {
+				question := &revisions[j].Questions[k]
+				if question.Id != "" {
+					continue
+				} else if k < len(quizzes[i].Questions) && quizzes[i].Questions[k].Question == question.Question {
+					question.Id = quizzes[i].Questions[k].Id
+				} else {
+					question.Id = NewQuestionID()
+				}
+				changed = true
+				count++
+			}
+			if changed && !m.DryRun {
+				err = m.DB.C("quiz_revisions").Update(bson.M{"quiz": quizzes[i].Id.ObjectId(), "version": revisions[j].Version},
+					bson.M{"$set": bson.M{"questions": revisions[j].Questions}})
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	m.Logf("quiz, quiz_revisions: %d questions given IDs", count)
+	return nil
+}
+
+func removeQuestionIds(m *Migrator) error {
+	// The IDs are harmless to older versions, which never read them
+	m.Logf("quiz: leaving question IDs in place")
+	return nil
+}
+
+func addGradingIndex(m *Migrator) error {
+	// RetrievePendingSubmissions lists ungraded submissions oldest first
+	m.Logf("submissions: creating index on graded, created")
+	if m.DryRun {
+		return nil
+	}
+	return m.DB.C("submissions").EnsureIndex(mgo.Index{Key: []string{"graded", "created"}, Name: "grading_queue"})
+}
+
+func dropGradingIndex(m *Migrator) error {
+	m.Logf("submissions: dropping index grading_queue")
+	if m.DryRun {
+		return nil
+	}
+	return m.DB.C("submissions").DropIndexName("grading_queue")
+}
+
+func addAttempts(m *Migrator) error {
+	// Users' best scores were kept on their accounts; each becomes an attempt with no quiz
+	c := m.DB.C("attempts")
+	m.Logf("attempts: creating indexes on username, finished and on submission")
+	if !m.DryRun {
+		err := c.EnsureIndex(mgo.Index{Key: []string{"username", "-finished"}, Name: "user_attempts"})
+		if err == nil {
+			err = c.EnsureIndex(mgo.Index{Key: []string{"submission"}, Name: "attempt_submission"})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	var users []bson.M
+	err := m.DB.C("users").Find(bson.M{"score": bson.M{"$gt": 0}}).All(&users)
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		username, _ := user["username"].(string)
+		score, _ := user["score"].(float64)
+		m.Logf("attempts: keeping %s's best score of %.1f%%", username, score)
+		if !m.DryRun {
+			err = c.Insert(Attempt{Id: NewAttemptID(), Username: username, Graded: true, Score: float32(score), Responses: Answers{}})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	m.Logf("users: removing score")
+	if m.DryRun {
+		return nil
+	}
+	_, err = m.DB.C("users").UpdateAll(nil, bson.M{"$unset": bson.M{"score": ""}})
+	return err
+}
+
+func dropAttempts(m *Migrator) error {
+	// Puts each user's best graded score back on their account
+	var users []User
+	err := m.DB.C("users").Find(nil).All(&users)
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		best := Attempt{}
+		err = m.DB.C("attempts").Find(bson.M{"username": user.Username, "graded": true}).Sort("-score").One(&best)
+		if err != nil && err != mgo.ErrNotFound {
+			return err
+		}
+		m.Logf("users: setting %s's score to %.1f%%", user.Username, best.Score)
+		if !m.DryRun {
+			err = m.DB.C("users").Update(bson.M{"username": user.Username}, bson.M{"$set": bson.M{"score": best.Score}})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	m.Logf("attempts: dropping collection")
+	if m.DryRun {
+		return nil
+	}
+	return m.DB.C("attempts").DropCollection()
+}
+
+func addAttemptSubjects(m *Migrator) error {
+	// Each attempt takes the subject of the revision it was graded against
+	var attempts []Attempt
+	err := m.DB.C("attempts").Find(bson.M{"quiz": objectIdType}).All(&attempts)
+	if err != nil {
+		return err
+	}
+	count := 0
+	for _, attempt := range attempts {
+		revision := Revision{}
+		err = m.DB.C("quiz_revisions").Find(bson.M{"quiz": attempt.Quiz.ObjectId(), "version": attempt.Version}).One(&revision)
+		if err != nil && err != mgo.ErrNotFound {
+			return err
+		} else if err == mgo.ErrNotFound || revision.Subject == "" {
+			continue
+		}
+		count++
+		if !m.DryRun {
+			err = m.DB.C("attempts").UpdateId(attempt.Id, bson.M{"$set": bson.M{"subject": revision.Subject}})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	m.Logf("attempts: set the subject of %d attempts", count)
+	return nil
+}
+
+func removeAttemptSubjects(m *Migrator) error {
+	m.Logf("attempts: removing subject")
+	if m.DryRun {
+		return nil
+	}
+	_, err := m.DB.C("attempts").UpdateAll(nil, bson.M{"$unset": bson.M{"subject": ""}})
+	return err
+}
+
+func addSittingIndex(m *Migrator) error {
+	// RetrieveSittings lists a student's sittings newest first.  The tests collection is small and needs no index.
+	m.Logf("sittings: creating index on username, started")
+	if m.DryRun {
+		return nil
+	}
+	return m.DB.C("sittings").EnsureIndex(mgo.Index{Key: []string{"username", "-started"}, Name: "user_sittings"})
+}
+
+func dropSittingIndex(m *Migrator) error {
+	m.Logf("sittings: dropping index user_sittings")
+	if m.DryRun {
+		return nil
+	}
+	return m.DB.C("sittings").DropIndexName("user_sittings")
+}
+
+func addScaleIndex(m *Migrator) error {
+	// InsertScale relies on it to turn two admins saving the same version at once into ErrConflict
+	m.Logf("scales: creating unique index on test and version")
+	if m.DryRun {
+		return nil
+	}
+	return m.DB.C("scales").EnsureIndex(mgo.Index{Key: []string{"test", "version"}, Unique: true, Name: "scale_unique"})
+}
+
+func dropScaleIndex(m *Migrator) error {
+	m.Logf("scales: dropping index scale_unique")
+	if m.DryRun {
+		return nil
+	}
+	return m.DB.C("scales").DropIndexName("scale_unique")
+}
+
+func addSubmissionUpdates(m *Migrator) error {
+	// Existing submissions count as never updated, the same as newly inserted ones
+	c := m.DB.C("submissions")
+	filter := bson.M{"updates": bson.M{"$exists": false}}
+	n, err := c.Find(filter).Count()
+	if err != nil {
+		return err
+	}
+	m.Logf("submissions: setting updates 0 on %d documents", n)
+	if m.DryRun {
+		return nil
+	}
+	_, err = c.UpdateAll(filter, bson.M{"$set": bson.M{"updates": 0}})
+	return err
+}
+
+func removeSubmissionUpdates(m *Migrator) error {
+	m.Logf("submissions: removing update counts")
+	if m.DryRun {
+		return nil
+	}
+	_, err := m.DB.C("submissions").UpdateAll(nil, bson.M{"$unset": bson.M{"updates": ""}})
+	return err
+}
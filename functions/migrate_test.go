@@ -0,0 +1,71 @@
+package functions
+
+// Migrations need a MongoDB, so these are skipped unless SATME_TEST_MONGO is set (see store_test.go).
+
+import (
+	"bytes"
+	"gopkg.in/mgo.v2/bson"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMigrateLegacyIds(t *testing.T) {
+	// A quiz saved under its ObjectId's text and one under an ID that was never an ObjectId.  A dry run reports both
+	// without touching anything; the real run moves the first and leaves the second for manual cleanup.
+	addr := os.Getenv("SATME_TEST_MONGO")
+	if addr == "" {
+		t.Skip("SATME_TEST_MONGO isn't set")
+	}
+	store, err := NewMongoStore(DefaultMongoConfig(addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	db := store.copy()
+	defer db.Close()
+	if err = db.DB("server").DropDatabase(); err != nil {
+		t.Fatal(err)
+	}
+	quizzes := db.DB("server").C("quiz")
+	oid := bson.NewObjectId()
+	err = quizzes.Insert(bson.M{"_id": oid.String(), "title": "Algebra", "questions": []bson.M{
+		{"question": "1+1", "answers": []string{"1", "2"}, "correct": 1},
+	}})
+	if err == nil {
+		err = quizzes.Insert(bson.M{"_id": "not an id", "title": "Broken"})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err = store.Migrate(LatestSchemaVersion(), true, &out); err != nil {
+		t.Fatalf("dry run: %v\n%s", err, out.String())
+	}
+	if !strings.Contains(out.String(), "skipping 2 documents") {
+		t.Errorf("dry run didn't report the legacy IDs:\n%s", out.String())
+	}
+	if version, err := store.SchemaVersion(); err != nil || version != 0 {
+		t.Errorf("schema version after the dry run: %d, %v", version, err)
+	}
+	if n, _ := quizzes.FindId(oid).Count(); n != 0 {
+		t.Errorf("the dry run moved the quiz")
+	}
+
+	out.Reset()
+	if err = store.Migrate(LatestSchemaVersion(), false, &out); err != nil {
+		t.Fatalf("migrating: %v\n%s", err, out.String())
+	}
+	id := QuizID(oid.Hex())
+	quiz, err := store.RetrieveQuiz(id)
+	if err != nil || quiz.Title != "Algebra" || quiz.Version != 1 || len(quiz.Questions) != 1 || quiz.Questions[0].Id == "" {
+		t.Errorf("migrated quiz: %+v, %v", quiz, err)
+	}
+	if _, err = store.RetrieveRevision(id, 1); err != nil {
+		t.Errorf("its first revision: %v", err)
+	}
+	if n, _ := quizzes.FindId("not an id").Count(); n != 1 {
+		t.Errorf("the quiz with no ObjectId was removed")
+	}
+}
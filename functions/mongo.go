@@ -0,0 +1,778 @@
+package functions
+
+// MongoDB backend for the Store interface, via mgo.v2
+// One session is dialed at startup; every operation works on a copy of it, so sockets come from a shared pool
+// and a restarted mongod is reconnected to transparently.
+
+import (
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"log"
+	"time"
+)
+
+type MongoConfig struct { // Connection settings for NewMongoStore
+	Addr          string        // host:port of mongod
+	PoolLimit     int           // Maximum sockets open to the server at once
+	Timeout       time.Duration // How long to wait for the server when dialing or picking a socket
+	SocketTimeout time.Duration // How long a single operation may take
+}
+
+func DefaultMongoConfig(addr string) MongoConfig {
+	return MongoConfig{Addr: addr, PoolLimit: 64, Timeout: 10 * time.Second, SocketTimeout: time.Minute}
+}
+
+type MongoStore struct { // Store backed by the "server" database on a MongoDB host
+	session *mgo.Session // Master session; never used directly, only copied
+}
+
+func NewMongoStore(config MongoConfig) (*MongoStore, error) {
+	// Dials the server once.  Fails if it can't be reached within config.Timeout.
+	session, err := mgo.DialWithInfo(&mgo.DialInfo{
+		Addrs:     []string{config.Addr},
+		Timeout:   config.Timeout,
+		PoolLimit: config.PoolLimit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	session.SetSyncTimeout(config.Timeout)
+	session.SetSocketTimeout(config.SocketTimeout)
+	return &MongoStore{session: session}, nil
+}
+
+func (store *MongoStore) Close() {
+	store.session.Close()
+}
+
+func (store *MongoStore) copy() *mgo.Session {
+	// A session for one operation.  The caller must Close it.
+	return store.session.Copy()
+}
+
+func mongoError(err error) error {
+	// Maps mgo errors onto the Store errors.  Anything that isn't a reply from the server means it couldn't be reached.
+	switch err.(type) {
+	case nil, *mgo.LastError, *mgo.QueryError:
+		return err
+	}
+	if err == mgo.ErrNotFound {
+		return ErrNotFound
+	}
+	log.Println("mongo:", err)
+	return ErrUnavailable
+}
+
+func (store *MongoStore) RetrieveQuiz(target QuizID) (Quiz, error) {
+	// Retrieves quiz with the given ID
+	db := store.copy()
+	defer db.Close()
+	c := db.DB("server").C("quiz")
+	result := new(Quiz)
+	if !target.Valid() {
+		return *new(Quiz), ErrNotFound
+	}
+	err := c.FindId(target.ObjectId()).One(&result)
+	if err != nil {
+		return *new(Quiz), mongoError(err)
+	}
+	return *result, nil
+}
+
+func (store *MongoStore) UpdateQuiz(quiz Quiz) error {
+	db := store.copy()
+	defer db.Close()
+	c := db.DB("server").C("quiz")
+	if !quiz.Id.Valid() {
+		return ErrNotFound
+	}
+	result := Quiz{}
+	_, err := c.Find(bson.M{"_id": quiz.Id.ObjectId(), "version": quiz.Version}).Apply(mgo.Change{
+		Update: bson.M{
+			"$set": bson.M{
+				"title":       quiz.Title,
+				"questions":   quiz.Questions,
+				"subject":     quiz.Subject,
+				"difficulty":  quiz.Difficulty,
+				"published":   quiz.Published,
+				"review":      quiz.Review,
+				"review_date": quiz.ReviewDate,
+				"time_limit":  quiz.TimeLimit,
+				"policy":      quiz.Policy,
+			},
+			"$inc": bson.M{"version": 1},
+		},
+		ReturnNew: true,
+	}, &result)
+	if err != nil {
+		return versionError(c, quiz.Id, err)
+	}
+	return saveRevision(db.DB("server"), result)
+}
+
+func (store *MongoStore) AddQuestion(id QuizID, version int, question Question) error {
+	db := store.copy()
+	defer db.Close()
+	c := db.DB("server").C("quiz")
+	if !id.Valid() {
+		return ErrNotFound
+	}
+	result := Quiz{}
+	_, err := c.Find(bson.M{"_id": id.ObjectId(), "version": version}).Apply(mgo.Change{
+		Update: bson.M{
+			"$push": bson.M{"questions": question},
+			"$inc":  bson.M{"version": 1},
+		},
+		ReturnNew: true,
+	}, &result)
+	if err != nil {
+		return versionError(c, id, err)
+	}
+	return saveRevision(db.DB("server"), result)
+}
+
+func (store *MongoStore) DeleteQuiz(id QuizID, version int) error {
+	db := store.copy()
+	defer db.Close()
+	c := db.DB("server").C("quiz")
+	if !id.Valid() {
+		return ErrNotFound
+	}
+	err := c.Remove(bson.M{"_id": id.ObjectId(), "version": version})
+	if err != nil {
+		return versionError(c, id, err)
+	}
+	// Revisions that attempts were graded against are kept
+	var graded []int
+	err = db.DB("server").C("attempts").Find(bson.M{"quiz": id.ObjectId()}).Distinct("version", &graded)
+	if err != nil {
+		return mongoError(err)
+	}
+	_, err = db.DB("server").C("quiz_revisions").RemoveAll(bson.M{"quiz": id.ObjectId(), "version": bson.M{"$nin": graded}})
+	return mongoError(err)
+}
+
+func saveRevision(db *mgo.Database, quiz Quiz) error {
+	// Called with the quiz document as the update left it.  Mongo can't write both in one step, so if this fails
+	// the quiz is saved but its new version is missing from the history.
+	err := db.C("quiz_revisions").Insert(NewRevision(quiz))
+	if err != nil {
+		log.Printf("quiz %s: failed to save revision %d: %v", quiz.Id, quiz.Version, err)
+	}
+	return mongoError(err)
+}
+
+func (store *MongoStore) RetrieveRevisions(id QuizID) ([]Revision, error) {
+	db := store.copy()
+	defer db.Close()
+	if !id.Valid() {
+		return nil, ErrNotFound
+	}
+	n, err := db.DB("server").C("quiz").FindId(id.ObjectId()).Count()
+	if err != nil {
+		return nil, mongoError(err)
+	} else if n == 0 {
+		return nil, ErrNotFound
+	}
+	result := []Revision{}
+	err = db.DB("server").C("quiz_revisions").Find(bson.M{"quiz": id.ObjectId()}).Sort("version").All(&result)
+	if err != nil {
+		return nil, mongoError(err)
+	}
+	return result, nil
+}
+
+func (store *MongoStore) RetrieveRevision(id QuizID, version int) (Revision, error) {
+	db := store.copy()
+	defer db.Close()
+	if !id.Valid() {
+		return Revision{}, ErrNotFound
+	}
+	result := Revision{}
+	err := db.DB("server").C("quiz_revisions").Find(bson.M{"quiz": id.ObjectId(), "version": version}).One(&result)
+	if err != nil {
+		return Revision{}, mongoError(err)
+	}
+	return result, nil
+}
+
+func versionError(c *mgo.Collection, id QuizID, err error) error {
+	// A conditional update that matched nothing either had a stale version or the quiz is gone
+	if err != mgo.ErrNotFound {
+		return mongoError(err)
+	}
+	n, err := c.FindId(id.ObjectId()).Count()
+	if err != nil {
+		return mongoError(err)
+	} else if n > 0 {
+		return ErrConflict
+	}
+	return ErrNotFound
+}
+
+func (store *MongoStore) InsertPassage(passage Passage) (string, error) {
+	db := store.copy()
+	defer db.Close()
+	passage.Id = NewPassageID()
+	passage.Created = time.Now()
+	err := db.DB("server").C("passages").Insert(&passage)
+	if err != nil {
+		return "", mongoError(err)
+	}
+	return passage.Id, nil
+}
+
+func (store *MongoStore) RetrievePassage(id string) (Passage, error) {
+	db := store.copy()
+	defer db.Close()
+	result := Passage{}
+	err := db.DB("server").C("passages").FindId(id).One(&result)
+	if err != nil {
+		return Passage{}, mongoError(err)
+	}
+	return result, nil
+}
+
+func (store *MongoStore) RetrievePassages() ([]Passage, error) {
+	db := store.copy()
+	defer db.Close()
+	result := []Passage{}
+	err := db.DB("server").C("passages").Find(nil).Sort("title").All(&result)
+	if err != nil {
+		return nil, mongoError(err)
+	}
+	return result, nil
+}
+
+func (store *MongoStore) UpdatePassage(passage Passage) error {
+	db := store.copy()
+	defer db.Close()
+	err := db.DB("server").C("passages").UpdateId(passage.Id, bson.M{"$set": bson.M{"title": passage.Title, "text": passage.Text}})
+	return mongoError(err)
+}
+
+func (store *MongoStore) InsertSubmission(submission Submission) (string, error) {
+	db := store.copy()
+	defer db.Close()
+	submission.Id = NewSubmissionID()
+	submission.Created = time.Now()
+	submission.Updates = 0
+	err := db.DB("server").C("submissions").Insert(&submission)
+	if err != nil {
+		return "", mongoError(err)
+	}
+	return submission.Id, nil
+}
+
+func (store *MongoStore) RetrieveSubmission(id string) (Submission, error) {
+	db := store.copy()
+	defer db.Close()
+	result := Submission{}
+	err := db.DB("server").C("submissions").FindId(id).One(&result)
+	if err != nil {
+		return Submission{}, mongoError(err)
+	}
+	return result, nil
+}
+
+func (store *MongoStore) RetrievePendingSubmissions() ([]Submission, error) {
+	db := store.copy()
+	defer db.Close()
+	result := []Submission{}
+	err := db.DB("server").C("submissions").Find(bson.M{"graded": false}).Sort("created").All(&result)
+	if err != nil {
+		return nil, mongoError(err)
+	}
+	return result, nil
+}
+
+func (store *MongoStore) UpdateSubmission(submission Submission) error {
+	db := store.copy()
+	defer db.Close()
+	c := db.DB("server").C("submissions")
+	filter := bson.M{"_id": submission.Id, "graded": false, "updates": submission.Updates}
+	submission.Updates++
+	err := c.Update(filter, &submission)
+	if err != mgo.ErrNotFound {
+		return mongoError(err)
+	}
+	n, err := c.FindId(submission.Id).Count()
+	if err != nil {
+		return mongoError(err)
+	} else if n > 0 {
+		return ErrConflict
+	}
+	return ErrNotFound
+}
+
+func (store *MongoStore) InsertQuiz(quiz DbQuiz) (QuizID, error) {
+	db := store.copy()
+	defer db.Close()
+	c := db.DB("server").C("quiz")
+	id := NewQuizID()
+	quiz.Created = time.Now()
+	quiz.Attempts = 0
+	quiz.Version = 1
+	record := quiz.GetQuiz(id)
+	err := c.Insert(&record)
+	if err != nil {
+		return "", mongoError(err)
+	}
+	return id, saveRevision(db.DB("server"), record)
+}
+
+func (store *MongoStore) RetrieveQuizzes(query QuizQuery) (QuizPage, error) {
+	// Retrieves one page of the matching quizzes
+	db := store.copy()
+	defer db.Close()
+	c := db.DB("server").C("quiz")
+	query = query.Normalize()
+	filter := bson.M{}
+	for field, value := range map[string]string{"title": query.Title, "subject": query.Subject, "difficulty": query.Difficulty, "author": query.Author} {
+		if value != "" {
+			filter[field] = value
+		}
+	}
+	if query.Published != "" {
+		filter["published"] = query.Published == "yes"
+	}
+	var order []string
+	switch query.Sort {
+	case "created":
+		order = []string{"-created", "-_id"}
+	case "popular":
+		order = []string{"-attempts", "title", "_id"}
+	default:
+		order = []string{"title", "_id"}
+	}
+	total, err := c.Find(filter).Count()
+	if err != nil {
+		return QuizPage{}, mongoError(err)
+	}
+	result := []Quiz{}
+	err = c.Find(filter).Sort(order...).Skip(query.Skip()).Limit(query.PerPage).All(&result)
+	if err != nil {
+		return QuizPage{}, mongoError(err)
+	}
+	return QuizPage{Query: query, Quizzes: result, Total: total}, nil
+}
+
+func (store *MongoStore) CountAttempt(id QuizID, version int) error {
+	db := store.copy()
+	defer db.Close()
+	if !id.Valid() {
+		return ErrNotFound
+	}
+	err := db.DB("server").C("quiz").UpdateId(id.ObjectId(), bson.M{"$inc": bson.M{"attempts": 1}})
+	if err != nil {
+		return mongoError(err)
+	}
+	err = db.DB("server").C("quiz_revisions").Update(bson.M{"quiz": id.ObjectId(), "version": version}, bson.M{"$inc": bson.M{"attempts": 1}})
+	if err == mgo.ErrNotFound {
+		return nil // Graded against an unknown version; the quiz's own count is what matters for listings
+	}
+	return mongoError(err)
+}
+
+func (store *MongoStore) SearchQuizzes(query SearchQuery) ([]SearchResult, error) {
+	// Uses the text index from the "text index for quiz search" migration for matching and ranking
+	db := store.copy()
+	defer db.Close()
+	c := db.DB("server").C("quiz")
+	query = query.Normalize()
+	filter := bson.M{"$text": bson.M{"$search": query.Text}}
+	if query.PublishedOnly {
+		filter["published"] = true
+	}
+	var found []struct {
+		Quiz  `bson:",inline"`
+		Score float64 `bson:"score"`
+	}
+	err := c.Find(filter).Select(bson.M{"score": bson.M{"$meta": "textScore"}}).Sort("$textScore:score").Limit(query.Limit).All(&found)
+	if err != nil {
+		return nil, mongoError(err)
+	}
+	terms := uniqueTerms(SearchTerms(query.Text))
+	results := []SearchResult{}
+	for i := 0; i < len(found); i++ {
+		matches := FindMatches(found[i].Quiz, terms)
+		if len(matches) == 0 && gridInMatch(found[i].Quiz, terms) {
+			// The text index covers every answer, so this quiz was found by a response one of its grid-ins accepts
+			continue
+		}
+		results = append(results, SearchResult{Quiz: found[i].Quiz, Score: found[i].Score, Matches: matches})
+	}
+	return results, nil
+}
+
+func gridInMatch(quiz Quiz, terms []string) bool {
+	// Whether any of the quiz's grid-ins accepts a response containing one of terms
+	for _, question := range quiz.Questions {
+		for j := 0; question.IsGridIn() && j < len(question.Answers); j++ {
+			if _, ok := Highlight(question.Answers[j], terms); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (store *MongoStore) DeleteAccount(user User) error {
+	db := store.copy()
+	defer db.Close()
+	c := db.DB("server").C("users")
+	err := c.Remove(bson.M{"username": user.Username})
+	if err != nil {
+		return mongoError(err)
+	}
+	_, err = db.DB("server").C("attempts").RemoveAll(bson.M{"username": user.Username})
+	if err != nil {
+		return mongoError(err)
+	}
+	_, err = db.DB("server").C("sittings").RemoveAll(bson.M{"username": user.Username})
+	return mongoError(err)
+}
+
+func (store *MongoStore) InsertAttempt(attempt Attempt) (string, error) {
+	db := store.copy()
+	defer db.Close()
+	n, err := db.DB("server").C("users").Find(bson.M{"username": attempt.Username}).Count()
+	if err != nil {
+		return "", mongoError(err)
+	} else if n == 0 {
+		return "", ErrNotFound
+	}
+	attempt.Id = NewAttemptID()
+	err = db.DB("server").C("attempts").Insert(&attempt)
+	if err != nil {
+		return "", mongoError(err)
+	}
+	return attempt.Id, nil
+}
+
+func (store *MongoStore) RetrieveAttempt(id string) (Attempt, error) {
+	db := store.copy()
+	defer db.Close()
+	result := Attempt{}
+	err := db.DB("server").C("attempts").FindId(id).One(&result)
+	if err != nil {
+		return Attempt{}, mongoError(err)
+	}
+	return result, nil
+}
+
+func (store *MongoStore) RetrieveAttempts(username string) ([]Attempt, error) {
+	db := store.copy()
+	defer db.Close()
+	result := []Attempt{}
+	err := db.DB("server").C("attempts").Find(bson.M{"username": username}).Sort("-finished").All(&result)
+	if err != nil {
+		return nil, mongoError(err)
+	}
+	return result, nil
+}
+
+func (store *MongoStore) RetrieveOpenAttempt(username string, quiz QuizID) (Attempt, error) {
+	db := store.copy()
+	defer db.Close()
+	if !quiz.Valid() {
+		return Attempt{}, ErrNotFound
+	}
+	result := Attempt{}
+	err := db.DB("server").C("attempts").Find(bson.M{"username": username, "quiz": quiz.ObjectId(), "finished": time.Time{}, "sitting": bson.M{"$exists": false}}).Sort("-started").One(&result)
+	if err != nil {
+		return Attempt{}, mongoError(err)
+	}
+	return result, nil
+}
+
+func (store *MongoStore) SaveResponse(id string, question string, response []string) error {
+	db := store.copy()
+	defer db.Close()
+	c := db.DB("server").C("attempts")
+	update := bson.M{"$set": bson.M{"responses." + question: response}}
+	if len(response) == 0 {
+		update = bson.M{"$unset": bson.M{"responses." + question: ""}}
+	}
+	err := c.Update(bson.M{"_id": id, "finished": time.Time{}}, update)
+	if err != mgo.ErrNotFound {
+		return mongoError(err)
+	}
+	n, err := c.FindId(id).Count()
+	if err != nil {
+		return mongoError(err)
+	} else if n > 0 {
+		return ErrConflict
+	}
+	return ErrNotFound
+}
+
+func (store *MongoStore) UpdateAttempt(attempt Attempt) error {
+	db := store.copy()
+	defer db.Close()
+	c := db.DB("server").C("attempts")
+	if attempt.Responses == nil {
+		attempt.Responses = Answers{}
+	}
+	err := c.Update(bson.M{"_id": attempt.Id, "finished": time.Time{}}, bson.M{"$set": bson.M{
+		"responses":  attempt.Responses,
+		"finished":   attempt.Finished,
+		"graded":     attempt.Graded,
+		"score":      attempt.Score,
+		"submission": attempt.Submission,
+		"policy":     attempt.Policy,
+	}})
+	if err != mgo.ErrNotFound {
+		return mongoError(err)
+	}
+	n, err := c.FindId(attempt.Id).Count()
+	if err != nil {
+		return mongoError(err)
+	} else if n > 0 {
+		return ErrConflict
+	}
+	return ErrNotFound
+}
+
+func (store *MongoStore) FinishAttempt(submission string, score float32) error {
+	db := store.copy()
+	defer db.Close()
+	if submission == "" {
+		return ErrNotFound
+	}
+	err := db.DB("server").C("attempts").Update(bson.M{"submission": submission}, bson.M{"$set": bson.M{"graded": true, "score": score}})
+	return mongoError(err)
+}
+
+func (store *MongoStore) CreateAccount(user User) error {
+	db := store.copy()
+	defer db.Close()
+	c := db.DB("server").C("users")
+	result := new(User)
+	err := c.Find(bson.M{"username": user.Username}).One(&result)
+	if err != nil && err != mgo.ErrNotFound {
+		return mongoError(err)
+	} else if err == nil {
+		return ErrUserExists
+	} else {
+		user, err = HashPassword(user)
+		if err != nil {
+			return err
+		}
+		err = c.Insert(&user)
+		return mongoError(err)
+	}
+}
+
+func (store *MongoStore) GetUser(username string) (User, error) {
+	db := store.copy()
+	defer db.Close()
+	c := db.DB("server").C("users")
+	result := new(User)
+	err := c.Find(bson.M{"username": username}).One(result)
+	if err != nil {
+		return User{}, mongoError(err)
+	}
+	return *result, nil
+}
+
+func (store *MongoStore) CheckLogin(user User) (User, error) {
+	db := store.copy()
+	defer db.Close()
+	c := db.DB("server").C("users")
+	dbresult := new(User)
+	err := c.Find(bson.M{"username": user.Username}).One(dbresult)
+	if err == mgo.ErrNotFound {
+		return User{}, ErrLoginFailed
+	} else if err != nil {
+		return User{}, mongoError(err)
+	}
+	return CheckPassword(user, *dbresult)
+}
+
+func (store *MongoStore) InsertTest(test Test) (string, error) {
+	db := store.copy()
+	defer db.Close()
+	test.Id = NewTestID()
+	test.Created = time.Now()
+	test.Version = 1
+	err := db.DB("server").C("tests").Insert(&test)
+	if err != nil {
+		return "", mongoError(err)
+	}
+	return test.Id, nil
+}
+
+func (store *MongoStore) RetrieveTest(id string) (Test, error) {
+	db := store.copy()
+	defer db.Close()
+	result := Test{}
+	err := db.DB("server").C("tests").FindId(id).One(&result)
+	if err != nil {
+		return Test{}, mongoError(err)
+	}
+	return result, nil
+}
+
+func (store *MongoStore) RetrieveTests() ([]Test, error) {
+	db := store.copy()
+	defer db.Close()
+	result := []Test{}
+	err := db.DB("server").C("tests").Find(nil).Sort("title", "_id").All(&result)
+	if err != nil {
+		return nil, mongoError(err)
+	}
+	return result, nil
+}
+
+func (store *MongoStore) UpdateTest(test Test) error {
+	db := store.copy()
+	defer db.Close()
+	c := db.DB("server").C("tests")
+	err := c.Update(bson.M{"_id": test.Id, "version": test.Version}, bson.M{
+		"$set": bson.M{"title": test.Title, "sections": test.Sections, "published": test.Published},
+		"$inc": bson.M{"version": 1},
+	})
+	if err != mgo.ErrNotFound {
+		return mongoError(err)
+	}
+	n, err := c.FindId(test.Id).Count()
+	if err != nil {
+		return mongoError(err)
+	} else if n > 0 {
+		return ErrConflict
+	}
+	return ErrNotFound
+}
+
+func (store *MongoStore) InsertSitting(sitting Sitting) (string, error) {
+	db := store.copy()
+	defer db.Close()
+	n, err := db.DB("server").C("users").Find(bson.M{"username": sitting.Username}).Count()
+	if err != nil {
+		return "", mongoError(err)
+	} else if n == 0 {
+		return "", ErrNotFound
+	}
+	sitting.Id = NewSittingID()
+	err = db.DB("server").C("sittings").Insert(&sitting)
+	if err != nil {
+		return "", mongoError(err)
+	}
+	return sitting.Id, nil
+}
+
+func (store *MongoStore) RetrieveSitting(id string) (Sitting, error) {
+	db := store.copy()
+	defer db.Close()
+	result := Sitting{}
+	err := db.DB("server").C("sittings").FindId(id).One(&result)
+	if err != nil {
+		return Sitting{}, mongoError(err)
+	}
+	return result, nil
+}
+
+func (store *MongoStore) RetrieveSittings(username string) ([]Sitting, error) {
+	db := store.copy()
+	defer db.Close()
+	result := []Sitting{}
+	err := db.DB("server").C("sittings").Find(bson.M{"username": username}).Sort("-started", "-_id").All(&result)
+	if err != nil {
+		return nil, mongoError(err)
+	}
+	return result, nil
+}
+
+func (store *MongoStore) UpdateSitting(sitting Sitting, current int) error {
+	db := store.copy()
+	defer db.Close()
+	c := db.DB("server").C("sittings")
+	err := c.Update(bson.M{"_id": sitting.Id, "current": current}, bson.M{"$set": bson.M{
+		"attempts": sitting.Attempts,
+		"current":  sitting.Current,
+		"finished": sitting.Finished,
+		"scale":    sitting.Scale,
+	}})
+	if err != mgo.ErrNotFound {
+		return mongoError(err)
+	}
+	n, err := c.FindId(sitting.Id).Count()
+	if err != nil {
+		return mongoError(err)
+	} else if n > 0 {
+		return ErrConflict
+	}
+	return ErrNotFound
+}
+
+func (store *MongoStore) BeginSection(sitting Sitting, attempt string) error {
+	// Each section started so far has an attempt, so one not started yet is one with no more attempts than its number
+	db := store.copy()
+	defer db.Close()
+	c := db.DB("server").C("sittings")
+	err := c.Update(bson.M{"_id": sitting.Id, "current": sitting.Current, "attempts": bson.M{"$size": sitting.Current}},
+		bson.M{"$push": bson.M{"attempts": attempt}})
+	if err != mgo.ErrNotFound {
+		return mongoError(err)
+	}
+	n, err := c.FindId(sitting.Id).Count()
+	if err != nil {
+		return mongoError(err)
+	} else if n > 0 {
+		return ErrConflict
+	}
+	return ErrNotFound
+}
+
+func (store *MongoStore) InsertScale(scale Scale) error {
+	// The unique index on test and version turns a race between two admins into ErrConflict for the second
+	db := store.copy()
+	defer db.Close()
+	n, err := db.DB("server").C("tests").FindId(scale.Test).Count()
+	if err != nil {
+		return mongoError(err)
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	c := db.DB("server").C("scales")
+	latest := Scale{}
+	err = c.Find(bson.M{"test": scale.Test}).Sort("-version").One(&latest)
+	if err != nil && err != mgo.ErrNotFound {
+		return mongoError(err)
+	} else if scale.Version != latest.Version+1 {
+		return ErrConflict
+	}
+	scale.Created = time.Now()
+	err = c.Insert(&scale)
+	if mgo.IsDup(err) {
+		return ErrConflict
+	}
+	return mongoError(err)
+}
+
+func (store *MongoStore) RetrieveScale(test string, version int) (Scale, error) {
+	db := store.copy()
+	defer db.Close()
+	query := bson.M{"test": test}
+	if version != 0 {
+		query["version"] = version
+	}
+	result := Scale{}
+	err := db.DB("server").C("scales").Find(query).Sort("-version").One(&result)
+	if err != nil {
+		return Scale{}, mongoError(err)
+	}
+	return result, nil
+}
+
+func (store *MongoStore) RetrieveScales(test string) ([]Scale, error) {
+	db := store.copy()
+	defer db.Close()
+	result := []Scale{}
+	err := db.DB("server").C("scales").Find(bson.M{"test": test}).Sort("version").All(&result)
+	if err != nil {
+		return nil, mongoError(err)
+	}
+	return result, nil
+}
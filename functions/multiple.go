@@ -0,0 +1,102 @@
+package functions
+
+// Multi-select ("select all that apply") and ordering ("put these in order") questions.
+// Both are answered with a list of choice Ids, sent as repeated form values (see Answers).  All-or-nothing scoring
+// needs the whole list right; partial credit is described with multiSelectCredit and orderingCredit.
+
+import (
+	"errors"
+	"math/rand"
+)
+
+func containsIndex(indexes []int, i int) bool {
+	for j := 0; j < len(indexes); j++ {
+		if indexes[j] == i {
+			return true
+		}
+	}
+	return false
+}
+
+func validScoring(scoring string) bool {
+	return scoring == "" || scoring == AllOrNothing || scoring == PartialCredit
+}
+
+func (question Question) validateMultiSelect() error {
+	err := validateAnswerList(question.Answers, "answer choices")
+	if err != nil {
+		return err
+	} else if len(question.CorrectIndexes) == 0 {
+		return errors.New("mark at least one answer as correct")
+	} else if !validScoring(question.Scoring) {
+		return errors.New("unknown scoring rule")
+	}
+	for i := 0; i < len(question.CorrectIndexes); i++ {
+		if question.CorrectIndexes[i] < 0 || question.CorrectIndexes[i] >= len(question.Answers) ||
+			containsIndex(question.CorrectIndexes[:i], question.CorrectIndexes[i]) {
+			return errors.New("the correct answers must each be one of the choices, once")
+		}
+	}
+	return nil
+}
+
+func (question Question) validateOrdering() error {
+	err := validateAnswerList(question.Answers, "items to order")
+	if err != nil {
+		return err
+	} else if !validScoring(question.Scoring) {
+		return errors.New("unknown scoring rule")
+	}
+	return nil
+}
+
+func (question Question) multiSelectCredit(chosen []string) float32 {
+	// Partial credit is the correct choices picked less the wrong ones, as a fraction of the correct choices, and never below 0
+	picked := map[string]bool{}
+	for i := 0; i < len(chosen); i++ {
+		picked[chosen[i]] = true
+	}
+	right, wrong := 0, 0
+	for i := 0; i < len(question.Answers); i++ {
+		if !picked[ChoiceID(i)] {
+			continue
+		} else if containsIndex(question.CorrectIndexes, i) {
+			right++
+		} else {
+			wrong++
+		}
+	}
+	if len(question.CorrectIndexes) == 0 {
+		return 0
+	} else if right == len(question.CorrectIndexes) && wrong == 0 {
+		return 1
+	} else if !question.PartialCredit() || right <= wrong {
+		return 0
+	}
+	return float32(right-wrong) / float32(len(question.CorrectIndexes))
+}
+
+func (question Question) orderingCredit(chosen []string) float32 {
+	// Partial credit is the fraction of items put in their right place
+	if len(question.Answers) == 0 {
+		return 0
+	}
+	placed := 0
+	for i := 0; i < len(question.Answers) && i < len(chosen); i++ {
+		if chosen[i] == ChoiceID(i) {
+			placed++
+		}
+	}
+	if placed == len(question.Answers) {
+		return 1
+	} else if !question.PartialCredit() {
+		return 0
+	}
+	return float32(placed) / float32(len(question.Answers))
+}
+
+func shuffled(items []Choice) []Choice {
+	result := append([]Choice{}, items...)
+	rand.Shuffle(len(result), func(i, j int) { result[i], result[j] = result[j], result[i] })
+	return result
+}
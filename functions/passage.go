@@ -0,0 +1,140 @@
+package functions
+
+// Reading passages shared by several questions.
+// A Passage is stored on its own and questions refer to it by Id, so one passage can be used in any number of quizzes.
+// Editing a passage changes it everywhere it is used, old revisions included.
+//
+// The text is plain, one printed line per line, with paragraphs separated by blank lines.  **bold**, *italic* and
+// __underlined__ words are marked up like that, the way SAT writing questions point at the words they ask about.
+
+import (
+	"errors"
+	"html/template"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Passage struct {
+	Id      string    `schema:"-" bson:"_id"`
+	Title   string    `schema:"title" bson:"title"` // For admins choosing a passage; students don't see it
+	Text    string    `schema:"text" bson:"text"`
+	Created time.Time `schema:"-" bson:"created"`
+}
+
+func NewPassageID() string {
+	return NewQuestionID()
+}
+
+func (passage Passage) Validate() error {
+	if strings.TrimSpace(passage.Title) == "" {
+		return errors.New("the passage needs a title")
+	} else if strings.TrimSpace(passage.Text) == "" {
+		return errors.New("the passage text can't be empty")
+	}
+	return nil
+}
+
+type PassageLine struct { // One printed line of a passage
+	Number    int           // Counting from 1.  The blank lines between paragraphs aren't counted.
+	Text      template.HTML // Escaped, with the markup turned into tags
+	Paragraph bool          // First line of a paragraph
+	Labeled   bool          // Whether Number is printed beside it, as on the test: line 1 and every fifth line
+}
+
+func (passage Passage) Lines() []PassageLine {
+	lines := []PassageLine{}
+	paragraph := true
+	for _, text := range strings.Split(strings.Replace(passage.Text, "\r\n", "\n", -1), "\n") {
+		if strings.TrimSpace(text) == "" {
+			paragraph = true
+			continue
+		}
+		number := len(lines) + 1
+		lines = append(lines, PassageLine{
+			Number:    number,
+			Text:      passageMarkup(strings.TrimRight(text, " \t")),
+			Paragraph: paragraph && number > 1,
+			Labeled:   number == 1 || number%5 == 0,
+		})
+		paragraph = false
+	}
+	return lines
+}
+
+var passageMarks = []struct{ mark, tag string }{{"**", "b"}, {"__", "u"}, {"*", "i"}} // Longest first
+
+func passageMarkup(line string) template.HTML {
+	// Escapes line and turns the markup into tags.  Marks that aren't closed by the end of the line are closed there.
+	escaped := template.HTMLEscapeString(line)
+	result := ""
+	open := []string{} // Tags open at this point, innermost last
+	for i := 0; i < len(escaped); {
+		tag := ""
+		for _, m := range passageMarks {
+			if strings.HasPrefix(escaped[i:], m.mark) {
+				tag = m.tag
+				i += len(m.mark)
+				break
+			}
+		}
+		if tag == "" {
+			result += escaped[i : i+1]
+			i++
+			continue
+		}
+		at := -1
+		for j := 0; j < len(open); j++ {
+			if open[j] == tag {
+				at = j
+			}
+		}
+		if at < 0 {
+			result += "<" + tag + ">"
+			open = append(open, tag)
+			continue
+		}
+		// Close everything opened inside this tag, then reopen it, so the tags always nest
+		for j := len(open) - 1; j >= at; j-- {
+			result += "</" + open[j] + ">"
+		}
+		for j := at + 1; j < len(open); j++ {
+			result += "<" + open[j] + ">"
+		}
+		open = append(open[:at:at], open[at+1:]...)
+	}
+	for j := len(open) - 1; j >= 0; j-- {
+		result += "</" + open[j] + ">"
+	}
+	return template.HTML(result)
+}
+
+func QuizPassages(store PassageStore, quiz Quiz) (map[string]Passage, error) {
+	// Every passage the quiz's questions refer to, by Id.  Passages that no longer exist are left out.
+	result := map[string]Passage{}
+	for i := 0; i < len(quiz.Questions); i++ {
+		id := quiz.Questions[i].Passage
+		if _, ok := result[id]; ok || id == "" {
+			continue
+		}
+		passage, err := store.RetrievePassage(id)
+		if err == ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		result[id] = passage
+	}
+	return result, nil
+}
+
+type passageSorter []Passage
+
+func (s passageSorter) Len() int           { return len(s) }
+func (s passageSorter) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s passageSorter) Less(i, j int) bool { return s[i].Title < s[j].Title }
+
+func SortPassages(passages []Passage) {
+	// By title, the order RetrievePassages returns them in
+	sort.Stable(passageSorter(passages))
+}
@@ -0,0 +1,97 @@
+package functions
+
+// Scoring policies.  Each quiz says how answers turn into a score: whether a wrong multiple-choice answer costs
+// something, like the old SAT's quarter-point guessing penalty; whether multi-select and ordering questions give
+// partial credit; and whether questions count their point weights.  The zero policy is how quizzes were always
+// scored, so attempts from before policies score the same.  Grading records the policy on the attempt, and revisions
+// keep it with the questions, so an attempt can always be scored again exactly as it was.
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+const MaxWeight = 10 // Points a question may be worth
+
+type ScoringPolicy struct {
+	Penalty  float32 `schema:"penalty" bson:"penalty"`   // Taken off for each wrong (not blank) multiple-choice answer, as a fraction of the question's points: 0.25 for the old SAT
+	Partial  string  `schema:"partial" bson:"partial"`   // AllOrNothing or PartialCredit for every multi-select and ordering question; empty leaves each question's own Scoring
+	Weighted bool    `schema:"weighted" bson:"weighted"` // Questions count their Weight; otherwise every question is worth 1
+}
+
+func (policy ScoringPolicy) Validate() error {
+	penalty := float64(policy.Penalty)
+	if math.IsNaN(penalty) || math.IsInf(penalty, 0) || penalty < 0 || penalty > 1 {
+		return errors.New("the penalty is a fraction of the question's points, from 0 to 1")
+	} else if !validScoring(policy.Partial) {
+		return fmt.Errorf("unknown partial credit setting %q", policy.Partial)
+	}
+	return nil
+}
+
+func (question Question) Points() int {
+	// The question's weight, with questions from before weights worth 1
+	if question.Weight < 1 {
+		return 1
+	}
+	return question.Weight
+}
+
+func (policy ScoringPolicy) Worth(question Question) float32 {
+	// Points the question counts for under the policy
+	if policy.Weighted {
+		return float32(question.Points())
+	}
+	return 1
+}
+
+func (quiz Quiz) Possible() float32 {
+	// Points the quiz is out of under its policy, as Submit works them out
+	var possible float32
+	for _, question := range quiz.Questions {
+		possible += quiz.Policy.Worth(question)
+	}
+	return possible
+}
+
+func (policy ScoringPolicy) Credit(question Question, response []string) float32 {
+	// Like Question.Credit, with the policy's partial credit setting
+	if policy.Partial != "" && (question.IsMultiSelect() || question.IsOrdering()) {
+		question.Scoring = policy.Partial
+	}
+	return question.Credit(response)
+}
+
+func (policy ScoringPolicy) Earned(question Question, response []string) float32 {
+	// Points a response earns, negative when a wrong multiple-choice answer is penalized.  Essays earn nothing here.
+	credit := policy.Credit(question, response)
+	if credit == 0 && question.Kind() == ChoiceQuestion && singleResponse(response) != "" {
+		credit = -policy.Penalty
+	}
+	return credit * policy.Worth(question)
+}
+
+func (policy ScoringPolicy) Described() string {
+	// In words, for admins and for students' results
+	described := "no penalty for wrong answers"
+	if policy.Penalty > 0 {
+		described = "wrong multiple-choice answers lose " + strconv.FormatFloat(float64(policy.Penalty), 'f', -1, 32) + " of their points"
+	}
+	switch policy.Partial {
+	case AllOrNothing:
+		described += "; no partial credit"
+	case PartialCredit:
+		described += "; partial credit on every multi-select and ordering question"
+	}
+	if policy.Weighted {
+		described += "; questions weighted by points"
+	}
+	return described
+}
+
+func (policy ScoringPolicy) Standard() bool {
+	// The zero policy
+	return policy == ScoringPolicy{}
+}
@@ -0,0 +1,63 @@
+package functions
+
+// Quiz identifiers.  A QuizID is always the 24 lower-case hex digits of a MongoDB ObjectId, whatever the backend,
+// so the ID in /quiz/{id} works unchanged in /grade/{id}, /addq/{id} and /add_question/{id}.
+
+import (
+	"errors"
+	"gopkg.in/mgo.v2/bson"
+	"strings"
+)
+
+var ErrInvalidQuizID = errors.New("invalid quiz id")
+
+type QuizID string
+
+func NewQuizID() QuizID {
+	return QuizID(bson.NewObjectId().Hex())
+}
+
+func NewQuestionID() string {
+	// Question IDs are made the same way but stay plain strings; they only need to be unique within their quiz
+	return bson.NewObjectId().Hex()
+}
+
+func ParseQuizID(s string) (QuizID, error) {
+	// Accepts the hex form in either case; anything else is ErrInvalidQuizID
+	s = strings.ToLower(s)
+	if !bson.IsObjectIdHex(s) {
+		return "", ErrInvalidQuizID
+	}
+	return QuizID(s), nil
+}
+
+func (id QuizID) Valid() bool {
+	return bson.IsObjectIdHex(string(id)) && strings.ToLower(string(id)) == string(id)
+}
+
+func (id QuizID) String() string {
+	return string(id)
+}
+
+func (id QuizID) ObjectId() bson.ObjectId {
+	// Only call on a valid ID
+	return bson.ObjectIdHex(string(id))
+}
+
+func (id QuizID) GetBSON() (interface{}, error) {
+	// Stored in Mongo as a real ObjectId
+	if !id.Valid() {
+		return nil, ErrInvalidQuizID
+	}
+	return id.ObjectId(), nil
+}
+
+func (id *QuizID) SetBSON(raw bson.Raw) error {
+	var oid bson.ObjectId
+	err := raw.Unmarshal(&oid)
+	if err != nil {
+		return err
+	}
+	*id = QuizID(oid.Hex())
+	return nil
+}
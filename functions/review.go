@@ -0,0 +1,127 @@
+package functions
+
+// Reviewing a graded quiz: every question with the student's answer, the correct answer and its explanation.
+// Each quiz decides when students who have taken it may see this: once they finish an attempt, only after a due date,
+// or never.
+
+import (
+	"strings"
+	"time"
+)
+
+// Review settings for Quiz.Review
+const ReviewAfterAttempt = "attempt" // Also when empty, and for "now", which quizzes saved before it have
+const ReviewAfterDue = "after"
+const ReviewNever = "never"
+
+func (quiz Quiz) ReviewPolicy() string {
+	if quiz.Review == "" || quiz.Review == "now" {
+		return ReviewAfterAttempt
+	}
+	return quiz.Review
+}
+
+func (quiz Quiz) ReviewOpen(now time.Time) bool {
+	// Whether students who have finished an attempt may see the answers and explanations at this time
+	switch quiz.ReviewPolicy() {
+	case ReviewAfterAttempt:
+		return true
+	case ReviewAfterDue:
+		return !quiz.ReviewDate.IsZero() && !now.Before(quiz.ReviewDate)
+	}
+	return false
+}
+
+func ValidReviewPolicy(policy string) bool {
+	return policy == "" || policy == "now" || policy == ReviewAfterAttempt || policy == ReviewAfterDue || policy == ReviewNever
+}
+
+type ReviewItem struct { // One question of a review
+	Number      int
+	Question    Question
+	Response    string  // What the student answered, as shown to them; empty if they didn't
+	Answer      string  // The correct answer, as shown to the student
+	Credit      float32 // From 0 to 1, as in ScoringPolicy.Credit
+	Earned      float32 // Points, as in ScoringPolicy.Earned
+	Worth       float32 // Out of this many
+	Explanation string
+	Solution    string // Worked solution, if there is one
+}
+
+func (item ReviewItem) Right() bool {
+	return item.Credit == 1
+}
+
+func (item ReviewItem) Partial() bool {
+	return item.Credit > 0 && item.Credit < 1
+}
+
+func (question Question) ShownAnswer() string {
+	// The correct answer in words, for students reviewing the quiz
+	switch question.Kind() {
+	case ChoiceQuestion:
+		if question.CorrectIndex >= 0 && question.CorrectIndex < len(question.Answers) {
+			return question.Answers[question.CorrectIndex]
+		}
+	case MultiSelectQuestion:
+		correct := []string{}
+		for i := 0; i < len(question.Answers); i++ {
+			if containsIndex(question.CorrectIndexes, i) {
+				correct = append(correct, question.Answers[i])
+			}
+		}
+		return strings.Join(correct, ", ")
+	case OrderingQuestion:
+		return strings.Join(question.Answers, " → ")
+	case GridInQuestion:
+		return strings.Join(question.Answers, " or ")
+	case EssayQuestion:
+		return "Graded by hand"
+	}
+	return ""
+}
+
+func (question Question) ShownResponse(response []string) string {
+	// A student's response to question in words, with choice Ids turned back into the choices
+	switch question.Kind() {
+	case ChoiceQuestion, MultiSelectQuestion, OrderingQuestion:
+		shown := []string{}
+		for _, id := range response {
+			i := question.choiceIndex(id)
+			if i >= 0 {
+				shown = append(shown, question.Answers[i])
+			} else {
+				shown = append(shown, "?") // A place left empty
+			}
+		}
+		if question.IsOrdering() {
+			return strings.Join(shown, " → ")
+		}
+		return strings.Join(shown, ", ")
+	}
+	return singleResponse(response)
+}
+
+func (quiz Quiz) ReviewItems(answers Answers) []ReviewItem {
+	// quiz is the stored quiz (or revision) that was graded, with the policy it was scored under, and answers what the
+	// student sent.  Without answers the review is just the answers and explanations.
+	items := []ReviewItem{}
+	for i := 0; i < len(quiz.Questions); i++ {
+		question := quiz.Questions[i]
+		item := ReviewItem{
+			Number:      i + 1,
+			Question:    question,
+			Answer:      question.ShownAnswer(),
+			Explanation: question.Explanation,
+			Solution:    question.Solution,
+		}
+		if answers != nil {
+			item.Response = question.ShownResponse(answers[question.Id])
+			item.Credit = quiz.Policy.Credit(question, answers[question.Id])
+			item.Earned = quiz.Policy.Earned(question, answers[question.Id])
+			item.Worth = quiz.Policy.Worth(question)
+		}
+		items = append(items, item)
+	}
+	return items
+}
@@ -0,0 +1,184 @@
+package functions
+
+// Quiz revision history.
+// Every write to a quiz saves a copy of it as it was afterwards, numbered by its Version.  Revisions are never changed
+// (apart from counting attempts), so a student's attempt can always be graded against the questions they were shown.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Revision struct { // A quiz as it was at one Version
+	Quiz       QuizID        `bson:"quiz"`
+	Version    int           `bson:"version"`
+	Title      string        `bson:"title"`
+	Questions  []Question    `bson:"questions"`
+	Subject    string        `bson:"subject"`
+	Difficulty string        `bson:"difficulty"`
+	Published  bool          `bson:"published"`
+	Policy     ScoringPolicy `bson:"policy"`   // How answers were scored
+	Created    time.Time     `bson:"created"`  // When this version was saved
+	Attempts   int           `bson:"attempts"` // Attempts graded against this version
+}
+
+func NewRevision(quiz Quiz) Revision {
+	// Snapshot of quiz at its current Version
+	return Revision{
+		Quiz:       quiz.Id,
+		Version:    quiz.Version,
+		Title:      quiz.Title,
+		Questions:  quiz.Questions,
+		Subject:    quiz.Subject,
+		Difficulty: quiz.Difficulty,
+		Published:  quiz.Published,
+		Policy:     quiz.Policy,
+		Created:    time.Now(),
+	}
+}
+
+func (revision Revision) GetQuiz() Quiz {
+	// The quiz as it was, for grading
+	return Quiz{
+		Id:         revision.Quiz,
+		Version:    revision.Version,
+		Title:      revision.Title,
+		Questions:  revision.Questions,
+		Subject:    revision.Subject,
+		Difficulty: revision.Difficulty,
+		Published:  revision.Published,
+		Policy:     revision.Policy,
+	}
+}
+
+func (revision Revision) Previous() int {
+	// The version before this one, for linking to its changes
+	return revision.Version - 1
+}
+
+func (revision Revision) Restore(quiz Quiz) Quiz {
+	// quiz with its title, subject, difficulty, scoring policy and questions put back to this revision's.  Publishing is
+	// left as it is.
+	quiz.Title = revision.Title
+	quiz.Policy = revision.Policy
+	quiz.Subject = revision.Subject
+	quiz.Difficulty = revision.Difficulty
+	quiz.Questions = revision.Questions
+	return quiz
+}
+
+type RevisionChange struct { // One difference between two revisions
+	Field    string // "title", "subject", "difficulty", "published", "policy", "question", "type", "passage", "answers", "correct", "rubric", "explanation", "solution", "scoring", "tolerance", "weight", "added" or "removed"
+	Question int    // Index of the question, for question fields
+	Old      string
+	New      string
+}
+
+func (change RevisionChange) Number() int {
+	return change.Question + 1
+}
+
+func DiffRevisions(from Revision, to Revision) []RevisionChange {
+	// What changed going from one revision to the other, field by field and then question by question
+	changes := []RevisionChange{}
+	for _, field := range []struct{ name, before, after string }{
+		{"title", from.Title, to.Title},
+		{"subject", from.Subject, to.Subject},
+		{"difficulty", from.Difficulty, to.Difficulty},
+		{"published", strconv.FormatBool(from.Published), strconv.FormatBool(to.Published)},
+		{"policy", from.Policy.Described(), to.Policy.Described()},
+	} {
+		if field.before != field.after {
+			changes = append(changes, RevisionChange{Field: field.name, Old: field.before, New: field.after})
+		}
+	}
+	for i := 0; i < len(from.Questions) || i < len(to.Questions); i++ {
+		if i >= len(from.Questions) {
+			changes = append(changes, RevisionChange{Field: "added", Question: i, New: to.Questions[i].Question})
+			continue
+		} else if i >= len(to.Questions) {
+			changes = append(changes, RevisionChange{Field: "removed", Question: i, Old: from.Questions[i].Question})
+			continue
+		}
+		before, after := from.Questions[i], to.Questions[i]
+		if before.Question != after.Question {
+			changes = append(changes, RevisionChange{Field: "question", Question: i, Old: before.Question, New: after.Question})
+		}
+		if before.Kind() != after.Kind() {
+			changes = append(changes, RevisionChange{Field: "type", Question: i, Old: before.Kind(), New: after.Kind()})
+		}
+		if before.Passage != after.Passage {
+			changes = append(changes, RevisionChange{Field: "passage", Question: i, Old: before.Passage, New: after.Passage})
+		}
+		oldAnswers, newAnswers := strings.Join(before.Answers, " / "), strings.Join(after.Answers, " / ")
+		if oldAnswers != newAnswers {
+			changes = append(changes, RevisionChange{Field: "answers", Question: i, Old: oldAnswers, New: newAnswers})
+		}
+		if correctKey(before) != correctKey(after) {
+			changes = append(changes, RevisionChange{Field: "correct", Question: i,
+				Old: correctAnswer(before), New: correctAnswer(after)})
+		}
+		if rubricText(before) != rubricText(after) {
+			changes = append(changes, RevisionChange{Field: "rubric", Question: i, Old: rubricText(before), New: rubricText(after)})
+		}
+		if before.Explanation != after.Explanation {
+			changes = append(changes, RevisionChange{Field: "explanation", Question: i, Old: before.Explanation, New: after.Explanation})
+		}
+		if before.Solution != after.Solution {
+			changes = append(changes, RevisionChange{Field: "solution", Question: i, Old: before.Solution, New: after.Solution})
+		}
+		if before.Scoring != after.Scoring {
+			changes = append(changes, RevisionChange{Field: "scoring", Question: i, Old: before.Scoring, New: after.Scoring})
+		}
+		if before.Points() != after.Points() {
+			changes = append(changes, RevisionChange{Field: "weight", Question: i,
+				Old: strconv.Itoa(before.Points()), New: strconv.Itoa(after.Points())})
+		}
+		if before.Tolerance != after.Tolerance {
+			changes = append(changes, RevisionChange{Field: "tolerance", Question: i,
+				Old: strconv.FormatFloat(before.Tolerance, 'g', -1, 64), New: strconv.FormatFloat(after.Tolerance, 'g', -1, 64)})
+		}
+	}
+	return changes
+}
+
+func rubricText(question Question) string {
+	// e.g. "Thesis (2) / Evidence (4)"
+	rows := []string{}
+	for _, criterion := range question.Rubric {
+		rows = append(rows, criterion.Name+" ("+strconv.Itoa(criterion.Points)+")")
+	}
+	return strings.Join(rows, " / ")
+}
+
+func correctKey(question Question) string {
+	// Which choices are marked correct, for comparing.  Grid-in and ordering questions have no marked choices.
+	switch question.Kind() {
+	case ChoiceQuestion:
+		return strconv.Itoa(question.CorrectIndex)
+	case MultiSelectQuestion:
+		return fmt.Sprint(question.CorrectIndexes)
+	}
+	return ""
+}
+
+func correctAnswer(question Question) string {
+	// The correct choices as shown to people, e.g. "2 (Paris)"
+	indexes := []int{question.CorrectIndex}
+	if question.IsMultiSelect() {
+		indexes = question.CorrectIndexes
+	} else if question.Kind() != ChoiceQuestion {
+		return ""
+	}
+	result := []string{}
+	for _, i := range indexes {
+		shown := strconv.Itoa(i + 1)
+		if i >= 0 && i < len(question.Answers) {
+			shown += " (" + question.Answers[i] + ")"
+		}
+		result = append(result, shown)
+	}
+	return strings.Join(result, ", ")
+}
@@ -0,0 +1,197 @@
+package functions
+
+// Scaled scores for full-length tests.  A test's conversion tables turn raw scores (questions right, or points earned
+// when questions are weighted) into scores on the exam's own scale, like the tables at the back of an official
+// practice test: section scores of 200–800 that add up to a total of 400–1600, subscores of 1–15 and cross-test scores
+// of 10–40.  Every save of the tables is a new Scale
+// version.  A sitting is scored with the version that was latest when it started, or when it finished if the test had
+// no tables yet, so changing the tables later never changes a score a student has already seen.
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const SectionScore = "section"     // Scaled 200–800; the section scores add up to the total
+const Subscore = "subscore"        // Scaled 1–15
+const CrossTestScore = "crosstest" // Scaled 10–40
+
+const MaxTables = 12 // Conversion tables a test may have
+
+type Conversion struct { // One table: the scaled score for each raw score
+	Name     string `bson:"name"`     // Such as "Math" or "Command of Evidence"
+	Kind     string `bson:"kind"`     // SectionScore, Subscore or CrossTestScore
+	Sections []int  `bson:"sections"` // The test sections, counting from 1, whose questions right make up the raw score
+	Scaled   []int  `bson:"scaled"`   // Scaled[raw]; raw scores past the end get the last entry
+}
+
+type Scale struct { // A test's conversion tables as saved at one Version
+	Test    string       `bson:"test"`
+	Version int          `bson:"version"` // 1 for the first tables saved, then one more for each save
+	Tables  []Conversion `bson:"tables"`
+	Author  string       `bson:"author"`  // Username of the admin who saved this version
+	Created time.Time    `bson:"created"` // When this version was saved
+}
+
+func ScaleRange(kind string) (int, int) {
+	// The lowest and highest scaled scores of a kind of table
+	switch kind {
+	case Subscore:
+		return 1, 15
+	case CrossTestScore:
+		return 10, 40
+	}
+	return 200, 800
+}
+
+func (table Conversion) Convert(raw int) int {
+	if len(table.Scaled) == 0 {
+		return 0
+	} else if raw < 0 {
+		raw = 0
+	} else if raw >= len(table.Scaled) {
+		raw = len(table.Scaled) - 1
+	}
+	return table.Scaled[raw]
+}
+
+func (table Conversion) Validate(sections int) error {
+	// sections is how many the test has
+	low, high := ScaleRange(table.Kind)
+	if table.Name == "" {
+		return errors.New("every table needs a name")
+	} else if table.Kind != SectionScore && table.Kind != Subscore && table.Kind != CrossTestScore {
+		return fmt.Errorf("%s: unknown kind of score", table.Name)
+	} else if len(table.Sections) == 0 {
+		return fmt.Errorf("%s: choose the sections it counts", table.Name)
+	} else if len(table.Scaled) == 0 {
+		return fmt.Errorf("%s: the table is empty", table.Name)
+	}
+	for _, section := range table.Sections {
+		if section < 1 || section > sections {
+			return fmt.Errorf("%s: the test has no section %d", table.Name, section)
+		}
+	}
+	for raw, scaled := range table.Scaled {
+		if scaled < low || scaled > high {
+			return fmt.Errorf("%s: scaled scores run from %d to %d, not %d", table.Name, low, high, scaled)
+		} else if raw > 0 && scaled < table.Scaled[raw-1] {
+			return fmt.Errorf("%s: a raw score of %d can't scale lower than %d", table.Name, raw, raw-1)
+		}
+	}
+	return nil
+}
+
+func (scale Scale) Validate(sections int) error {
+	if len(scale.Tables) > MaxTables {
+		return fmt.Errorf("a test can have at most %d tables", MaxTables)
+	}
+	for _, table := range scale.Tables {
+		if err := table.Validate(sections); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type ScaleForm struct { // The form on /scale/{id}: a table per row of Names, Kinds, Sections and Scaled, in order
+	Names    []string `schema:"names"` // Rows with no name are left out
+	Kinds    []string `schema:"kinds"`
+	Sections []string `schema:"sections"` // Section numbers, such as "1 2"
+	Scaled   []string `schema:"scaled"`   // The scaled score for raw scores 0, 1, 2 and so on, separated by spaces, commas or lines
+	Version  int      `schema:"version"`  // The latest version when the form was shown
+}
+
+func splitNumbers(s string) ([]int, error) {
+	result := []int{}
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\r' || r == '\n' }) {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, n)
+	}
+	return result, nil
+}
+
+func (form ScaleForm) Apply(scale *Scale, sections int) error {
+	// Puts the form's tables into scale as the version after form.Version, or says what is wrong with them
+	tables := []Conversion{}
+	for i := 0; i < len(form.Names); i++ {
+		table := Conversion{Name: strings.TrimSpace(form.Names[i]), Kind: SectionScore}
+		if table.Name == "" {
+			continue
+		}
+		var err error
+		if i < len(form.Kinds) {
+			table.Kind = form.Kinds[i]
+		}
+		if i < len(form.Sections) {
+			table.Sections, err = splitNumbers(form.Sections[i])
+			if err != nil {
+				return fmt.Errorf("%s: sections are numbers, such as 1 2", table.Name)
+			}
+		}
+		if i < len(form.Scaled) {
+			table.Scaled, err = splitNumbers(form.Scaled[i])
+			if err != nil {
+				return fmt.Errorf("%s: the table is whole numbers separated by spaces", table.Name)
+			}
+		}
+		tables = append(tables, table)
+	}
+	scale.Tables = tables
+	scale.Version = form.Version + 1
+	return scale.Validate(sections)
+}
+
+func (section SectionResult) Raw() int {
+	// Points earned, which unless questions are weighted is questions right, counting partial credit towards the nearest
+	// whole point
+	return int(math.Floor(float64(section.Earned()) + 0.5))
+}
+
+type ScaledScore struct { // One table's score for a sitting
+	Name   string
+	Kind   string
+	Raw    int
+	Scaled int
+	Min    int // The table's range, for showing "out of"
+	Max    int
+}
+
+type ScaledResult struct { // A sitting's scores on the exam's scale
+	Version   int           // Of the tables used; 0 if the test has none
+	Sections  []ScaledScore // Section scores, which add up to Total
+	Subscores []ScaledScore // Subscores and cross-test scores
+	Total     int
+	TotalMin  int
+	TotalMax  int
+}
+
+func (scale Scale) Score(result TestResult) ScaledResult {
+	scaled := ScaledResult{Version: scale.Version}
+	for _, table := range scale.Tables {
+		score := ScaledScore{Name: table.Name, Kind: table.Kind}
+		score.Min, score.Max = ScaleRange(table.Kind)
+		for _, section := range table.Sections {
+			if section >= 1 && section <= len(result.Sections) {
+				score.Raw += result.Sections[section-1].Raw()
+			}
+		}
+		score.Scaled = table.Convert(score.Raw)
+		if table.Kind == SectionScore {
+			scaled.Sections = append(scaled.Sections, score)
+			scaled.Total += score.Scaled
+			scaled.TotalMin += score.Min
+			scaled.TotalMax += score.Max
+		} else {
+			scaled.Subscores = append(scaled.Subscores, score)
+		}
+	}
+	return scaled
+}
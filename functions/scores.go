@@ -0,0 +1,89 @@
+package functions
+
+// A student's scores quiz by quiz and subject by subject, worked out from their attempts.
+// Only graded attempts count; those still waiting on essays are left out until they are scored, and those still in
+// progress aren't counted at all.
+
+import (
+	"sort"
+	"time"
+)
+
+type ScoreStats struct { // Scores on one quiz, or on every quiz in one subject
+	Name     string // The quiz's title as last taken, or the subject
+	Quiz     QuizID // For per-quiz rows
+	Attempts int
+	Best     float32
+	Latest   float32
+	Average  float32
+	Taken    time.Time // When the latest attempt finished
+}
+
+type ScoreReport struct {
+	Username string
+	Quizzes  []ScoreStats // Most recently taken first
+	Subjects []ScoreStats // By subject
+	Pending  int          // Attempts waiting on essays
+	Earlier  float32      // Best score kept from before attempts were, if any
+}
+
+const NoSubject = "Other" // Shown for quizzes without a subject
+
+func NewScoreReport(username string, attempts []Attempt) ScoreReport {
+	// attempts are the user's, newest first, as RetrieveAttempts returns them
+	report := ScoreReport{Username: username, Quizzes: []ScoreStats{}, Subjects: []ScoreStats{}}
+	quizzes := map[QuizID]int{}  // Quiz -> index in report.Quizzes
+	subjects := map[string]int{} // Subject -> index in report.Subjects
+	for _, attempt := range attempts {
+		if attempt.InProgress() {
+			continue
+		} else if !attempt.Graded {
+			report.Pending++
+			continue
+		} else if attempt.Legacy() {
+			if attempt.Score > report.Earlier {
+				report.Earlier = attempt.Score
+			}
+			continue
+		}
+		i, ok := quizzes[attempt.Quiz]
+		if !ok {
+			i = len(report.Quizzes)
+			quizzes[attempt.Quiz] = i
+			report.Quizzes = append(report.Quizzes, ScoreStats{Name: attempt.Title, Quiz: attempt.Quiz})
+		}
+		report.Quizzes[i].add(attempt)
+		subject := attempt.Subject
+		if subject == "" {
+			subject = NoSubject
+		}
+		j, ok := subjects[subject]
+		if !ok {
+			j = len(report.Subjects)
+			subjects[subject] = j
+			report.Subjects = append(report.Subjects, ScoreStats{Name: subject})
+		}
+		report.Subjects[j].add(attempt)
+	}
+	sort.Stable(statsSorter(report.Subjects))
+	return report
+}
+
+func (stats *ScoreStats) add(attempt Attempt) {
+	// Counts one more attempt, older than any counted so far
+	if stats.Attempts == 0 {
+		stats.Latest = attempt.Score
+		stats.Taken = attempt.Finished
+	}
+	if stats.Attempts == 0 || attempt.Score > stats.Best {
+		stats.Best = attempt.Score
+	}
+	stats.Average = (stats.Average*float32(stats.Attempts) + attempt.Score) / float32(stats.Attempts+1)
+	stats.Attempts++
+}
+
+type statsSorter []ScoreStats // By name
+
+func (s statsSorter) Len() int           { return len(s) }
+func (s statsSorter) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s statsSorter) Less(i, j int) bool { return s[i].Name < s[j].Name }
@@ -0,0 +1,272 @@
+package functions
+
+// Full-text search over quiz titles, question text and answer choices.
+// Mongo uses a text index (see migrate.go); the other backends keep a SearchIndex in memory.
+// Snippets are highlighted the same way for every backend.  A grid-in's answers are the responses it accepts, so
+// they are never searched: students could look them up.
+
+import (
+	"html/template"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const DefaultSearchLimit = 20
+const snippetLength = 160 // Characters of context kept around the first match
+
+// Matches in a title count for more than in a question, which count for more than in an answer
+var searchWeights = map[string]float64{"title": 3, "question": 2, "answer": 1}
+
+type SearchQuery struct {
+	Text          string `schema:"q"`
+	PublishedOnly bool   `schema:"-"` // Set for students, who can't see unpublished quizzes
+	Limit         int    `schema:"limit"`
+}
+
+func (query SearchQuery) Normalize() SearchQuery {
+	// Fills in the default limit and caps it like a listing page
+	if query.Limit < 1 {
+		query.Limit = DefaultSearchLimit
+	} else if query.Limit > MaxPerPage {
+		query.Limit = MaxPerPage
+	}
+	return query
+}
+
+type SearchMatch struct { // One field of a quiz that matched
+	Field    string        // "title", "question" or "answer"
+	Question int           // Index of the question, for "question" and "answer"
+	Snippet  template.HTML // Escaped text with the matching words in <mark>
+}
+
+func (match SearchMatch) Number() int {
+	// Question number as shown to people, starting at 1
+	return match.Question + 1
+}
+
+type SearchResult struct {
+	Quiz    Quiz
+	Score   float64 // Higher is better
+	Matches []SearchMatch
+}
+
+func SearchTerms(text string) []string {
+	// Splits text into lower-case words, folding simple plurals so "equations" finds "equation"
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i := 0; i < len(words); i++ {
+		words[i] = stem(words[i])
+	}
+	return words
+}
+
+func uniqueTerms(terms []string) []string {
+	seen := map[string]bool{}
+	result := []string{}
+	for i := 0; i < len(terms); i++ {
+		if !seen[terms[i]] {
+			seen[terms[i]] = true
+			result = append(result, terms[i])
+		}
+	}
+	return result
+}
+
+func stem(word string) string {
+	if len(word) > 3 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") {
+		return word[:len(word)-1]
+	}
+	return word
+}
+
+func Highlight(text string, terms []string) (template.HTML, bool) {
+	// Escapes text and marks every word whose stem is one of terms.  Long text is cut down to the part around the first match.
+	wanted := map[string]bool{}
+	for i := 0; i < len(terms); i++ {
+		wanted[terms[i]] = true
+	}
+	// Find word boundaries by hand so the original spelling and punctuation are kept
+	type span struct{ start, end int }
+	marks := []span{}
+	start := -1
+	runes := []rune(text)
+	for i := 0; i <= len(runes); i++ {
+		inWord := i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]))
+		if inWord && start < 0 {
+			start = i
+		} else if !inWord && start >= 0 {
+			if wanted[stem(strings.ToLower(string(runes[start:i])))] {
+				marks = append(marks, span{start, i})
+			}
+			start = -1
+		}
+	}
+	if len(marks) == 0 {
+		return template.HTML(template.HTMLEscapeString(text)), false
+	}
+	from, to := 0, len(runes)
+	if len(runes) > snippetLength {
+		from = marks[0].start - snippetLength/4
+		if from < 0 {
+			from = 0
+		}
+		to = from + snippetLength
+		if to > len(runes) {
+			to = len(runes)
+		}
+	}
+	result := ""
+	if from > 0 {
+		result += "&hellip;"
+	}
+	at := from
+	for i := 0; i < len(marks); i++ {
+		if marks[i].start < from || marks[i].end > to {
+			continue
+		}
+		result += template.HTMLEscapeString(string(runes[at:marks[i].start]))
+		result += "<mark>" + template.HTMLEscapeString(string(runes[marks[i].start:marks[i].end])) + "</mark>"
+		at = marks[i].end
+	}
+	result += template.HTMLEscapeString(string(runes[at:to]))
+	if to < len(runes) {
+		result += "&hellip;"
+	}
+	return template.HTML(result), true
+}
+
+func searchableAnswers(question Question) []string {
+	if question.IsGridIn() {
+		return nil
+	}
+	return question.Answers
+}
+
+func FindMatches(quiz Quiz, terms []string) []SearchMatch {
+	// Every field of quiz that contains one of terms, with its highlighted snippet
+	matches := []SearchMatch{}
+	if snippet, ok := Highlight(quiz.Title, terms); ok {
+		matches = append(matches, SearchMatch{Field: "title", Snippet: snippet})
+	}
+	for i := 0; i < len(quiz.Questions); i++ {
+		if snippet, ok := Highlight(quiz.Questions[i].Question, terms); ok {
+			matches = append(matches, SearchMatch{Field: "question", Question: i, Snippet: snippet})
+		}
+		answers := searchableAnswers(quiz.Questions[i])
+		for j := 0; j < len(answers); j++ {
+			if snippet, ok := Highlight(answers[j], terms); ok {
+				matches = append(matches, SearchMatch{Field: "answer", Question: i, Snippet: snippet})
+			}
+		}
+	}
+	return matches
+}
+
+type SearchIndex struct { // In-process inverted index for backends without their own full-text search.  Safe for concurrent use.
+	mutex   sync.RWMutex
+	quizzes map[QuizID]Quiz
+	terms   map[string]map[QuizID]float64 // term -> quiz -> weighted number of occurrences
+}
+
+func NewSearchIndex() *SearchIndex {
+	return &SearchIndex{quizzes: map[QuizID]Quiz{}, terms: map[string]map[QuizID]float64{}}
+}
+
+func (index *SearchIndex) Update(quiz Quiz) {
+	// Adds quiz, replacing any earlier version of it
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	index.remove(quiz.Id)
+	index.quizzes[quiz.Id] = quiz
+	add := func(text string, field string) {
+		words := SearchTerms(text)
+		for i := 0; i < len(words); i++ {
+			if index.terms[words[i]] == nil {
+				index.terms[words[i]] = map[QuizID]float64{}
+			}
+			index.terms[words[i]][quiz.Id] += searchWeights[field]
+		}
+	}
+	add(quiz.Title, "title")
+	for i := 0; i < len(quiz.Questions); i++ {
+		add(quiz.Questions[i].Question, "question")
+		answers := searchableAnswers(quiz.Questions[i])
+		for j := 0; j < len(answers); j++ {
+			add(answers[j], "answer")
+		}
+	}
+}
+
+func (index *SearchIndex) Remove(id QuizID) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	index.remove(id)
+}
+
+func (index *SearchIndex) remove(id QuizID) {
+	if _, ok := index.quizzes[id]; !ok {
+		return
+	}
+	delete(index.quizzes, id)
+	for term, postings := range index.terms {
+		delete(postings, id)
+		if len(postings) == 0 {
+			delete(index.terms, term)
+		}
+	}
+}
+
+func (index *SearchIndex) Search(query SearchQuery) []SearchResult {
+	// Ranks quizzes by tf-idf over the query terms; quizzes matching more of the terms come first
+	query = query.Normalize()
+	terms := uniqueTerms(SearchTerms(query.Text))
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+	scores := map[QuizID]float64{}
+	found := map[QuizID]int{}
+	for i := 0; i < len(terms); i++ {
+		postings := index.terms[terms[i]]
+		idf := math.Log(1 + float64(len(index.quizzes))/float64(1+len(postings)))
+		for id, weight := range postings {
+			scores[id] += weight * idf
+			found[id]++
+		}
+	}
+	results := []SearchResult{}
+	for id, score := range scores {
+		quiz := index.quizzes[id]
+		if query.PublishedOnly && !quiz.Published {
+			continue
+		}
+		score *= float64(found[id]) / float64(len(terms))
+		results = append(results, SearchResult{Quiz: quiz, Score: score})
+	}
+	SortSearchResults(results)
+	if len(results) > query.Limit {
+		results = results[:query.Limit]
+	}
+	for i := 0; i < len(results); i++ {
+		results[i].Quiz = copyQuiz(results[i].Quiz)
+		results[i].Matches = FindMatches(results[i].Quiz, terms)
+	}
+	return results
+}
+
+type searchSorter []SearchResult
+
+func (s searchSorter) Len() int      { return len(s) }
+func (s searchSorter) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s searchSorter) Less(i, j int) bool {
+	if s[i].Score != s[j].Score {
+		return s[i].Score > s[j].Score
+	}
+	return s[i].Quiz.Title < s[j].Quiz.Title
+}
+
+func SortSearchResults(results []SearchResult) {
+	sort.Stable(searchSorter(results))
+}
@@ -0,0 +1,1519 @@
+package functions
+
+// SQLite backend for the Store interface, for schools that can't run MongoDB.
+// Everything lives in one file; the schema is created and upgraded on open (see sqliteMigrations).
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/mattn/go-sqlite3"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Schema changes, oldest first.  The database's PRAGMA user_version records how many have been applied.
+// Never edit an entry once it has shipped; append a new one instead.
+var sqliteMigrations = []string{
+	// 1: quizzes, questions with their answer choices, users and score attempts
+	`CREATE TABLE quizzes (
+		id TEXT PRIMARY KEY,
+		title TEXT NOT NULL
+	);
+	CREATE TABLE questions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		quiz_id TEXT NOT NULL REFERENCES quizzes(id) ON DELETE CASCADE,
+		position INTEGER NOT NULL,
+		question TEXT NOT NULL,
+		correct INTEGER NOT NULL,
+		UNIQUE (quiz_id, position)
+	);
+	CREATE TABLE answers (
+		question_id INTEGER NOT NULL REFERENCES questions(id) ON DELETE CASCADE,
+		position INTEGER NOT NULL,
+		answer TEXT NOT NULL,
+		PRIMARY KEY (question_id, position)
+	);
+	CREATE TABLE users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT NOT NULL UNIQUE,
+		password BLOB NOT NULL,
+		role TEXT NOT NULL
+	);
+	CREATE TABLE attempts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		score REAL NOT NULL,
+		created INTEGER NOT NULL
+	);
+	CREATE INDEX attempts_user ON attempts(user_id);`,
+	// 2: listing fields.  Existing quizzes count as published.
+	`ALTER TABLE quizzes ADD COLUMN subject TEXT NOT NULL DEFAULT '';
+	ALTER TABLE quizzes ADD COLUMN difficulty TEXT NOT NULL DEFAULT '';
+	ALTER TABLE quizzes ADD COLUMN author TEXT NOT NULL DEFAULT '';
+	ALTER TABLE quizzes ADD COLUMN published INTEGER NOT NULL DEFAULT 1;
+	ALTER TABLE quizzes ADD COLUMN created INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE quizzes ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+	CREATE INDEX quizzes_title ON quizzes(title);
+	CREATE INDEX quizzes_created ON quizzes(created);
+	CREATE INDEX quizzes_popular ON quizzes(attempts, title);`,
+	// 3: quiz versions for conditional updates
+	`ALTER TABLE quizzes ADD COLUMN version INTEGER NOT NULL DEFAULT 1;`,
+	// 4: revision history, laid out like quizzes/questions/answers.  Existing quizzes get a revision for their current version.
+	`CREATE TABLE quiz_revisions (
+		quiz_id TEXT NOT NULL REFERENCES quizzes(id) ON DELETE CASCADE,
+		version INTEGER NOT NULL,
+		title TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		difficulty TEXT NOT NULL,
+		published INTEGER NOT NULL,
+		created INTEGER NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (quiz_id, version)
+	);
+	CREATE TABLE revision_questions (
+		quiz_id TEXT NOT NULL,
+		version INTEGER NOT NULL,
+		position INTEGER NOT NULL,
+		question TEXT NOT NULL,
+		correct INTEGER NOT NULL,
+		PRIMARY KEY (quiz_id, version, position),
+		FOREIGN KEY (quiz_id, version) REFERENCES quiz_revisions(quiz_id, version) ON DELETE CASCADE
+	);
+	CREATE TABLE revision_answers (
+		quiz_id TEXT NOT NULL,
+		version INTEGER NOT NULL,
+		question INTEGER NOT NULL,
+		position INTEGER NOT NULL,
+		answer TEXT NOT NULL,
+		PRIMARY KEY (quiz_id, version, question, position),
+		FOREIGN KEY (quiz_id, version, question) REFERENCES revision_questions(quiz_id, version, position) ON DELETE CASCADE
+	);
+	INSERT INTO quiz_revisions (quiz_id, version, title, subject, difficulty, published, created, attempts)
+		SELECT id, version, title, subject, difficulty, published, created, attempts FROM quizzes;
+	INSERT INTO revision_questions (quiz_id, version, position, question, correct)
+		SELECT q.quiz_id, z.version, q.position, q.question, q.correct FROM questions q JOIN quizzes z ON z.id = q.quiz_id;
+	INSERT INTO revision_answers (quiz_id, version, question, position, answer)
+		SELECT q.quiz_id, z.version, q.position, a.position, a.answer
+		FROM answers a JOIN questions q ON q.id = a.question_id JOIN quizzes z ON z.id = q.quiz_id;`,
+	// 5: stable question IDs, in the same 24-hex-digit form NewQuestionID makes.  Revisions of a question that
+	// hasn't changed since share its ID.
+	`ALTER TABLE questions ADD COLUMN uid TEXT NOT NULL DEFAULT '';
+	UPDATE questions SET uid = lower(hex(randomblob(12)));
+	ALTER TABLE revision_questions ADD COLUMN uid TEXT NOT NULL DEFAULT '';
+	UPDATE revision_questions SET uid = COALESCE((SELECT q.uid FROM questions q WHERE q.quiz_id = revision_questions.quiz_id
+		AND q.position = revision_questions.position AND q.question = revision_questions.question), lower(hex(randomblob(12))));`,
+	// 6: question types.  Existing questions are multiple choice.
+	`ALTER TABLE questions ADD COLUMN type TEXT NOT NULL DEFAULT '';
+	ALTER TABLE questions ADD COLUMN tolerance REAL NOT NULL DEFAULT 0;
+	ALTER TABLE revision_questions ADD COLUMN type TEXT NOT NULL DEFAULT '';
+	ALTER TABLE revision_questions ADD COLUMN tolerance REAL NOT NULL DEFAULT 0;`,
+	// 7: multi-select and ordering questions.  A multi-select question's correct choices are flagged in answers.
+	`ALTER TABLE questions ADD COLUMN scoring TEXT NOT NULL DEFAULT '';
+	ALTER TABLE answers ADD COLUMN correct INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE revision_questions ADD COLUMN scoring TEXT NOT NULL DEFAULT '';
+	ALTER TABLE revision_answers ADD COLUMN correct INTEGER NOT NULL DEFAULT 0;`,
+	// 8: reading passages.  Questions refer to them by ID, or by '' for none.
+	`CREATE TABLE passages (
+		id TEXT PRIMARY KEY,
+		title TEXT NOT NULL,
+		text TEXT NOT NULL,
+		created INTEGER NOT NULL
+	);
+	CREATE INDEX passages_title ON passages(title);
+	ALTER TABLE questions ADD COLUMN passage TEXT NOT NULL DEFAULT '';
+	ALTER TABLE revision_questions ADD COLUMN passage TEXT NOT NULL DEFAULT '';`,
+	// 9: essay rubrics, and submissions waiting for their essays to be graded.  A submission's essays are only ever
+	// read and written whole, so they are kept as JSON.  Submissions outlive their quiz.
+	`CREATE TABLE criteria (
+		question_id INTEGER NOT NULL REFERENCES questions(id) ON DELETE CASCADE,
+		position INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		points INTEGER NOT NULL,
+		PRIMARY KEY (question_id, position)
+	);
+	CREATE TABLE revision_criteria (
+		quiz_id TEXT NOT NULL,
+		version INTEGER NOT NULL,
+		question INTEGER NOT NULL,
+		position INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		points INTEGER NOT NULL,
+		PRIMARY KEY (quiz_id, version, question, position),
+		FOREIGN KEY (quiz_id, version, question) REFERENCES revision_questions(quiz_id, version, position) ON DELETE CASCADE
+	);
+	CREATE TABLE submissions (
+		id TEXT PRIMARY KEY,
+		quiz_id TEXT NOT NULL,
+		version INTEGER NOT NULL,
+		title TEXT NOT NULL,
+		username TEXT NOT NULL,
+		created INTEGER NOT NULL,
+		questions INTEGER NOT NULL,
+		credit REAL NOT NULL,
+		essays TEXT NOT NULL,
+		graded INTEGER NOT NULL,
+		score REAL NOT NULL
+	);
+	CREATE INDEX submissions_queue ON submissions(graded, created);`,
+	// 10: explanations and when students may review them.  review_date is a Unix time, 0 for none.
+	`ALTER TABLE quizzes ADD COLUMN review TEXT NOT NULL DEFAULT '';
+	ALTER TABLE quizzes ADD COLUMN review_date INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE questions ADD COLUMN explanation TEXT NOT NULL DEFAULT '';
+	ALTER TABLE questions ADD COLUMN solution TEXT NOT NULL DEFAULT '';
+	ALTER TABLE revision_questions ADD COLUMN explanation TEXT NOT NULL DEFAULT '';
+	ALTER TABLE revision_questions ADD COLUMN solution TEXT NOT NULL DEFAULT '';`,
+	// 11: full attempts.  Scores recorded before this become attempts with no quiz; created is when an attempt finished.
+	`ALTER TABLE attempts ADD COLUMN uid TEXT NOT NULL DEFAULT '';
+	UPDATE attempts SET uid = lower(hex(randomblob(12)));
+	CREATE UNIQUE INDEX attempts_uid ON attempts(uid);
+	ALTER TABLE attempts ADD COLUMN quiz_id TEXT NOT NULL DEFAULT '';
+	ALTER TABLE attempts ADD COLUMN version INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE attempts ADD COLUMN title TEXT NOT NULL DEFAULT '';
+	ALTER TABLE attempts ADD COLUMN started INTEGER NOT NULL DEFAULT 0;
+	UPDATE attempts SET started = created;
+	ALTER TABLE attempts ADD COLUMN responses TEXT NOT NULL DEFAULT '{}';
+	ALTER TABLE attempts ADD COLUMN graded INTEGER NOT NULL DEFAULT 1;
+	ALTER TABLE attempts ADD COLUMN submission TEXT NOT NULL DEFAULT '';
+	CREATE INDEX attempts_submission ON attempts(submission);`,
+	// 12: the quiz's subject on each attempt, for scores by subject
+	`ALTER TABLE attempts ADD COLUMN subject TEXT NOT NULL DEFAULT '';
+	UPDATE attempts SET subject = COALESCE((SELECT r.subject FROM quiz_revisions r
+		WHERE r.quiz_id = attempts.quiz_id AND r.version = attempts.version), '');`,
+	// 13: timed quizzes.  An attempt in progress has created 0; deadline is a Unix time, 0 for untimed.
+	`ALTER TABLE quizzes ADD COLUMN time_limit INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE attempts ADD COLUMN deadline INTEGER NOT NULL DEFAULT 0;
+	CREATE INDEX attempts_open ON attempts(user_id, quiz_id, created);`,
+	// 14: full-length tests.  Sections are kept as JSON, as a sitting's attempts are; sitting is '' for attempts outside a test.
+	`CREATE TABLE tests (
+		id TEXT PRIMARY KEY,
+		title TEXT NOT NULL,
+		sections TEXT NOT NULL,
+		published INTEGER NOT NULL,
+		author TEXT NOT NULL,
+		created INTEGER NOT NULL,
+		version INTEGER NOT NULL
+	);
+	CREATE INDEX tests_title ON tests(title);
+	CREATE TABLE sittings (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		uid TEXT NOT NULL UNIQUE,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		test_id TEXT NOT NULL,
+		title TEXT NOT NULL,
+		sections TEXT NOT NULL,
+		attempts TEXT NOT NULL,
+		current INTEGER NOT NULL,
+		started INTEGER NOT NULL,
+		finished INTEGER NOT NULL
+	);
+	CREATE INDEX sittings_user ON sittings(user_id, started);
+	ALTER TABLE attempts ADD COLUMN sitting TEXT NOT NULL DEFAULT '';`,
+	// 15: versioned conversion tables for full-length tests, and the version each sitting is scored with (0 for the latest)
+	`CREATE TABLE scales (
+		test_id TEXT NOT NULL REFERENCES tests(id) ON DELETE CASCADE,
+		version INTEGER NOT NULL,
+		tables TEXT NOT NULL,
+		author TEXT NOT NULL,
+		created INTEGER NOT NULL,
+		PRIMARY KEY (test_id, version)
+	);
+	ALTER TABLE sittings ADD COLUMN scale INTEGER NOT NULL DEFAULT 0;`,
+	// 16: scoring policies and question weights.  A policy is only ever read and written whole, so it is kept as JSON;
+	// '{}' is the standard policy everything was scored with before.  possible is 0 for submissions out of questions.
+	`ALTER TABLE quizzes ADD COLUMN policy TEXT NOT NULL DEFAULT '{}';
+	ALTER TABLE quiz_revisions ADD COLUMN policy TEXT NOT NULL DEFAULT '{}';
+	ALTER TABLE questions ADD COLUMN weight INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE revision_questions ADD COLUMN weight INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE submissions ADD COLUMN possible REAL NOT NULL DEFAULT 0;
+	ALTER TABLE submissions ADD COLUMN policy TEXT NOT NULL DEFAULT '{}';
+	ALTER TABLE attempts ADD COLUMN policy TEXT NOT NULL DEFAULT '{}';`,
+	// 17: revisions that attempts were graded against outlive their quiz, so quiz_revisions loses its cascade from
+	// quizzes.  SQLite can't drop a constraint, so the table is rebuilt.
+	`CREATE TABLE quiz_revisions_new (
+		quiz_id TEXT NOT NULL,
+		version INTEGER NOT NULL,
+		title TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		difficulty TEXT NOT NULL,
+		published INTEGER NOT NULL,
+		created INTEGER NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		policy TEXT NOT NULL DEFAULT '{}',
+		PRIMARY KEY (quiz_id, version)
+	);
+	INSERT INTO quiz_revisions_new (` + revisionColumns + `) SELECT ` + revisionColumns + ` FROM quiz_revisions;
+	DROP TABLE quiz_revisions;
+	ALTER TABLE quiz_revisions_new RENAME TO quiz_revisions;`,
+	// 18: how many times each submission has been saved, so two graders can't overwrite each other's scores
+	`ALTER TABLE submissions ADD COLUMN updates INTEGER NOT NULL DEFAULT 0;`,
+}
+
+type SQLiteStore struct { // Store backed by a SQLite database file
+	db      *sql.DB
+	index   *SearchIndex // Kept in step with the quizzes table for SearchQuizzes
+	writing sync.Mutex   // Held across each quiz write and its index update, so the index can't fall behind
+}
+
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	// Opens (creating if needed) the database at path and brings its schema up to date
+	db, err := sql.Open("sqlite3", "file:"+path+"?_foreign_keys=on&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1) // SQLite only allows one writer anyway, and ":memory:" databases are per-connection
+	store := &SQLiteStore{db: db, index: NewSearchIndex()}
+	err = store.migrate()
+	if err == nil {
+		err = store.buildIndex()
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+func (store *SQLiteStore) Close() {
+	store.db.Close()
+}
+
+func (store *SQLiteStore) migrate() error {
+	// Applies every migration newer than the database's user_version, each in its own transaction.  Foreign keys are
+	// off meanwhile, so that a migration rebuilding a table doesn't fire its children's ON DELETE CASCADE; the
+	// pragma is a no-op inside a transaction, and there is only the one connection.
+	var version int
+	err := store.db.QueryRow("PRAGMA user_version").Scan(&version)
+	if err != nil {
+		return err
+	}
+	if version == len(sqliteMigrations) {
+		return nil
+	}
+	_, err = store.db.Exec("PRAGMA foreign_keys = OFF")
+	if err != nil {
+		return err
+	}
+	defer store.db.Exec("PRAGMA foreign_keys = ON")
+	for ; version < len(sqliteMigrations); version++ {
+		tx, err := store.db.Begin()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(sqliteMigrations[version])
+		if err == nil {
+			// PRAGMA can't take a placeholder, but version is our own integer
+			_, err = tx.Exec("PRAGMA user_version = " + strconv.Itoa(version+1))
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (store *SQLiteStore) buildIndex() error {
+	// Loads every quiz into the search index
+	rows, err := store.db.Query("SELECT id FROM quizzes")
+	if err != nil {
+		return err
+	}
+	ids := []QuizID{}
+	for rows.Next() {
+		var id QuizID
+		err = rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for i := 0; i < len(ids); i++ {
+		err = store.reindex(ids[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (store *SQLiteStore) reindex(id QuizID) error {
+	quiz, err := store.RetrieveQuiz(id)
+	if err != nil {
+		return err
+	}
+	store.index.Update(quiz)
+	return nil
+}
+
+func sqliteError(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
+
+func policyJSON(policy ScoringPolicy) (string, error) {
+	// json.Marshal refuses a NaN or infinite penalty, which Validate keeps out of stored policies
+	encoded, err := json.Marshal(policy)
+	return string(encoded), err
+}
+
+const quizColumns = "id, title, subject, difficulty, author, published, created, attempts, version, review, review_date, time_limit, policy"
+
+type sqlScanner interface { // Either *sql.Row or *sql.Rows
+	Scan(dest ...interface{}) error
+}
+
+func scanQuiz(row sqlScanner) (Quiz, error) {
+	// Reads the quizColumns of one row
+	quiz := Quiz{}
+	var created, reviewDate int64
+	var policy string
+	err := row.Scan(&quiz.Id, &quiz.Title, &quiz.Subject, &quiz.Difficulty, &quiz.Author, &quiz.Published, &created, &quiz.Attempts, &quiz.Version,
+		&quiz.Review, &reviewDate, &quiz.TimeLimit, &policy)
+	if err != nil {
+		return quiz, err
+	}
+	quiz.Created = time.Unix(created, 0)
+	quiz.ReviewDate = unixTime(reviewDate)
+	return quiz, json.Unmarshal([]byte(policy), &quiz.Policy)
+}
+
+func unixTime(seconds int64) time.Time {
+	// The time stored as seconds, with 0 meaning none
+	if seconds == 0 {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0)
+}
+
+func unixSeconds(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+type sqlQuerier interface { // Either *sql.DB or *sql.Tx
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func loadQuestions(q sqlQuerier, quizID QuizID) ([]Question, error) {
+	// Reads a quiz's questions in order, with their answers
+	rows, err := q.Query("SELECT id, uid, question, correct, type, tolerance, scoring, passage, explanation, solution, weight FROM questions WHERE quiz_id = ? ORDER BY position", quizID)
+	if err != nil {
+		return nil, err
+	}
+	questions := []Question{}
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		question := Question{Answers: []string{}}
+		err = rows.Scan(&id, &question.Id, &question.Question, &question.CorrectIndex, &question.Type, &question.Tolerance, &question.Scoring,
+			&question.Passage, &question.Explanation, &question.Solution, &question.Weight)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		questions = append(questions, question)
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(ids); i++ {
+		rows, err = q.Query("SELECT answer, correct FROM answers WHERE question_id = ? ORDER BY position", ids[i])
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var answer string
+			var correct bool
+			err = rows.Scan(&answer, &correct)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if correct {
+				questions[i].CorrectIndexes = append(questions[i].CorrectIndexes, len(questions[i].Answers))
+			}
+			questions[i].Answers = append(questions[i].Answers, answer)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+		rows, err = q.Query("SELECT name, points FROM criteria WHERE question_id = ? ORDER BY position", ids[i])
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			criterion := Criterion{}
+			err = rows.Scan(&criterion.Name, &criterion.Points)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			questions[i].Rubric = append(questions[i].Rubric, criterion)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return questions, nil
+}
+
+func insertQuestion(q sqlQuerier, quizID QuizID, position int, question Question) error {
+	result, err := q.Exec(`INSERT INTO questions (quiz_id, position, uid, question, correct, type, tolerance, scoring, passage, explanation, solution, weight)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		quizID, position, question.Id, question.Question, question.CorrectIndex, question.Type, question.Tolerance, question.Scoring,
+		question.Passage, question.Explanation, question.Solution, question.Weight)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(question.Answers); i++ {
+		_, err = q.Exec("INSERT INTO answers (question_id, position, answer, correct) VALUES (?, ?, ?, ?)",
+			id, i, question.Answers[i], containsIndex(question.CorrectIndexes, i))
+		if err != nil {
+			return err
+		}
+	}
+	for i := 0; i < len(question.Rubric); i++ {
+		_, err = q.Exec("INSERT INTO criteria (question_id, position, name, points) VALUES (?, ?, ?, ?)",
+			id, i, question.Rubric[i].Name, question.Rubric[i].Points)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func bumpVersion(q sqlQuerier, id QuizID, version int) error {
+	// Moves the quiz past version, or tells why it couldn't: ErrConflict if it is at another version, ErrNotFound if it's gone
+	result, err := q.Exec("UPDATE quizzes SET version = version + 1 WHERE id = ? AND version = ?", id, version)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n > 0 {
+		return err
+	}
+	err = q.QueryRow("SELECT version FROM quizzes WHERE id = ?", id).Scan(&version)
+	if err != nil {
+		return sqliteError(err)
+	}
+	return ErrConflict
+}
+
+func snapshotQuiz(q sqlQuerier, id QuizID) error {
+	// Copies the quiz as it now is into the revision tables, under its current version
+	_, err := q.Exec(`INSERT INTO quiz_revisions (quiz_id, version, title, subject, difficulty, published, created, policy)
+		SELECT id, version, title, subject, difficulty, published, ?, policy FROM quizzes WHERE id = ?`, time.Now().Unix(), id)
+	if err == nil {
+		_, err = q.Exec(`INSERT INTO revision_questions (quiz_id, version, position, uid, question, correct, type, tolerance, scoring, passage,
+				explanation, solution, weight)
+			SELECT q.quiz_id, z.version, q.position, q.uid, q.question, q.correct, q.type, q.tolerance, q.scoring, q.passage,
+				q.explanation, q.solution, q.weight
+			FROM questions q JOIN quizzes z ON z.id = q.quiz_id
+			WHERE q.quiz_id = ?`, id)
+	}
+	if err == nil {
+		_, err = q.Exec(`INSERT INTO revision_answers (quiz_id, version, question, position, answer, correct)
+			SELECT q.quiz_id, z.version, q.position, a.position, a.answer, a.correct
+			FROM answers a JOIN questions q ON q.id = a.question_id JOIN quizzes z ON z.id = q.quiz_id
+			WHERE q.quiz_id = ?`, id)
+	}
+	if err == nil {
+		_, err = q.Exec(`INSERT INTO revision_criteria (quiz_id, version, question, position, name, points)
+			SELECT q.quiz_id, z.version, q.position, c.position, c.name, c.points
+			FROM criteria c JOIN questions q ON q.id = c.question_id JOIN quizzes z ON z.id = q.quiz_id
+			WHERE q.quiz_id = ?`, id)
+	}
+	return err
+}
+
+const revisionColumns = "quiz_id, version, title, subject, difficulty, published, created, attempts, policy"
+
+func scanRevision(row sqlScanner) (Revision, error) {
+	// Reads the revisionColumns of one row
+	revision := Revision{}
+	var created int64
+	var policy string
+	err := row.Scan(&revision.Quiz, &revision.Version, &revision.Title, &revision.Subject, &revision.Difficulty,
+		&revision.Published, &created, &revision.Attempts, &policy)
+	if err != nil {
+		return revision, err
+	}
+	revision.Created = time.Unix(created, 0)
+	return revision, json.Unmarshal([]byte(policy), &revision.Policy)
+}
+
+func loadRevisionQuestions(q sqlQuerier, quizID QuizID, version int) ([]Question, error) {
+	// Like loadQuestions, for one revision
+	rows, err := q.Query("SELECT uid, question, correct, type, tolerance, scoring, passage, explanation, solution, weight FROM revision_questions WHERE quiz_id = ? AND version = ? ORDER BY position",
+		quizID, version)
+	if err != nil {
+		return nil, err
+	}
+	questions := []Question{}
+	for rows.Next() {
+		question := Question{Answers: []string{}}
+		err = rows.Scan(&question.Id, &question.Question, &question.CorrectIndex, &question.Type, &question.Tolerance, &question.Scoring,
+			&question.Passage, &question.Explanation, &question.Solution, &question.Weight)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		questions = append(questions, question)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	// Question positions are always 0 to n-1, so they index questions directly
+	rows, err = q.Query("SELECT question, answer, correct FROM revision_answers WHERE quiz_id = ? AND version = ? ORDER BY question, position",
+		quizID, version)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var position int
+		var answer string
+		var correct bool
+		err = rows.Scan(&position, &answer, &correct)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if position < len(questions) {
+			question := &questions[position]
+			if correct {
+				question.CorrectIndexes = append(question.CorrectIndexes, len(question.Answers))
+			}
+			question.Answers = append(question.Answers, answer)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows, err = q.Query("SELECT question, name, points FROM revision_criteria WHERE quiz_id = ? AND version = ? ORDER BY question, position",
+		quizID, version)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var position int
+		criterion := Criterion{}
+		err = rows.Scan(&position, &criterion.Name, &criterion.Points)
+		if err != nil {
+			return nil, err
+		}
+		if position < len(questions) {
+			questions[position].Rubric = append(questions[position].Rubric, criterion)
+		}
+	}
+	return questions, rows.Err()
+}
+
+func (store *SQLiteStore) RetrieveRevisions(id QuizID) ([]Revision, error) {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	var exists int
+	err = tx.QueryRow("SELECT 1 FROM quizzes WHERE id = ?", id).Scan(&exists) // ErrNotFound for a missing quiz rather than no revisions
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	rows, err := tx.Query("SELECT "+revisionColumns+" FROM quiz_revisions WHERE quiz_id = ? ORDER BY version", id)
+	if err != nil {
+		return nil, err
+	}
+	result := []Revision{}
+	for rows.Next() {
+		revision, err := scanRevision(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		result = append(result, revision)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(result); i++ {
+		result[i].Questions, err = loadRevisionQuestions(tx, id, result[i].Version)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (store *SQLiteStore) RetrieveRevision(id QuizID, version int) (Revision, error) {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return Revision{}, err
+	}
+	defer tx.Rollback()
+	result, err := scanRevision(tx.QueryRow("SELECT "+revisionColumns+" FROM quiz_revisions WHERE quiz_id = ? AND version = ?", id, version))
+	if err != nil {
+		return Revision{}, sqliteError(err)
+	}
+	result.Questions, err = loadRevisionQuestions(tx, id, version)
+	if err != nil {
+		return Revision{}, err
+	}
+	return result, nil
+}
+
+func (store *SQLiteStore) RetrieveQuiz(target QuizID) (Quiz, error) {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return Quiz{}, err
+	}
+	defer tx.Rollback() // Read-only; the transaction is just for a consistent view
+	result, err := scanQuiz(tx.QueryRow("SELECT "+quizColumns+" FROM quizzes WHERE id = ?", target))
+	if err != nil {
+		return Quiz{}, sqliteError(err)
+	}
+	result.Questions, err = loadQuestions(tx, target)
+	if err != nil {
+		return Quiz{}, err
+	}
+	return result, nil
+}
+
+func (store *SQLiteStore) RetrieveQuizzes(query QuizQuery) (QuizPage, error) {
+	query = query.Normalize()
+	where := " WHERE 1"
+	args := []interface{}{}
+	for _, filter := range []struct{ column, value string }{
+		{"title", query.Title}, {"subject", query.Subject}, {"difficulty", query.Difficulty}, {"author", query.Author},
+	} {
+		if filter.value != "" {
+			where += " AND " + filter.column + " = ?"
+			args = append(args, filter.value)
+		}
+	}
+	if query.Published != "" {
+		where += " AND published = ?"
+		args = append(args, query.Published == "yes")
+	}
+	order := " ORDER BY title, rowid"
+	if query.Sort == "created" {
+		order = " ORDER BY created DESC, rowid DESC"
+	} else if query.Sort == "popular" {
+		order = " ORDER BY attempts DESC, title, rowid"
+	}
+	tx, err := store.db.Begin()
+	if err != nil {
+		return QuizPage{}, err
+	}
+	defer tx.Rollback()
+	page := QuizPage{Query: query, Quizzes: []Quiz{}}
+	err = tx.QueryRow("SELECT COUNT(*) FROM quizzes"+where, args...).Scan(&page.Total)
+	if err != nil {
+		return QuizPage{}, err
+	}
+	rows, err := tx.Query("SELECT "+quizColumns+" FROM quizzes"+where+order+" LIMIT ? OFFSET ?",
+		append(args, query.PerPage, query.Skip())...)
+	if err != nil {
+		return QuizPage{}, err
+	}
+	for rows.Next() {
+		quiz, err := scanQuiz(rows)
+		if err != nil {
+			rows.Close()
+			return QuizPage{}, err
+		}
+		page.Quizzes = append(page.Quizzes, quiz)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return QuizPage{}, err
+	}
+	for i := 0; i < len(page.Quizzes); i++ {
+		page.Quizzes[i].Questions, err = loadQuestions(tx, page.Quizzes[i].Id)
+		if err != nil {
+			return QuizPage{}, err
+		}
+	}
+	return page, nil
+}
+
+func (store *SQLiteStore) InsertQuiz(quiz DbQuiz) (QuizID, error) {
+	policy, err := policyJSON(quiz.Policy)
+	if err != nil {
+		return "", err
+	}
+	store.writing.Lock()
+	defer store.writing.Unlock()
+	tx, err := store.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	id := NewQuizID()
+	_, err = tx.Exec("INSERT INTO quizzes ("+quizColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, 0, 1, ?, ?, ?, ?)",
+		id, quiz.Title, quiz.Subject, quiz.Difficulty, quiz.Author, quiz.Published, time.Now().Unix(), quiz.Review, unixSeconds(quiz.ReviewDate), quiz.TimeLimit,
+		policy)
+	for i := 0; err == nil && i < len(quiz.Questions); i++ {
+		err = insertQuestion(tx, id, i, quiz.Questions[i])
+	}
+	if err == nil {
+		err = snapshotQuiz(tx, id)
+	}
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return "", err
+	}
+	return id, store.reindex(id)
+}
+
+func (store *SQLiteStore) UpdateQuiz(quiz Quiz) error {
+	// Replaces the details and every question of an existing quiz
+	policy, err := policyJSON(quiz.Policy)
+	if err != nil {
+		return err
+	}
+	store.writing.Lock()
+	defer store.writing.Unlock()
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	err = bumpVersion(tx, quiz.Id, quiz.Version)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("UPDATE quizzes SET title = ?, subject = ?, difficulty = ?, published = ?, review = ?, review_date = ?, time_limit = ?, policy = ? WHERE id = ?",
+		quiz.Title, quiz.Subject, quiz.Difficulty, quiz.Published, quiz.Review, unixSeconds(quiz.ReviewDate), quiz.TimeLimit, policy, quiz.Id)
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM questions WHERE quiz_id = ?", quiz.Id) // Answers cascade
+	}
+	for i := 0; err == nil && i < len(quiz.Questions); i++ {
+		err = insertQuestion(tx, quiz.Id, i, quiz.Questions[i])
+	}
+	if err == nil {
+		err = snapshotQuiz(tx, quiz.Id)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	return store.reindex(quiz.Id)
+}
+
+func (store *SQLiteStore) AddQuestion(id QuizID, version int, question Question) error {
+	store.writing.Lock()
+	defer store.writing.Unlock()
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	var count int
+	err = bumpVersion(tx, id, version)
+	if err == nil {
+		err = tx.QueryRow("SELECT COUNT(*) FROM questions WHERE quiz_id = ?", id).Scan(&count)
+	}
+	if err == nil {
+		err = insertQuestion(tx, id, count, question)
+	}
+	if err == nil {
+		err = snapshotQuiz(tx, id)
+	}
+	if err != nil {
+		tx.Rollback()
+		return sqliteError(err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	return store.reindex(id)
+}
+
+func (store *SQLiteStore) DeleteQuiz(id QuizID, version int) error {
+	// Questions and answers go with it through ON DELETE CASCADE.  Revisions that attempts were graded against are
+	// kept; the rest go, and their questions with them.
+	store.writing.Lock()
+	defer store.writing.Unlock()
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	err = bumpVersion(tx, id, version)
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM quiz_revisions WHERE quiz_id = ? AND version NOT IN (SELECT version FROM attempts WHERE quiz_id = ?)", id, id)
+	}
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM quizzes WHERE id = ?", id)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	store.index.Remove(id)
+	return nil
+}
+
+func (store *SQLiteStore) InsertPassage(passage Passage) (string, error) {
+	passage.Id = NewPassageID()
+	_, err := store.db.Exec("INSERT INTO passages (id, title, text, created) VALUES (?, ?, ?, ?)",
+		passage.Id, passage.Title, passage.Text, time.Now().Unix())
+	if err != nil {
+		return "", err
+	}
+	return passage.Id, nil
+}
+
+func scanPassage(row sqlScanner) (Passage, error) {
+	passage := Passage{}
+	var created int64
+	err := row.Scan(&passage.Id, &passage.Title, &passage.Text, &created)
+	passage.Created = time.Unix(created, 0)
+	return passage, err
+}
+
+func (store *SQLiteStore) RetrievePassage(id string) (Passage, error) {
+	passage, err := scanPassage(store.db.QueryRow("SELECT id, title, text, created FROM passages WHERE id = ?", id))
+	return passage, sqliteError(err)
+}
+
+func (store *SQLiteStore) RetrievePassages() ([]Passage, error) {
+	rows, err := store.db.Query("SELECT id, title, text, created FROM passages ORDER BY title")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []Passage{}
+	for rows.Next() {
+		passage, err := scanPassage(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, passage)
+	}
+	return result, rows.Err()
+}
+
+func (store *SQLiteStore) UpdatePassage(passage Passage) error {
+	result, err := store.db.Exec("UPDATE passages SET title = ?, text = ? WHERE id = ?", passage.Title, passage.Text, passage.Id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+const submissionColumns = "id, quiz_id, version, title, username, created, questions, credit, essays, graded, score, possible, policy, updates"
+
+func scanSubmission(row sqlScanner) (Submission, error) {
+	// Reads the submissionColumns of one row
+	submission := Submission{}
+	var created int64
+	var essays, policy string
+	err := row.Scan(&submission.Id, &submission.Quiz, &submission.Version, &submission.Title, &submission.Username, &created,
+		&submission.Questions, &submission.Credit, &essays, &submission.Graded, &submission.Score, &submission.Possible, &policy,
+		&submission.Updates)
+	if err != nil {
+		return submission, err
+	}
+	submission.Created = time.Unix(created, 0)
+	err = json.Unmarshal([]byte(policy), &submission.Policy)
+	if err != nil {
+		return submission, err
+	}
+	return submission, json.Unmarshal([]byte(essays), &submission.Essays)
+}
+
+func (store *SQLiteStore) InsertSubmission(submission Submission) (string, error) {
+	submission.Id = NewSubmissionID()
+	essays, err := json.Marshal(submission.Essays)
+	if err != nil {
+		return "", err
+	}
+	policy, err := policyJSON(submission.Policy)
+	if err != nil {
+		return "", err
+	}
+	_, err = store.db.Exec("INSERT INTO submissions ("+submissionColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0)",
+		submission.Id, submission.Quiz, submission.Version, submission.Title, submission.Username, time.Now().Unix(),
+		submission.Questions, submission.Credit, string(essays), submission.Graded, submission.Score, submission.Possible, policy)
+	if err != nil {
+		return "", err
+	}
+	return submission.Id, nil
+}
+
+func (store *SQLiteStore) RetrieveSubmission(id string) (Submission, error) {
+	submission, err := scanSubmission(store.db.QueryRow("SELECT "+submissionColumns+" FROM submissions WHERE id = ?", id))
+	return submission, sqliteError(err)
+}
+
+func (store *SQLiteStore) RetrievePendingSubmissions() ([]Submission, error) {
+	rows, err := store.db.Query("SELECT " + submissionColumns + " FROM submissions WHERE graded = 0 ORDER BY created, rowid")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []Submission{}
+	for rows.Next() {
+		submission, err := scanSubmission(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, submission)
+	}
+	return result, rows.Err()
+}
+
+func (store *SQLiteStore) UpdateSubmission(submission Submission) error {
+	essays, err := json.Marshal(submission.Essays)
+	if err != nil {
+		return err
+	}
+	result, err := store.db.Exec("UPDATE submissions SET credit = ?, essays = ?, graded = ?, score = ?, updates = updates + 1 WHERE id = ? AND graded = 0 AND updates = ?",
+		submission.Credit, string(essays), submission.Graded, submission.Score, submission.Id, submission.Updates)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n > 0 {
+		return err
+	}
+	var exists int
+	err = store.db.QueryRow("SELECT 1 FROM submissions WHERE id = ?", submission.Id).Scan(&exists)
+	if err != nil {
+		return sqliteError(err)
+	}
+	return ErrConflict
+}
+
+func (store *SQLiteStore) SearchQuizzes(query SearchQuery) ([]SearchResult, error) {
+	return store.index.Search(query), nil
+}
+
+func (store *SQLiteStore) CountAttempt(id QuizID, version int) error {
+	result, err := store.db.Exec("UPDATE quizzes SET attempts = attempts + 1 WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	_, err = store.db.Exec("UPDATE quiz_revisions SET attempts = attempts + 1 WHERE quiz_id = ? AND version = ?", id, version)
+	return err
+}
+
+func (store *SQLiteStore) CreateAccount(user User) error {
+	user, err := HashPassword(user)
+	if err != nil {
+		return err
+	}
+	_, err = store.db.Exec("INSERT INTO users (username, password, role) VALUES (?, ?, ?)", user.Username, user.DbPassword, user.Role)
+	if sqlErr, ok := err.(sqlite3.Error); ok && sqlErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return ErrUserExists
+	}
+	return err
+}
+
+func (store *SQLiteStore) GetUser(username string) (User, error) {
+	result := User{}
+	err := store.db.QueryRow("SELECT username, password, role FROM users WHERE username = ?", username).Scan(
+		&result.Username, &result.DbPassword, &result.Role)
+	if err != nil {
+		return User{}, sqliteError(err)
+	}
+	return result, nil
+}
+
+func (store *SQLiteStore) CheckLogin(user User) (User, error) {
+	dbresult, err := store.GetUser(user.Username)
+	if err == ErrNotFound {
+		return User{}, ErrLoginFailed
+	} else if err != nil {
+		return User{}, err
+	}
+	return CheckPassword(user, dbresult)
+}
+
+func (store *SQLiteStore) DeleteAccount(user User) error {
+	result, err := store.db.Exec("DELETE FROM users WHERE username = ?", user.Username) // Attempts cascade
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+const attemptColumns = "a.uid, u.username, a.quiz_id, a.version, a.title, a.subject, a.started, a.deadline, a.created, a.responses, a.graded, a.score, a.submission, a.sitting, a.policy"
+
+func scanAttempt(row sqlScanner) (Attempt, error) {
+	// Reads the attemptColumns of one row, from attempts a joined to users u
+	attempt := Attempt{}
+	var started, deadline, finished int64
+	var responses, policy string
+	err := row.Scan(&attempt.Id, &attempt.Username, &attempt.Quiz, &attempt.Version, &attempt.Title, &attempt.Subject, &started, &deadline, &finished,
+		&responses, &attempt.Graded, &attempt.Score, &attempt.Submission, &attempt.Sitting, &policy)
+	if err != nil {
+		return attempt, err
+	}
+	attempt.Started = time.Unix(started, 0)
+	attempt.Deadline = unixTime(deadline)
+	attempt.Finished = unixTime(finished)
+	err = json.Unmarshal([]byte(policy), &attempt.Policy)
+	if err != nil {
+		return attempt, err
+	}
+	return attempt, json.Unmarshal([]byte(responses), &attempt.Responses)
+}
+
+func (store *SQLiteStore) InsertAttempt(attempt Attempt) (string, error) {
+	attempt.Id = NewAttemptID()
+	if attempt.Responses == nil {
+		attempt.Responses = Answers{}
+	}
+	responses, err := json.Marshal(attempt.Responses)
+	if err != nil {
+		return "", err
+	}
+	policy, err := policyJSON(attempt.Policy)
+	if err != nil {
+		return "", err
+	}
+	result, err := store.db.Exec(`INSERT INTO attempts (uid, user_id, quiz_id, version, title, subject, started, deadline, created, responses, graded, score, submission, sitting, policy)
+		SELECT ?, id, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ? FROM users WHERE username = ?`,
+		attempt.Id, attempt.Quiz, attempt.Version, attempt.Title, attempt.Subject, attempt.Started.Unix(), unixSeconds(attempt.Deadline), unixSeconds(attempt.Finished), string(responses),
+		attempt.Graded, attempt.Score, attempt.Submission, attempt.Sitting, policy, attempt.Username)
+	if err != nil {
+		return "", err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return "", err
+	} else if n == 0 {
+		return "", ErrNotFound
+	}
+	return attempt.Id, nil
+}
+
+func (store *SQLiteStore) RetrieveAttempt(id string) (Attempt, error) {
+	attempt, err := scanAttempt(store.db.QueryRow("SELECT "+attemptColumns+" FROM attempts a JOIN users u ON u.id = a.user_id WHERE a.uid = ?", id))
+	return attempt, sqliteError(err)
+}
+
+func (store *SQLiteStore) RetrieveAttempts(username string) ([]Attempt, error) {
+	rows, err := store.db.Query("SELECT "+attemptColumns+` FROM attempts a JOIN users u ON u.id = a.user_id
+		WHERE u.username = ? ORDER BY a.created DESC, a.id DESC`, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []Attempt{}
+	for rows.Next() {
+		attempt, err := scanAttempt(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, attempt)
+	}
+	return result, rows.Err()
+}
+
+func (store *SQLiteStore) RetrieveOpenAttempt(username string, quiz QuizID) (Attempt, error) {
+	attempt, err := scanAttempt(store.db.QueryRow("SELECT "+attemptColumns+` FROM attempts a JOIN users u ON u.id = a.user_id
+		WHERE u.username = ? AND a.quiz_id = ? AND a.created = 0 AND a.sitting = '' ORDER BY a.started DESC, a.id DESC LIMIT 1`, username, quiz))
+	return attempt, sqliteError(err)
+}
+
+func (store *SQLiteStore) SaveResponse(id string, question string, response []string) error {
+	// Responses are kept as one JSON object, so they are read and written back in a transaction
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var finished int64
+	var stored string
+	err = tx.QueryRow("SELECT created, responses FROM attempts WHERE uid = ?", id).Scan(&finished, &stored)
+	if err != nil {
+		return sqliteError(err)
+	} else if finished != 0 {
+		return ErrConflict
+	}
+	responses := Answers{}
+	err = json.Unmarshal([]byte(stored), &responses)
+	if err != nil {
+		return err
+	}
+	if len(response) == 0 {
+		delete(responses, question)
+	} else {
+		responses[question] = response
+	}
+	encoded, err := json.Marshal(responses)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE attempts SET responses = ? WHERE uid = ?", string(encoded), id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *SQLiteStore) UpdateAttempt(attempt Attempt) error {
+	if attempt.Responses == nil {
+		attempt.Responses = Answers{}
+	}
+	responses, err := json.Marshal(attempt.Responses)
+	if err != nil {
+		return err
+	}
+	policy, err := policyJSON(attempt.Policy)
+	if err != nil {
+		return err
+	}
+	result, err := store.db.Exec("UPDATE attempts SET responses = ?, created = ?, graded = ?, score = ?, submission = ?, policy = ? WHERE uid = ? AND created = 0",
+		string(responses), unixSeconds(attempt.Finished), attempt.Graded, attempt.Score, attempt.Submission, policy, attempt.Id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n > 0 {
+		return err
+	}
+	var finished int64
+	err = store.db.QueryRow("SELECT created FROM attempts WHERE uid = ?", attempt.Id).Scan(&finished)
+	if err == nil {
+		return ErrConflict
+	}
+	return sqliteError(err)
+}
+
+func (store *SQLiteStore) FinishAttempt(submission string, score float32) error {
+	result, err := store.db.Exec("UPDATE attempts SET graded = 1, score = ? WHERE submission = ? AND submission != ''", score, submission)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+const testColumns = "id, title, sections, published, author, created, version"
+
+func scanTest(row sqlScanner) (Test, error) {
+	test := Test{}
+	var sections string
+	var created int64
+	err := row.Scan(&test.Id, &test.Title, &sections, &test.Published, &test.Author, &created, &test.Version)
+	if err != nil {
+		return test, err
+	}
+	test.Created = time.Unix(created, 0)
+	return test, json.Unmarshal([]byte(sections), &test.Sections)
+}
+
+func (store *SQLiteStore) InsertTest(test Test) (string, error) {
+	test.Id = NewTestID()
+	if test.Sections == nil {
+		test.Sections = []TestSection{}
+	}
+	sections, err := json.Marshal(test.Sections)
+	if err != nil {
+		return "", err
+	}
+	_, err = store.db.Exec("INSERT INTO tests ("+testColumns+") VALUES (?, ?, ?, ?, ?, ?, 1)",
+		test.Id, test.Title, string(sections), test.Published, test.Author, time.Now().Unix())
+	if err != nil {
+		return "", err
+	}
+	return test.Id, nil
+}
+
+func (store *SQLiteStore) RetrieveTest(id string) (Test, error) {
+	test, err := scanTest(store.db.QueryRow("SELECT "+testColumns+" FROM tests WHERE id = ?", id))
+	return test, sqliteError(err)
+}
+
+func (store *SQLiteStore) RetrieveTests() ([]Test, error) {
+	rows, err := store.db.Query("SELECT " + testColumns + " FROM tests ORDER BY title, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []Test{}
+	for rows.Next() {
+		test, err := scanTest(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, test)
+	}
+	return result, rows.Err()
+}
+
+func (store *SQLiteStore) UpdateTest(test Test) error {
+	if test.Sections == nil {
+		test.Sections = []TestSection{}
+	}
+	sections, err := json.Marshal(test.Sections)
+	if err != nil {
+		return err
+	}
+	result, err := store.db.Exec("UPDATE tests SET title = ?, sections = ?, published = ?, version = version + 1 WHERE id = ? AND version = ?",
+		test.Title, string(sections), test.Published, test.Id, test.Version)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n > 0 {
+		return err
+	}
+	var version int
+	err = store.db.QueryRow("SELECT version FROM tests WHERE id = ?", test.Id).Scan(&version)
+	if err == nil {
+		return ErrConflict
+	}
+	return sqliteError(err)
+}
+
+const sittingColumns = "s.uid, u.username, s.test_id, s.title, s.sections, s.attempts, s.current, s.started, s.finished, s.scale"
+
+func scanSitting(row sqlScanner) (Sitting, error) {
+	// Reads the sittingColumns of one row, from sittings s joined to users u
+	sitting := Sitting{}
+	var sections, attempts string
+	var started, finished int64
+	err := row.Scan(&sitting.Id, &sitting.Username, &sitting.Test, &sitting.Title, &sections, &attempts, &sitting.Current, &started, &finished, &sitting.Scale)
+	if err != nil {
+		return sitting, err
+	}
+	sitting.Started = time.Unix(started, 0)
+	sitting.Finished = unixTime(finished)
+	err = json.Unmarshal([]byte(sections), &sitting.Sections)
+	if err != nil {
+		return sitting, err
+	}
+	return sitting, json.Unmarshal([]byte(attempts), &sitting.Attempts)
+}
+
+func (store *SQLiteStore) InsertSitting(sitting Sitting) (string, error) {
+	sitting.Id = NewSittingID()
+	if sitting.Attempts == nil {
+		sitting.Attempts = []string{}
+	}
+	sections, err := json.Marshal(sitting.Sections)
+	if err != nil {
+		return "", err
+	}
+	attempts, err := json.Marshal(sitting.Attempts)
+	if err != nil {
+		return "", err
+	}
+	result, err := store.db.Exec(`INSERT INTO sittings (uid, user_id, test_id, title, sections, attempts, current, started, finished, scale)
+		SELECT ?, id, ?, ?, ?, ?, ?, ?, ?, ? FROM users WHERE username = ?`,
+		sitting.Id, sitting.Test, sitting.Title, string(sections), string(attempts), sitting.Current, sitting.Started.Unix(),
+		unixSeconds(sitting.Finished), sitting.Scale, sitting.Username)
+	if err != nil {
+		return "", err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return "", err
+	} else if n == 0 {
+		return "", ErrNotFound
+	}
+	return sitting.Id, nil
+}
+
+func (store *SQLiteStore) RetrieveSitting(id string) (Sitting, error) {
+	sitting, err := scanSitting(store.db.QueryRow("SELECT "+sittingColumns+" FROM sittings s JOIN users u ON u.id = s.user_id WHERE s.uid = ?", id))
+	return sitting, sqliteError(err)
+}
+
+func (store *SQLiteStore) RetrieveSittings(username string) ([]Sitting, error) {
+	rows, err := store.db.Query("SELECT "+sittingColumns+` FROM sittings s JOIN users u ON u.id = s.user_id
+		WHERE u.username = ? ORDER BY s.started DESC, s.id DESC`, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []Sitting{}
+	for rows.Next() {
+		sitting, err := scanSitting(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, sitting)
+	}
+	return result, rows.Err()
+}
+
+func (store *SQLiteStore) UpdateSitting(sitting Sitting, current int) error {
+	if sitting.Attempts == nil {
+		sitting.Attempts = []string{}
+	}
+	attempts, err := json.Marshal(sitting.Attempts)
+	if err != nil {
+		return err
+	}
+	result, err := store.db.Exec("UPDATE sittings SET attempts = ?, current = ?, finished = ?, scale = ? WHERE uid = ? AND current = ?",
+		string(attempts), sitting.Current, unixSeconds(sitting.Finished), sitting.Scale, sitting.Id, current)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n > 0 {
+		return err
+	}
+	err = store.db.QueryRow("SELECT current FROM sittings WHERE uid = ?", sitting.Id).Scan(&current)
+	if err == nil {
+		return ErrConflict
+	}
+	return sqliteError(err)
+}
+
+func (store *SQLiteStore) BeginSection(sitting Sitting, attempt string) error {
+	// Each section started so far has an attempt, so one not started yet is one with no more attempts than its number
+	attempts, err := json.Marshal(sitting.Begin(attempt).Attempts)
+	if err != nil {
+		return err
+	}
+	result, err := store.db.Exec("UPDATE sittings SET attempts = ? WHERE uid = ? AND current = ? AND json_array_length(attempts) = ?",
+		string(attempts), sitting.Id, sitting.Current, sitting.Current)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n > 0 {
+		return err
+	}
+	var current int
+	err = store.db.QueryRow("SELECT current FROM sittings WHERE uid = ?", sitting.Id).Scan(&current)
+	if err == nil {
+		return ErrConflict
+	}
+	return sqliteError(err)
+}
+
+const scaleColumns = "test_id, version, tables, author, created"
+
+func scanScale(row sqlScanner) (Scale, error) {
+	// Reads the scaleColumns of one row
+	scale := Scale{}
+	var tables string
+	var created int64
+	err := row.Scan(&scale.Test, &scale.Version, &tables, &scale.Author, &created)
+	if err != nil {
+		return scale, err
+	}
+	scale.Created = time.Unix(created, 0)
+	return scale, json.Unmarshal([]byte(tables), &scale.Tables)
+}
+
+func (store *SQLiteStore) InsertScale(scale Scale) error {
+	if scale.Tables == nil {
+		scale.Tables = []Conversion{}
+	}
+	tables, err := json.Marshal(scale.Tables)
+	if err != nil {
+		return err
+	}
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var latest int
+	err = tx.QueryRow("SELECT (SELECT COALESCE(MAX(version), 0) FROM scales WHERE test_id = t.id) FROM tests t WHERE t.id = ?", scale.Test).Scan(&latest)
+	if err != nil {
+		return sqliteError(err)
+	} else if scale.Version != latest+1 {
+		return ErrConflict
+	}
+	_, err = tx.Exec("INSERT INTO scales ("+scaleColumns+") VALUES (?, ?, ?, ?, ?)",
+		scale.Test, scale.Version, string(tables), scale.Author, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *SQLiteStore) RetrieveScale(test string, version int) (Scale, error) {
+	var row *sql.Row
+	if version == 0 {
+		row = store.db.QueryRow("SELECT "+scaleColumns+" FROM scales WHERE test_id = ? ORDER BY version DESC LIMIT 1", test)
+	} else {
+		row = store.db.QueryRow("SELECT "+scaleColumns+" FROM scales WHERE test_id = ? AND version = ?", test, version)
+	}
+	scale, err := scanScale(row)
+	return scale, sqliteError(err)
+}
+
+func (store *SQLiteStore) RetrieveScales(test string) ([]Scale, error) {
+	rows, err := store.db.Query("SELECT "+scaleColumns+" FROM scales WHERE test_id = ? ORDER BY version", test)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []Scale{}
+	for rows.Next() {
+		scale, err := scanScale(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, scale)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,83 @@
+package functions
+
+// Storage abstraction for the SATme server.
+// The handlers only ever talk to a Store, so the backend (MongoDB, or anything else implementing these interfaces) can be swapped out.
+// Every backend must return the errors below for the corresponding cases so the handlers can tell them apart.
+
+import (
+	"errors"
+)
+
+var ErrNotFound = errors.New("not found")               // No quiz or user matched
+var ErrUserExists = errors.New("user already exists")   // CreateAccount with a taken username
+var ErrLoginFailed = errors.New("login failed")         // Unknown user or wrong password
+var ErrUnavailable = errors.New("database unavailable") // The backend couldn't be reached; worth retrying later
+var ErrConflict = errors.New("edit conflict")           // A quiz write named a Version that is no longer current
+
+type QuizStore interface { // Quiz persistence
+	RetrieveQuiz(id QuizID) (Quiz, error)
+	RetrieveQuizzes(query QuizQuery) (QuizPage, error)           // One page of the quizzes matching query
+	InsertQuiz(quiz DbQuiz) (QuizID, error)                      // Returns the ID of the new quiz.  Created and Attempts are set by the store.
+	UpdateQuiz(quiz Quiz) error                                  // Saves everything except Author, Created and Attempts.  ErrConflict if quiz.Version is stale.
+	AddQuestion(id QuizID, version int, question Question) error // Appends in one step, if the quiz is still at version
+	CountAttempt(id QuizID, version int) error                   // Adds one to the Attempts of the quiz and of the revision it was graded against
+	SearchQuizzes(query SearchQuery) ([]SearchResult, error)     // Best matches first
+	RetrieveRevisions(id QuizID) ([]Revision, error)             // Every revision of the quiz, oldest first
+	RetrieveRevision(id QuizID, version int) (Revision, error)   // ErrNotFound if the quiz never had that version
+	DeleteQuiz(id QuizID, version int) error                     // Removes the quiz and its history, if the quiz is still at version.  Revisions attempts were graded against stay.
+}
+
+type PassageStore interface { // Reading passage persistence
+	InsertPassage(passage Passage) (string, error) // Returns the ID of the new passage.  Created is set by the store.
+	RetrievePassage(id string) (Passage, error)
+	RetrievePassages() ([]Passage, error) // Every passage, by title
+	UpdatePassage(passage Passage) error  // Saves the title and text
+}
+
+type SubmissionStore interface { // Quizzes with essays to grade by hand
+	InsertSubmission(submission Submission) (string, error) // Returns the ID of the new submission.  Created is set by the store.
+	RetrieveSubmission(id string) (Submission, error)
+	RetrievePendingSubmissions() ([]Submission, error) // Those not yet Graded, oldest first
+	UpdateSubmission(submission Submission) error      // ErrConflict if the stored one is already Graded, since its score is final, or if submission.Updates is stale
+}
+
+type UserStore interface { // Account persistence
+	CreateAccount(user User) error
+	CheckLogin(user User) (User, error)
+	GetUser(username string) (User, error)
+	DeleteAccount(user User) error
+}
+
+type AttemptStore interface { // Students' graded quizzes
+	InsertAttempt(attempt Attempt) (string, error) // Returns the ID of the new attempt.  ErrNotFound if there is no such user.
+	RetrieveAttempt(id string) (Attempt, error)
+	RetrieveAttempts(username string) ([]Attempt, error)               // The user's attempts, most recently finished first, then any in progress
+	RetrieveOpenAttempt(username string, quiz QuizID) (Attempt, error) // The user's newest attempt at the quiz still in progress, leaving out test sections.  ErrNotFound if there is none.
+	SaveResponse(id string, question string, response []string) error  // Saves one answer of an attempt in progress; an empty response clears it.  ErrConflict if the attempt has already finished.
+	UpdateAttempt(attempt Attempt) error                               // Saves the responses of an attempt in progress, and how it was graded if Finished is set.  ErrConflict if it has already finished.
+	FinishAttempt(submission string, score float32) error              // Records the final score of the attempt waiting on the submission
+}
+
+type TestStore interface { // Full-length tests and students' sittings
+	InsertTest(test Test) (string, error) // Returns the ID of the new test.  Created and Version are set by the store.
+	RetrieveTest(id string) (Test, error)
+	RetrieveTests() ([]Test, error)                // Every test, by title
+	UpdateTest(test Test) error                    // Saves the title, sections and Published.  ErrConflict if test.Version is stale.
+	InsertSitting(sitting Sitting) (string, error) // Returns the ID of the new sitting.  ErrNotFound if there is no such user.
+	RetrieveSitting(id string) (Sitting, error)
+	RetrieveSittings(username string) ([]Sitting, error)   // The user's sittings, newest first
+	UpdateSitting(sitting Sitting, current int) error      // Saves Attempts, Current, Finished and Scale, if the stored sitting is still at section current.  ErrConflict otherwise.
+	BeginSection(sitting Sitting, attempt string) error    // Records attempt as the start of the sitting's current section, if the stored sitting is still at that section and hasn't started it.  ErrConflict otherwise.
+	InsertScale(scale Scale) error                         // Saves the test's tables as scale.Version, which must be one more than the latest (ErrConflict otherwise).  ErrNotFound if there is no such test.  Created is set by the store.
+	RetrieveScale(test string, version int) (Scale, error) // The test's tables at version, or the latest for 0.  ErrNotFound if there are none.
+	RetrieveScales(test string) ([]Scale, error)           // Every version of the test's tables, oldest first
+}
+
+type Store interface { // Everything the server needs from a backend
+	QuizStore
+	PassageStore
+	SubmissionStore
+	UserStore
+	AttemptStore
+	TestStore
+}
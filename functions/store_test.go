@@ -0,0 +1,364 @@
+package functions
+
+// Store conformance: every backend must behave the same, down to which error it returns.  The memory and SQLite
+// stores are always tested.  SATME_TEST_MONGO names a MongoDB host to test too; its "server" database is dropped
+// before each case, so only ever point it at a throwaway server.
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+type backend struct {
+	name string
+	open func(t *testing.T) Store // A new, empty store
+}
+
+func backends(t *testing.T) []backend {
+	result := []backend{
+		{"memory", func(t *testing.T) Store { return NewMemoryStore() }},
+		{"sqlite", func(t *testing.T) Store {
+			store, err := NewSQLiteStore(":memory:")
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(store.Close)
+			return store
+		}},
+	}
+	if addr := os.Getenv("SATME_TEST_MONGO"); addr != "" {
+		result = append(result, backend{"mongo", func(t *testing.T) Store {
+			store, err := NewMongoStore(DefaultMongoConfig(addr))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(store.Close)
+			db := store.copy()
+			defer db.Close()
+			if err = db.DB("server").DropDatabase(); err != nil {
+				t.Fatal(err)
+			}
+			if err = store.Migrate(LatestSchemaVersion(), false, io.Discard); err != nil {
+				t.Fatal(err)
+			}
+			return store
+		}})
+	}
+	return result
+}
+
+var storeCases = []struct {
+	name string
+	run  func(t *testing.T, store Store)
+}{
+	{"missing quiz", func(t *testing.T, store Store) {
+		id := NewQuizID()
+		if _, err := store.RetrieveQuiz(id); err != ErrNotFound {
+			t.Errorf("RetrieveQuiz: %v", err)
+		}
+		if _, err := store.RetrieveRevision(id, 1); err != ErrNotFound {
+			t.Errorf("RetrieveRevision: %v", err)
+		}
+		if err := store.AddQuestion(id, 1, NewQuestion("1+1", []string{"1", "2"}, 1)); err != ErrNotFound && err != ErrConflict {
+			t.Errorf("AddQuestion: %v", err)
+		}
+	}},
+	{"missing revision", func(t *testing.T, store Store) {
+		id := insertQuiz(t, store, "Algebra")
+		if _, err := store.RetrieveRevision(id, 2); err != ErrNotFound {
+			t.Errorf("RetrieveRevision of a version to come: %v", err)
+		}
+	}},
+	{"missing user", func(t *testing.T, store Store) {
+		if _, err := store.GetUser("nobody"); err != ErrNotFound {
+			t.Errorf("GetUser: %v", err)
+		}
+		if _, err := store.CheckLogin(User{Username: "nobody", Password: "pw"}); err != ErrLoginFailed {
+			t.Errorf("CheckLogin: %v", err)
+		}
+		if _, err := store.InsertAttempt(Attempt{Username: "nobody", Quiz: NewQuizID(), Started: time.Now()}); err != ErrNotFound {
+			t.Errorf("InsertAttempt: %v", err)
+		}
+	}},
+	{"missing attempt", func(t *testing.T, store Store) {
+		if _, err := store.RetrieveAttempt(NewAttemptID()); err != ErrNotFound {
+			t.Errorf("RetrieveAttempt: %v", err)
+		}
+		createUser(t, store, "bob", "user")
+		if _, err := store.RetrieveOpenAttempt("bob", NewQuizID()); err != ErrNotFound {
+			t.Errorf("RetrieveOpenAttempt: %v", err)
+		}
+	}},
+	{"user exists", func(t *testing.T, store Store) {
+		createUser(t, store, "bob", "user")
+		if err := store.CreateAccount(User{Username: "bob", Password: "other", Role: "admin"}); err != ErrUserExists {
+			t.Errorf("CreateAccount with a taken username: %v", err)
+		}
+		user, err := store.CheckLogin(User{Username: "bob", Password: "pw"})
+		if err != nil || user.Role != "user" {
+			t.Errorf("CheckLogin after the second CreateAccount: %+v, %v", user, err)
+		}
+		if _, err = store.CheckLogin(User{Username: "bob", Password: "other"}); err != ErrLoginFailed {
+			t.Errorf("CheckLogin with the wrong password: %v", err)
+		}
+	}},
+	{"stale version", func(t *testing.T, store Store) {
+		id := insertQuiz(t, store, "Algebra")
+		quiz := retrieveQuiz(t, store, id)
+		quiz.Title = "Linear equations"
+		if err := store.UpdateQuiz(quiz); err != nil {
+			t.Fatal(err)
+		}
+		quiz.Title = "Stale"
+		if err := store.UpdateQuiz(quiz); err != ErrConflict {
+			t.Errorf("UpdateQuiz at a stale version: %v", err)
+		}
+		if err := store.AddQuestion(id, 1, NewQuestion("1+1", []string{"1", "2"}, 1)); err != ErrConflict {
+			t.Errorf("AddQuestion at a stale version: %v", err)
+		}
+		if err := store.DeleteQuiz(id, 1); err != ErrConflict {
+			t.Errorf("DeleteQuiz at a stale version: %v", err)
+		}
+		quiz = retrieveQuiz(t, store, id)
+		if quiz.Title != "Linear equations" || quiz.Version != 2 || len(quiz.Questions) != 0 {
+			t.Errorf("after the stale writes: %+v", quiz)
+		}
+		if err := store.DeleteQuiz(id, 2); err != nil {
+			t.Errorf("DeleteQuiz at the current version: %v", err)
+		}
+		if _, err := store.RetrieveQuiz(id); err != ErrNotFound {
+			t.Errorf("RetrieveQuiz after DeleteQuiz: %v", err)
+		}
+	}},
+	{"revisions", func(t *testing.T, store Store) {
+		id := insertQuiz(t, store, "Algebra")
+		if err := store.AddQuestion(id, 1, NewQuestion("1+1", []string{"1", "2"}, 1)); err != nil {
+			t.Fatal(err)
+		}
+		quiz := retrieveQuiz(t, store, id)
+		quiz.Title = "Arithmetic"
+		quiz.Questions[0].Answers = []string{"2", "3"}
+		quiz.Questions[0].CorrectIndex = 0
+		if err := store.UpdateQuiz(quiz); err != nil {
+			t.Fatal(err)
+		}
+		revisions, err := store.RetrieveRevisions(id)
+		if err != nil || len(revisions) != 3 {
+			t.Fatalf("RetrieveRevisions: %d, %v", len(revisions), err)
+		}
+		for i, revision := range revisions {
+			if revision.Version != i+1 {
+				t.Errorf("revision %d is version %d", i, revision.Version)
+			}
+		}
+		first, err := store.RetrieveRevision(id, 1)
+		if err != nil || first.Title != "Algebra" || len(first.Questions) != 0 {
+			t.Errorf("version 1: %+v, %v", first, err)
+		}
+		second, err := store.RetrieveRevision(id, 2)
+		if err != nil || len(second.Questions) != 1 || second.Questions[0].Answers[1] != "2" || second.Questions[0].CorrectIndex != 1 {
+			t.Errorf("version 2: %+v, %v", second, err)
+		}
+		third := retrieveQuiz(t, store, id)
+		if third.Version != 3 || third.Questions[0].Id != second.Questions[0].Id || third.Questions[0].CorrectIndex != 0 {
+			t.Errorf("version 3: %+v", third)
+		}
+
+		// Counting an attempt goes to the revision it was graded against as well as the quiz
+		if err = store.CountAttempt(id, 2); err != nil {
+			t.Fatal(err)
+		}
+		second, _ = store.RetrieveRevision(id, 2)
+		if second.Attempts != 1 || retrieveQuiz(t, store, id).Attempts != 1 {
+			t.Errorf("attempts after CountAttempt: revision %d", second.Attempts)
+		}
+	}},
+	{"deleted quiz", func(t *testing.T, store Store) {
+		// The revision an attempt was taken against outlives the quiz; the others go with it
+		createUser(t, store, "bob", "user")
+		id := insertQuiz(t, store, "Algebra")
+		if err := store.AddQuestion(id, 1, NewQuestion("1+1", []string{"1", "2"}, 1)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.InsertAttempt(StartAttempt(retrieveQuiz(t, store, id), "bob", time.Now())); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.DeleteQuiz(id, 2); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.RetrieveQuiz(id); err != ErrNotFound {
+			t.Errorf("RetrieveQuiz after DeleteQuiz: %v", err)
+		}
+		if revision, err := store.RetrieveRevision(id, 2); err != nil || len(revision.Questions) != 1 {
+			t.Errorf("the revision attempted: %+v, %v", revision, err)
+		}
+		if _, err := store.RetrieveRevision(id, 1); err != ErrNotFound {
+			t.Errorf("a revision never attempted: %v", err)
+		}
+	}},
+	{"sitting sections", func(t *testing.T, store Store) {
+		// Of two requests starting the same section, only the first records its attempt
+		createUser(t, store, "bob", "user")
+		test := Test{Id: NewTestID(), Title: "Practice", Sections: []TestSection{{Name: "One", Quiz: NewQuizID()}, {Name: "Two", Quiz: NewQuizID()}}}
+		sitting := StartSitting(test, "bob", time.Now())
+		if err := store.BeginSection(sitting, "first"); err != ErrNotFound {
+			t.Errorf("BeginSection of a missing sitting: %v", err)
+		}
+		var err error
+		sitting.Id, err = store.InsertSitting(sitting)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = store.BeginSection(sitting, "first"); err != nil {
+			t.Fatal(err)
+		}
+		if err = store.BeginSection(sitting, "second"); err != ErrConflict {
+			t.Errorf("BeginSection of a section already started: %v", err)
+		}
+		stored, err := store.RetrieveSitting(sitting.Id)
+		if err != nil || len(stored.Attempts) != 1 || stored.CurrentAttempt() != "first" {
+			t.Fatalf("after both: %+v, %v", stored, err)
+		}
+		if err = store.UpdateSitting(stored.Advance(time.Now()), 0); err != nil {
+			t.Fatal(err)
+		}
+		if err = store.BeginSection(stored, "late"); err != ErrConflict {
+			t.Errorf("BeginSection of a section handed in: %v", err)
+		}
+		stored, _ = store.RetrieveSitting(sitting.Id)
+		if err = store.BeginSection(stored, "next"); err != nil {
+			t.Errorf("BeginSection of the next section: %v", err)
+		}
+		stored, _ = store.RetrieveSitting(sitting.Id)
+		if len(stored.Attempts) != 2 || stored.CurrentAttempt() != "next" || stored.Attempts[0] != "first" {
+			t.Errorf("attempts: %v", stored.Attempts)
+		}
+	}},
+	{"concurrent grading", func(t *testing.T, store Store) {
+		// Two graders load the submission, score different essays and save; the second save is refused
+		rubric := []Criterion{{Name: "Thesis", Points: 4}}
+		id, err := store.InsertSubmission(Submission{Quiz: NewQuizID(), Version: 1, Title: "Essays", Essays: []EssayResponse{
+			{Number: 1, Rubric: rubric}, {Number: 2, Rubric: rubric},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		first, _ := store.RetrieveSubmission(id)
+		second, _ := store.RetrieveSubmission(id)
+		if err = first.ScoreEssay(0, []int{4}, "carol"); err != nil {
+			t.Fatal(err)
+		}
+		if err = store.UpdateSubmission(first); err != nil {
+			t.Fatal(err)
+		}
+		if err = second.ScoreEssay(1, []int{2}, "dave"); err != nil {
+			t.Fatal(err)
+		}
+		if err = store.UpdateSubmission(second); err != ErrConflict {
+			t.Errorf("UpdateSubmission of a stale copy: %v", err)
+		}
+		stored, err := store.RetrieveSubmission(id)
+		if err != nil || !stored.Essays[0].Scored || stored.Essays[1].Scored || stored.Updates != 1 {
+			t.Fatalf("after both saves: %+v, %v", stored, err)
+		}
+		if err = stored.ScoreEssay(1, []int{2}, "dave"); err != nil {
+			t.Fatal(err)
+		}
+		if err = store.UpdateSubmission(stored); err != nil {
+			t.Errorf("UpdateSubmission of the current copy: %v", err)
+		}
+		stored, _ = store.RetrieveSubmission(id)
+		if !stored.Graded || stored.Essays[0].Grader != "carol" || stored.Essays[1].Grader != "dave" {
+			t.Errorf("finished submission: %+v", stored)
+		}
+	}},
+	{"grid-in search", func(t *testing.T, store Store) {
+		// A student searching for a grid-in's accepted answer doesn't find the quiz, or the answer in a snippet
+		id := insertQuiz(t, store, "Squares")
+		question := NewQuestion("What is 42 squared?", []string{"1764"}, 0)
+		question.Type = GridInQuestion
+		if err := store.AddQuestion(id, 1, question); err != nil {
+			t.Fatal(err)
+		}
+		quiz := retrieveQuiz(t, store, id)
+		quiz.Published = true
+		if err := store.UpdateQuiz(quiz); err != nil {
+			t.Fatal(err)
+		}
+		results, err := store.SearchQuizzes(SearchQuery{Text: "1764", PublishedOnly: true})
+		if err != nil || len(results) != 0 {
+			t.Errorf("search for the answer: %+v, %v", results, err)
+		}
+		results, err = store.SearchQuizzes(SearchQuery{Text: "squared 1764", PublishedOnly: true})
+		if err != nil || len(results) != 1 {
+			t.Fatalf("search for the question: %+v, %v", results, err)
+		}
+		for _, match := range results[0].Matches {
+			if match.Field != "question" {
+				t.Errorf("match in the %s: %s", match.Field, match.Snippet)
+			}
+		}
+	}},
+	{"finished attempt", func(t *testing.T, store Store) {
+		createUser(t, store, "bob", "user")
+		quiz := retrieveQuiz(t, store, insertQuiz(t, store, "Algebra"))
+		attempt := StartAttempt(quiz, "bob", time.Now())
+		var err error
+		attempt.Id, err = store.InsertAttempt(attempt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if open, err := store.RetrieveOpenAttempt("bob", quiz.Id); err != nil || open.Id != attempt.Id {
+			t.Errorf("RetrieveOpenAttempt: %+v, %v", open, err)
+		}
+		finished := attempt.Finish(Answers{}, quiz.Submit(Answers{}), time.Now())
+		if err = store.UpdateAttempt(finished); err != nil {
+			t.Fatal(err)
+		}
+		if err = store.UpdateAttempt(finished); err != ErrConflict {
+			t.Errorf("UpdateAttempt of a finished attempt: %v", err)
+		}
+		if err = store.SaveResponse(attempt.Id, "q", []string{"1"}); err != ErrConflict {
+			t.Errorf("SaveResponse to a finished attempt: %v", err)
+		}
+		if _, err = store.RetrieveOpenAttempt("bob", quiz.Id); err != ErrNotFound {
+			t.Errorf("RetrieveOpenAttempt after finishing: %v", err)
+		}
+	}},
+}
+
+func TestConformance(t *testing.T) {
+	for _, backend := range backends(t) {
+		t.Run(backend.name, func(t *testing.T) {
+			for _, c := range storeCases {
+				t.Run(c.name, func(t *testing.T) {
+					c.run(t, backend.open(t))
+				})
+			}
+		})
+	}
+}
+
+func insertQuiz(t *testing.T, store Store, title string) QuizID {
+	id, err := store.InsertQuiz(NewQuiz(title))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func retrieveQuiz(t *testing.T, store Store, id QuizID) Quiz {
+	quiz, err := store.RetrieveQuiz(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return quiz
+}
+
+func createUser(t *testing.T, store Store, username string, role string) {
+	if err := store.CreateAccount(User{Username: username, Password: "pw", Role: role}); err != nil {
+		t.Fatal(err)
+	}
+}
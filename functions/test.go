@@ -0,0 +1,209 @@
+package functions
+
+// Full-length practice tests.  A Test is a run of sections, each one a quiz, taken in order like the real SAT.
+// A student's Sitting copies the sections when it starts, so changing the test later doesn't change a sitting under
+// way.  Each section is an Attempt at its quiz, tied to the sitting and timed by the section.  Sections open one at a
+// time, in order, and once a section is handed in it can't be gone back to.
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const MaxSections = 10 // Sections a test may have
+
+type Test struct {
+	Id        string        `schema:"-" bson:"_id"`
+	Title     string        `schema:"title" bson:"title"`
+	Sections  []TestSection `schema:"-" bson:"sections"`
+	Published bool          `schema:"published" bson:"published"` // Only published tests are listed for students
+	Author    string        `schema:"-" bson:"author"`            // Username of the admin who created it
+	Created   time.Time     `schema:"-" bson:"created"`
+	Version   int           `schema:"version" bson:"version"` // Like Quiz.Version
+}
+
+type TestSection struct {
+	Name      string `bson:"name"` // Such as "Math (no calculator)"
+	Quiz      QuizID `bson:"quiz"`
+	TimeLimit int    `bson:"time_limit"` // Minutes for the section, up to MaxTimeLimit; 0 keeps the quiz's own limit, if it has one
+}
+
+func NewTestID() string {
+	return NewQuestionID()
+}
+
+func (test Test) Includes(quiz QuizID) bool {
+	// Whether the quiz is one of the test's sections
+	for _, section := range test.Sections {
+		if section.Quiz == quiz {
+			return true
+		}
+	}
+	return false
+}
+
+type TestForm struct { // The form on /test/{id}: a section per row of Names, Quizzes and Minutes, in order
+	Title     string   `schema:"title"`
+	Published bool     `schema:"published"`
+	Names     []string `schema:"names"`
+	Quizzes   []string `schema:"quizzes"` // Rows with no quiz are left out
+	Minutes   []string `schema:"minutes"` // Blank for 0
+	Version   int      `schema:"version"`
+}
+
+func (form TestForm) Apply(test *Test) error {
+	// Puts the form's title, sections and Published into test, or says what is wrong with them
+	sections := []TestSection{}
+	for i := 0; i < len(form.Quizzes); i++ {
+		if strings.TrimSpace(form.Quizzes[i]) == "" {
+			continue
+		}
+		section := TestSection{}
+		var err error
+		section.Quiz, err = ParseQuizID(strings.TrimSpace(form.Quizzes[i]))
+		if err != nil {
+			return fmt.Errorf("section %d: choose a quiz", len(sections)+1)
+		}
+		if i < len(form.Names) {
+			section.Name = strings.TrimSpace(form.Names[i])
+		}
+		if i < len(form.Minutes) && strings.TrimSpace(form.Minutes[i]) != "" {
+			section.TimeLimit, err = strconv.Atoi(strings.TrimSpace(form.Minutes[i]))
+			if err != nil || section.TimeLimit < 0 || section.TimeLimit > MaxTimeLimit {
+				return fmt.Errorf("section %d: the time limit must be from 0 to %d minutes", len(sections)+1, MaxTimeLimit)
+			}
+		}
+		sections = append(sections, section)
+	}
+	test.Title = strings.TrimSpace(form.Title)
+	test.Sections = sections
+	test.Published = form.Published
+	return test.Validate()
+}
+
+func (test Test) Validate() error {
+	if test.Title == "" {
+		return errors.New("the test needs a title")
+	} else if len(test.Sections) > MaxSections {
+		return fmt.Errorf("a test can have at most %d sections", MaxSections)
+	} else if test.Published && len(test.Sections) == 0 {
+		return errors.New("add a section before publishing the test")
+	}
+	for i := 0; i < len(test.Sections); i++ {
+		if test.Sections[i].Name == "" {
+			return fmt.Errorf("section %d needs a name", i+1)
+		}
+	}
+	return nil
+}
+
+func (section TestSection) Timing(quiz Quiz) Quiz {
+	// The quiz as the section gives it, with the section's time limit
+	if section.TimeLimit > 0 {
+		quiz.TimeLimit = section.TimeLimit
+	}
+	return quiz
+}
+
+type Sitting struct { // One student taking one test
+	Id       string        `bson:"_id"`
+	Username string        `bson:"username"`
+	Test     string        `bson:"test"`
+	Title    string        `bson:"title"`    // The test's title then
+	Sections []TestSection `bson:"sections"` // And its sections
+	Attempts []string      `bson:"attempts"` // Id of the attempt at each section started so far
+	Current  int           `bson:"current"`  // The section in progress or next to start; len(Sections) once all are done
+	Started  time.Time     `bson:"started"`
+	Finished time.Time     `bson:"finished"` // Zero until the last section is handed in
+	Scale    int           `bson:"scale"`    // Version of the test's conversion tables it is scored with; 0 while the test has none
+}
+
+func NewSittingID() string {
+	return NewQuestionID()
+}
+
+func StartSitting(test Test, username string, now time.Time) Sitting {
+	return Sitting{
+		Username: username,
+		Test:     test.Id,
+		Title:    test.Title,
+		Sections: append([]TestSection{}, test.Sections...),
+		Attempts: []string{},
+		Started:  now,
+	}
+}
+
+func (sitting Sitting) Done() bool {
+	return sitting.Current >= len(sitting.Sections)
+}
+
+func (sitting Sitting) CurrentAttempt() string {
+	// The attempt at the current section, or "" if it hasn't been started
+	if sitting.Current < len(sitting.Attempts) {
+		return sitting.Attempts[sitting.Current]
+	}
+	return ""
+}
+
+func (sitting Sitting) Begin(attempt string) Sitting {
+	// The sitting with its current section started as attempt
+	sitting.Attempts = append(append([]string{}, sitting.Attempts[:sitting.Current]...), attempt)
+	return sitting
+}
+
+func (sitting Sitting) Advance(now time.Time) Sitting {
+	// The sitting after its current section is handed in
+	sitting.Current++
+	if sitting.Done() {
+		sitting.Finished = now
+	}
+	return sitting
+}
+
+type SectionResult struct { // For the combined result page
+	Number    int // Counting from 1
+	Section   TestSection
+	Attempt   Attempt // Zero if the section was never started
+	Questions int     // In the revision the section was taken against
+	Points    float32 // What the section is out of under the policy it was scored with (see Quiz.Possible)
+}
+
+func (section SectionResult) Earned() float32 {
+	// Points the section's score is worth
+	return section.Attempt.Score / 100 * section.Points
+}
+
+type TestResult struct {
+	Sections  []SectionResult
+	Questions int
+	Points    float32
+	Graded    bool    // False while any section waits on essays
+	Score     float32 // Percentage over every point in the test; final once Graded
+	Scaled    ScaledResult
+}
+
+func NewTestResult(sections []SectionResult) TestResult {
+	// Sections count by their points, so a long or heavily weighted section counts for more than a short one
+	result := TestResult{Sections: sections, Graded: true}
+	var earned float32
+	for _, section := range sections {
+		result.Questions += section.Questions
+		result.Points += section.Points
+		earned += section.Earned()
+		if section.Attempt.Id != "" && !section.Attempt.Graded {
+			result.Graded = false
+		}
+	}
+	if result.Points > 0 {
+		result.Score = earned / result.Points * 100
+	}
+	return result
+}
+
+func (result TestResult) Weighted() bool {
+	// Whether questions are worth different points, so the score isn't out of Questions
+	return result.Points != float32(result.Questions)
+}
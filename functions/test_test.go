@@ -0,0 +1,27 @@
+package functions
+
+import (
+	"testing"
+)
+
+func TestWeightedSections(t *testing.T) {
+	// A section of two questions worth 5 points each counts for more than one of four questions worth 1
+	weighted := Quiz{Policy: ScoringPolicy{Weighted: true}, Questions: []Question{{Weight: 5}, {Weight: 5}}}
+	plain := Quiz{Questions: []Question{{Weight: 5}, {}, {}, {}}}
+	if weighted.Possible() != 10 || plain.Possible() != 4 {
+		t.Fatalf("Possible: %g and %g", weighted.Possible(), plain.Possible())
+	}
+	result := NewTestResult([]SectionResult{
+		{Number: 1, Questions: 2, Points: weighted.Possible(), Attempt: Attempt{Id: "a", Graded: true, Score: 50}},
+		{Number: 2, Questions: 4, Points: plain.Possible(), Attempt: Attempt{Id: "b", Graded: true, Score: 100}},
+	})
+	if result.Points != 14 || result.Questions != 6 || !result.Weighted() {
+		t.Errorf("totals: %g points over %d questions", result.Points, result.Questions)
+	}
+	if result.Score < 64.28 || result.Score > 64.29 {
+		t.Errorf("score: %g, not 9 of 14 points", result.Score)
+	}
+	if raw := result.Sections[0].Raw(); raw != 5 {
+		t.Errorf("raw score of the weighted section: %d", raw)
+	}
+}
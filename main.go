@@ -3,7 +3,7 @@ package main
 /* This is the Golang version of the SATme backend.
 	It is currently designed for a synchronous front-end.  However, gorilla/websocket does facilitate websocket usage.
 	It uses gorilla/sessions for sessions, gorilla/schema for forms, gorilla/mux for routing, and html/template for templates.
-	It uses a MongoDB backend via mgo.v2.
+	It uses a MongoDB backend via mgo.v2 by default, behind the functions.Store interface so that other backends (SQLite, in-memory) can be swapped in.
 	This is built in Golang for the combination of rapid development, ease & simplicity of use (vs Yesod), and high concurrent performance
 with Goroutines (green threads).  This may or may not be the production version.
 	The general functions and structs are located in the functions package.  Note that it must be moved to its own directory under $GOPATH/src before compiling.
@@ -23,43 +23,101 @@ import (
 	"net/http"                    // Basic HTTP library
 	// "gopkg.in/mgo.v2"		// MongoDB driver
 	// "gopkg.in/mgo.v2/bson"		// Used to convert to BSON for Mongo
-	"fmt" // fmt is more or less equivalent to stdio in other languages
+	"flag" // Command-line options
+	"fmt"  // fmt is more or less equivalent to stdio in other languages
 	// "golang.org/x/crypto/bcrypt"	// Secure password hashing, more secure for passwords than SHA3
-	"time"
-	// "errors"
+	"encoding/json"
+	"errors"
 	"functions"
+	"io"
+	"time"
 	// "encoding/hex"
 	"os"
+	"strconv"
+	"strings"
 )
 
 /* START VARIABLE DECLARATIONS */
 
 var decoder = schema.NewDecoder()                                                           // Decoder struct for form results
+var query_decoder = schema.NewDecoder()                                                     // Decoder for GET query strings, which may carry unrelated parameters
+var rows_decoder = schema.NewDecoder()                                                      // Decoder for forms with a row per entry of several lists, such as answer choices
 var store = sessions.NewCookieStore([]byte("non-production-a"), []byte("non-production-e")) // Session store with encryption and authentication keys
 var dbstr = "localhost:27017"                                                               // MongoDB host
 
+const maxImportSize = 10 << 20 // Bytes read from an uploaded quiz export
+
+type server struct { // Holds the dependencies shared by the routing functions
+	db functions.Store // Quiz, user and score storage
+}
+
+func init() {
+	query_decoder.IgnoreUnknownKeys(true)
+	rows_decoder.ZeroEmpty(true) // Keep empty entries, so they're reported rather than shifting the rows after them
+}
+
 /* END VARIABLE DECLARATIONS */
 
 /* START MAIN FUNCTION */
 
 func main() {
 	var PORT int = 8080 // So it's not hard-coded
-	r := mux.NewRouter()
-	r.HandleFunc("/", index)
-	r.HandleFunc("/static/{file}", serve_static)
-	r.HandleFunc("/login_post", post_login)
-	r.HandleFunc("/login_get", get_login)
-	r.HandleFunc("/create_acct_get", create_account_get)
-	r.HandleFunc("/create_acct", create_account_post)
-	r.HandleFunc("/quizzes", get_all_quizzes)
-	r.HandleFunc("/quiz/{id}", display_quiz)
-	r.HandleFunc("/grade/{id}", grade_quiz)
-	r.HandleFunc("/score", view_score)
-	r.HandleFunc("/admin", admin_panel)
-	r.HandleFunc("/create_quiz", create_quiz)
-	r.HandleFunc("/addq/{id}", addq_menu)
-	r.HandleFunc("/add_question/{id}", add_question)
-	http.Handle("/", r)
+	backend := flag.String("store", "mongo", "storage backend: mongo, sqlite, or memory for development without a database")
+	sqlitePath := flag.String("sqlite", "./satme.db", "SQLite database file, for -store=sqlite")
+	mongo := functions.DefaultMongoConfig(dbstr)
+	flag.StringVar(&mongo.Addr, "mongo", mongo.Addr, "MongoDB host")
+	flag.IntVar(&mongo.PoolLimit, "mongo-pool", mongo.PoolLimit, "maximum open MongoDB connections")
+	flag.DurationVar(&mongo.Timeout, "mongo-timeout", mongo.Timeout, "how long to wait for MongoDB to become reachable")
+	flag.DurationVar(&mongo.SocketTimeout, "mongo-socket-timeout", mongo.SocketTimeout, "how long a single MongoDB operation may take")
+	migrate := flag.String("migrate", "", "run MongoDB schema migrations and exit: status, up or down")
+	migrateTo := flag.Int("migrate-to", -1, "schema version for -migrate (default: latest for up)")
+	dryRun := flag.Bool("dry-run", false, "with -migrate, only print what would change")
+	flag.Parse()
+	if *migrate != "" && *backend != "mongo" {
+		log.Fatal("-migrate only applies to -store=mongo; SQLite databases are migrated when opened")
+	}
+	s := new(server)
+	switch *backend {
+	case "mongo":
+		db, err := functions.NewMongoStore(mongo)
+		if err != nil {
+			log.Fatal("Connecting to MongoDB at ", mongo.Addr, ": ", err)
+		}
+		defer db.Close()
+		if *migrate != "" {
+			err = run_migrations(db, *migrate, *migrateTo, *dryRun)
+			if err != nil {
+				log.Fatal("Migration failed: ", err)
+			}
+			return
+		}
+		version, err := db.SchemaVersion()
+		if err != nil {
+			log.Fatal("Reading schema version: ", err)
+		} else if version != functions.LatestSchemaVersion() {
+			log.Printf("Warning: MongoDB schema is at version %d, but this server expects %d.  Run with -migrate up.", version, functions.LatestSchemaVersion())
+		}
+		s.db = db
+	case "sqlite":
+		db, err := functions.NewSQLiteStore(*sqlitePath)
+		if err != nil {
+			log.Fatal("Opening SQLite database ", *sqlitePath, ": ", err)
+		}
+		defer db.Close()
+		s.db = db
+	case "memory":
+		s.db = functions.NewMemoryStore()
+		log.Println("Using in-memory store: nothing will be saved")
+		// There's no database to promote an account in, so development gets a ready-made admin
+		err := s.db.CreateAccount(functions.User{Username: "admin", Password: "admin", Role: "admin"})
+		if err != nil {
+			log.Fatal("Creating development admin: ", err)
+		}
+		log.Println("Development admin account: admin / admin")
+	default:
+		log.Fatal("Unknown store: ", *backend)
+	}
+	http.Handle("/", s.router())
 	logstr := fmt.Sprintf("Listening on port %d", PORT)
 	log.Println(logstr)
 	portstr := fmt.Sprintf(":%d", PORT)
@@ -69,8 +127,70 @@ func main() {
 	}
 }
 
+func (s *server) router() *mux.Router {
+	// Routes are set up separately from main so that a server with any Store can be exercised
+	r := mux.NewRouter()
+	r.HandleFunc("/", s.index)
+	r.HandleFunc("/static/{file}", s.serve_static)
+	r.HandleFunc("/login_post", s.post_login)
+	r.HandleFunc("/login_get", s.get_login)
+	r.HandleFunc("/create_acct_get", s.create_account_get)
+	r.HandleFunc("/create_acct", s.create_account_post)
+	r.HandleFunc("/quizzes", s.get_all_quizzes)
+	r.HandleFunc("/quiz/{id}", s.display_quiz)
+	r.HandleFunc("/grade/{id}", s.grade_quiz)
+	r.HandleFunc("/save_answer/{id}/{question}", s.save_answer)
+	r.HandleFunc("/score", s.view_score)
+	r.HandleFunc("/attempts", s.list_attempts)
+	r.HandleFunc("/attempt/{id}", s.view_attempt)
+	r.HandleFunc("/admin", s.admin_panel)
+	r.HandleFunc("/create_quiz", s.create_quiz)
+	r.HandleFunc("/addq/{id}", s.addq_menu)
+	r.HandleFunc("/add_question/{id}", s.add_question)
+	r.HandleFunc("/publish/{id}", s.publish_quiz)
+	r.HandleFunc("/search", s.search_quizzes)
+	r.HandleFunc("/revisions/{id}", s.quiz_revisions)
+	r.HandleFunc("/rollback/{id}", s.rollback_quiz)
+	r.HandleFunc("/edit_question/{id}/{question}", s.edit_question)
+	r.HandleFunc("/delete_question/{id}/{question}", s.delete_question)
+	r.HandleFunc("/move_question/{id}/{question}", s.move_question)
+	r.HandleFunc("/rename_quiz/{id}", s.rename_quiz)
+	r.HandleFunc("/delete_quiz/{id}", s.delete_quiz)
+	r.HandleFunc("/passages", s.list_passages)
+	r.HandleFunc("/passage/{id}", s.edit_passage)
+	r.HandleFunc("/export/{id}", s.export_quiz)
+	r.HandleFunc("/import", s.import_quiz)
+	r.HandleFunc("/grading", s.grading_queue)
+	r.HandleFunc("/review/{id}", s.review_quiz)
+	r.HandleFunc("/review_settings/{id}", s.review_settings)
+	r.HandleFunc("/time_limit/{id}", s.time_limit)
+	r.HandleFunc("/scoring/{id}", s.scoring_policy)
+	r.HandleFunc("/grading/{id}", s.grade_submission)
+	r.HandleFunc("/tests", s.list_tests)
+	r.HandleFunc("/test/{id}", s.view_test)
+	r.HandleFunc("/start_test/{id}", s.start_test)
+	r.HandleFunc("/sitting/{id}", s.view_sitting)
+	r.HandleFunc("/sitting/{id}/section", s.take_section)
+	r.HandleFunc("/scale/{id}", s.edit_scale)
+	return r
+}
+
 /* END MAIN FUNCTION */
 
+/* STATUS FUNCTION */
+
+func db_status(err error) int {
+	// HTTP status for a failed Store call: 404 for a missing quiz or user, 503 if the database is down so the client knows to try again
+	if err == functions.ErrNotFound {
+		return 404
+	} else if err == functions.ErrUnavailable {
+		return 503
+	} else if err == functions.ErrConflict {
+		return 409
+	}
+	return 500
+}
+
 /* LOGGING FUNCTION */
 
 func flog(content string) {
@@ -92,12 +212,12 @@ func flog(content string) {
 
 /* START ROUTING FUNCTIONS */
 
-func serve_static(w http.ResponseWriter, r *http.Request) {
+func (s *server) serve_static(w http.ResponseWriter, r *http.Request) {
 	// Static file server
 	http.ServeFile(w, r, "static/"+mux.Vars(r)["file"])
 }
 
-func index(w http.ResponseWriter, r *http.Request) {
+func (s *server) index(w http.ResponseWriter, r *http.Request) {
 	// Index function
 	t, err := template.ParseFiles("templates/index.html")
 	if err != nil {
@@ -114,7 +234,7 @@ func index(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func create_quiz(w http.ResponseWriter, r *http.Request) {
+func (s *server) create_quiz(w http.ResponseWriter, r *http.Request) {
 	session, err := store.Get(r, "login")
 	if err != nil {
 		http.Error(w, "failed to retrieve session", 500)
@@ -137,9 +257,11 @@ func create_quiz(w http.ResponseWriter, r *http.Request) {
 					flog("create_quiz: failed to read form")
 				} else {
 					quiz.Questions = []functions.Question{}
-					err = functions.InsertQuiz(functions.DbQuiz{quiz.Title, quiz.Questions})
+					dbquiz := quiz.GetDbQuiz()
+					dbquiz.Author, _ = session.Values["username"].(string)
+					_, err = s.db.InsertQuiz(dbquiz)
 					if err != nil {
-						http.Error(w, "failed to insert quiz", 500)
+						http.Error(w, "failed to insert quiz", db_status(err))
 						flog("create_quiz: failed to insert quiz")
 					} else {
 						fmt.Fprintf(w, "Successfully created quiz")
@@ -150,7 +272,79 @@ func create_quiz(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func addq_menu(w http.ResponseWriter, r *http.Request) {
+type addq_page struct { // Data for addq.html
+	Quiz     functions.Quiz
+	Pending  functions.PostQuestion // Question that couldn't be added, filled back into the form
+	Conflict bool                   // The quiz changed while the admin was writing Pending
+	Error    string                 // Why Pending couldn't be added, if it was invalid
+	Passages []functions.Passage    // To choose from for each question
+}
+
+type passage_option struct { // One entry in a question's passage menu
+	Id       string
+	Title    string
+	Selected bool
+}
+
+func (page addq_page) DraftRubric() []functions.Criterion {
+	// Rubric rows for the new essay form: the pending rubric, or one row worth a point to start with
+	if rubric := page.Pending.GetRubric(); len(rubric) > 0 && page.Drafting(functions.EssayQuestion) {
+		return rubric
+	}
+	return []functions.Criterion{{Points: 1}}
+}
+
+func (page addq_page) PassageOptions(selected string) []passage_option {
+	result := []passage_option{}
+	for _, passage := range page.Passages {
+		result = append(result, passage_option{passage.Id, passage.Title, passage.Id == selected})
+	}
+	return result
+}
+
+func (page addq_page) PendingPassage(kind string) string {
+	// The passage chosen for Pending, for the form of that kind
+	if page.Drafting(kind) {
+		return page.Pending.Passage
+	}
+	return ""
+}
+
+func (page addq_page) PendingExplained(kind string) functions.PostQuestion {
+	// Pending's explanation and solution, for the form of that kind
+	if page.Drafting(kind) {
+		return page.Pending
+	}
+	return functions.PostQuestion{}
+}
+
+func (page addq_page) ReviewDate() string {
+	// For the datetime-local input
+	if page.Quiz.ReviewDate.IsZero() {
+		return ""
+	}
+	return page.Quiz.ReviewDate.Local().Format("2006-01-02T15:04")
+}
+
+func (page addq_page) MinAnswers() int   { return functions.MinAnswers }
+func (page addq_page) MaxAnswers() int   { return functions.MaxAnswers }
+func (page addq_page) MaxCriteria() int  { return functions.MaxCriteria }
+func (page addq_page) MaxTimeLimit() int { return functions.MaxTimeLimit }
+
+func (page addq_page) Drafting(kind string) bool {
+	// Whether Pending is a question of this kind, so its text goes back into that form and not the others
+	return page.Pending.GetQuestion().Kind() == kind
+}
+
+func (page addq_page) Draft(kind string, blank int) []string {
+	// Answer inputs for the new question form of this kind: the pending answers, or blank empty ones to start with
+	if len(page.Pending.Answers) > 0 && page.Drafting(kind) {
+		return page.Pending.Answers
+	}
+	return make([]string, blank)
+}
+
+func (s *server) addq_menu(w http.ResponseWriter, r *http.Request) {
 	// Menu to add questions to a specific quiz.  It's a workaround for some bugs--not ideal, but hopefully it works.
 	session, err := store.Get(r, "login")
 	if err != nil {
@@ -162,17 +356,21 @@ func addq_menu(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "failed to verify admin privileges.  are you logged in?", 500)
 		} else {
 			t, _ := template.ParseFiles("templates/addq.html")
-			id, ok := mux.Vars(r)["id"]
-			if !ok {
-				http.Error(w, "failed to retrieve GET parameter", 500)
+			id, err := functions.ParseQuizID(mux.Vars(r)["id"])
+			if err != nil {
+				http.Error(w, "quiz not found", 404)
 			} else {
-				quiz, err := functions.RetrieveQuiz(id)
+				quiz, err := s.db.RetrieveQuiz(id)
 				if err != nil {
-					http.Error(w, "failed to read quiz", 500)
+					http.Error(w, "failed to read quiz", db_status(err))
 					flog("addq_menu: failed to read quiz")
 					log.Println(err)
+				} else if passages, err := s.db.RetrievePassages(); err != nil {
+					http.Error(w, "failed to read passages", db_status(err))
+					flog("addq_menu: failed to read passages")
+					log.Println(err)
 				} else {
-					err = t.Execute(w, quiz)
+					err = t.Execute(w, addq_page{Quiz: quiz, Passages: passages})
 					if err != nil {
 						http.Error(w, "failed to execute template", 500)
 						flog("addq_menu: failed to execute template")
@@ -183,7 +381,7 @@ func addq_menu(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func add_question(w http.ResponseWriter, r *http.Request) {
+func (s *server) add_question(w http.ResponseWriter, r *http.Request) {
 	session, err := store.Get(r, "login")
 	if err != nil {
 		http.Error(w, "failed to retrieve session", 500)
@@ -198,34 +396,56 @@ func add_question(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, "failed to parse form", 500)
 				flog("add_question: failed to parse form")
 			} else {
-				question := new(functions.Question)
-				err = decoder.Decode(question, r.PostForm)
+				question := new(functions.PostQuestion)
+				err = rows_decoder.Decode(question, r.PostForm)
 				if err != nil {
 					http.Error(w, "failed to read form", 500)
 					flog("add_question: failed to read form")
 					log.Println(err)
 				} else {
-					id, ok := mux.Vars(r)["id"]
-					if !ok {
-						http.Error(w, "Invalid GET parameters", 500)
+					id, err := functions.ParseQuizID(mux.Vars(r)["id"])
+					if err != nil {
+						http.Error(w, "quiz not found", 404)
 					} else {
-						// tmp, _ := hex.DecodeString(id)
-						// id = string(tmp)
-						quiz, err := functions.RetrieveQuiz(id)
-						if err != nil {
-							http.Error(w, "failed to retrieve quiz", 500)
-							flog("add_question: failed to retrieve quiz")
-							log.Println(err)
-						} else {
-							quiz.Questions = append(quiz.Questions, *question)
-							err = functions.UpdateQuiz(quiz)
+						// Invalid questions go back to the form.  Valid ones are appended only if nobody has changed the quiz since it was loaded.
+						added := question.GetQuestion()
+						invalid := s.validate_question(added)
+						if invalid == nil {
+							err = s.db.AddQuestion(id, question.Version, added)
+						}
+						if invalid != nil || err == functions.ErrConflict {
+							// Show the quiz as it is now, with the admin's question still filled in so it can be checked and resent
+							quiz, err := s.db.RetrieveQuiz(id)
 							if err != nil {
-								http.Error(w, "failed to update quiz", 500)
-								flog("add_question: failed to update quiz")
+								http.Error(w, "failed to retrieve quiz", db_status(err))
+								flog("add_question: failed to retrieve quiz")
+								log.Println(err)
+							} else if passages, err := s.db.RetrievePassages(); err != nil {
+								http.Error(w, "failed to read passages", db_status(err))
+								flog("add_question: failed to read passages")
 								log.Println(err)
 							} else {
-								http.Redirect(w, r, "/addq/"+id, 302)
+								page := addq_page{Quiz: quiz, Pending: *question, Passages: passages}
+								if invalid != nil {
+									page.Error = invalid.Error()
+									w.WriteHeader(400)
+								} else {
+									page.Conflict = true
+									w.WriteHeader(409)
+								}
+								t, _ := template.ParseFiles("templates/addq.html")
+								err = t.Execute(w, page)
+								if err != nil {
+									flog("add_question: failed to execute template")
+									log.Println(err)
+								}
 							}
+						} else if err != nil {
+							http.Error(w, "failed to add question", db_status(err))
+							flog("add_question: failed to add question")
+							log.Println(err)
+						} else {
+							http.Redirect(w, r, "/addq/"+id.String(), 302)
 						}
 					}
 				}
@@ -234,194 +454,431 @@ func add_question(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func admin_panel(w http.ResponseWriter, r *http.Request) {
+func (s *server) validate_question(question functions.Question) error {
+	// Validate, and check that the passage the question refers to exists
+	err := question.Validate()
+	if err == nil && question.Passage != "" {
+		_, err = s.db.RetrievePassage(question.Passage)
+		if err == functions.ErrNotFound {
+			err = errors.New("the passage it refers to doesn't exist")
+		}
+	}
+	return err
+}
+
+func (s *server) edit_quiz(w http.ResponseWriter, r *http.Request, name string, edit func(quiz *functions.Quiz) error) {
+	// Shared by the forms on /addq/{id}: applies edit to the quiz and saves it, as long as nobody has changed it since
+	// the version posted with the form.  Goes back to /addq/{id} afterwards.
 	session, err := store.Get(r, "login")
 	if err != nil {
 		http.Error(w, "failed to retrieve session", 500)
-		flog("admin_panel: failed to retrieve session")
+		flog(name + ": failed to retrieve session")
 	} else {
 		role, ok := session.Values["role"].(string)
 		if !ok || (role != "su" && role != "admin") {
 			http.Error(w, "failed to verify admin privileges.  are you logged in?", 500)
+		} else if err = r.ParseForm(); err != nil {
+			http.Error(w, "failed to parse form", 500)
+			flog(name + ": failed to parse form")
 		} else {
-			quizzes, err := functions.RetrieveQuizzes("")
+			id, err := functions.ParseQuizID(mux.Vars(r)["id"])
 			if err != nil {
-				http.Error(w, "failed to retrieve quizzes", 500)
-				flog("admin_panel: failed to retrieve quizzes")
-				log.Println(err)
+				http.Error(w, "quiz not found", 404)
 			} else {
-				t, _ := template.ParseFiles("templates/admin.html")
-				newQuizzes := []functions.TmplQuiz{}
-				for i := 0; i < len(quizzes); i++ {
-					newQuizzes = append(newQuizzes, quizzes[i].GetTmplQuiz())
+				version, err := strconv.Atoi(r.PostFormValue("version"))
+				if err != nil {
+					http.Error(w, "missing quiz version", 400)
+				} else {
+					quiz, err := s.db.RetrieveQuiz(id)
+					if err != nil {
+						http.Error(w, "failed to retrieve quiz", db_status(err))
+						flog(name + ": failed to retrieve quiz")
+						log.Println(err)
+					} else if err = edit(&quiz); err == functions.ErrNotFound {
+						http.Error(w, "question not found", 404)
+					} else if err != nil {
+						http.Error(w, err.Error(), 400)
+					} else {
+						quiz.Version = version
+						err = s.db.UpdateQuiz(quiz)
+						if err == functions.ErrConflict {
+							http.Error(w, "this quiz was changed by someone else while you were editing it.  go back to the quiz to see the changes, then try again.", 409)
+						} else if err != nil {
+							http.Error(w, "failed to update quiz", db_status(err))
+							flog(name + ": failed to update quiz")
+							log.Println(err)
+						} else {
+							http.Redirect(w, r, "/addq/"+id.String(), 302)
+						}
+					}
 				}
-				err := t.Execute(w, newQuizzes)
+			}
+		}
+	}
+}
+
+func (s *server) edit_question(w http.ResponseWriter, r *http.Request) {
+	// Replaces a question's text, answers and correct answer.  It keeps its ID and place in the quiz.
+	s.edit_quiz(w, r, "edit_question", func(quiz *functions.Quiz) error {
+		posted := new(functions.PostQuestion)
+		err := rows_decoder.Decode(posted, r.PostForm)
+		if err != nil {
+			return errors.New("failed to read form")
+		}
+		question := posted.GetQuestion()
+		question.Id = mux.Vars(r)["question"]
+		err = s.validate_question(question)
+		if err != nil {
+			return err
+		}
+		return quiz.ReplaceQuestion(question)
+	})
+}
+
+func (s *server) delete_question(w http.ResponseWriter, r *http.Request) {
+	s.edit_quiz(w, r, "delete_question", func(quiz *functions.Quiz) error {
+		return quiz.RemoveQuestion(mux.Vars(r)["question"])
+	})
+}
+
+func (s *server) move_question(w http.ResponseWriter, r *http.Request) {
+	// Moves a question one place up (direction=up) or down (direction=down)
+	s.edit_quiz(w, r, "move_question", func(quiz *functions.Quiz) error {
+		by := 1
+		if r.PostFormValue("direction") == "up" {
+			by = -1
+		}
+		return quiz.MoveQuestion(mux.Vars(r)["question"], by)
+	})
+}
+
+func (s *server) rename_quiz(w http.ResponseWriter, r *http.Request) {
+	s.edit_quiz(w, r, "rename_quiz", func(quiz *functions.Quiz) error {
+		title := strings.TrimSpace(r.PostFormValue("title"))
+		if title == "" {
+			return errors.New("the title can't be empty")
+		}
+		quiz.Title = title
+		return nil
+	})
+}
+
+func (s *server) review_settings(w http.ResponseWriter, r *http.Request) {
+	// When students may see the answers and explanations: review=attempt, never, or after with review_date (YYYY-MM-DDTHH:MM, server time)
+	s.edit_quiz(w, r, "review_settings", func(quiz *functions.Quiz) error {
+		policy := r.PostFormValue("review")
+		if !functions.ValidReviewPolicy(policy) {
+			return errors.New("unknown review setting")
+		}
+		date := time.Time{}
+		if policy == functions.ReviewAfterDue {
+			var err error
+			date, err = time.ParseInLocation("2006-01-02T15:04", r.PostFormValue("review_date"), time.Local)
+			if err != nil {
+				return errors.New("give the date answers become available")
+			}
+		}
+		quiz.Review = policy
+		quiz.ReviewDate = date
+		return nil
+	})
+}
+
+func (s *server) time_limit(w http.ResponseWriter, r *http.Request) {
+	// Minutes students have to finish the quiz, or 0 for no limit.  Attempts already started keep their deadlines.
+	s.edit_quiz(w, r, "time_limit", func(quiz *functions.Quiz) error {
+		minutes, err := strconv.Atoi(strings.TrimSpace(r.PostFormValue("time_limit")))
+		if err != nil || minutes < 0 || minutes > functions.MaxTimeLimit {
+			return fmt.Errorf("the time limit must be from 0 to %d minutes", functions.MaxTimeLimit)
+		}
+		quiz.TimeLimit = minutes
+		return nil
+	})
+}
+
+func (s *server) scoring_policy(w http.ResponseWriter, r *http.Request) {
+	// How answers are scored (see functions.ScoringPolicy).  Attempts already graded keep the policy they were scored under.
+	s.edit_quiz(w, r, "scoring_policy", func(quiz *functions.Quiz) error {
+		policy := functions.ScoringPolicy{
+			Partial:  r.PostFormValue("partial"),
+			Weighted: r.PostFormValue("weighted") == "true",
+		}
+		if penalty := strings.TrimSpace(r.PostFormValue("penalty")); penalty != "" {
+			value, err := strconv.ParseFloat(penalty, 32)
+			if err != nil {
+				return errors.New("the penalty is a fraction of the question's points, from 0 to 1")
+			}
+			policy.Penalty = float32(value)
+		}
+		if err := policy.Validate(); err != nil {
+			return err
+		}
+		quiz.Policy = policy
+		return nil
+	})
+}
+
+func (s *server) delete_quiz(w http.ResponseWriter, r *http.Request) {
+	// GET asks for confirmation; POST (with the quiz version) deletes the quiz and its history
+	session, err := store.Get(r, "login")
+	if err != nil {
+		http.Error(w, "failed to retrieve session", 500)
+		flog("delete_quiz: failed to retrieve session")
+	} else {
+		role, ok := session.Values["role"].(string)
+		if !ok || (role != "su" && role != "admin") {
+			http.Error(w, "failed to verify admin privileges.  are you logged in?", 500)
+		} else {
+			id, err := functions.ParseQuizID(mux.Vars(r)["id"])
+			if err != nil {
+				http.Error(w, "quiz not found", 404)
+			} else if r.Method != "POST" {
+				quiz, err := s.db.RetrieveQuiz(id)
 				if err != nil {
-					http.Error(w, "failed to execute template", 500)
-					flog("admin_panel: failed to execute template")
+					http.Error(w, "failed to retrieve quiz", db_status(err))
+					flog("delete_quiz: failed to retrieve quiz")
+				} else {
+					t, _ := template.ParseFiles("templates/delete_quiz.html")
+					err = t.Execute(w, quiz)
+					if err != nil {
+						http.Error(w, "failed to execute template", 500)
+						flog("delete_quiz: failed to execute template")
+					}
+				}
+			} else {
+				version, err := strconv.Atoi(r.PostFormValue("version"))
+				in_test, test_err := s.test_section(id)
+				if err != nil {
+					http.Error(w, "missing quiz version", 400)
+				} else if test_err != nil {
+					http.Error(w, "failed to retrieve tests", db_status(test_err))
+					flog("delete_quiz: failed to retrieve tests")
+					log.Println(test_err)
+				} else if in_test {
+					// Sittings in progress would lose the section.  The test has to drop it first.
+					http.Error(w, "this quiz is a section of a test.  remove it from the test before deleting it.", 409)
+				} else if err = s.db.DeleteQuiz(id, version); err == functions.ErrConflict {
+					http.Error(w, "this quiz was changed by someone else while you were looking at it.  go back to the quiz to see the changes, then decide again.", 409)
+				} else if err != nil {
+					http.Error(w, "failed to delete quiz", db_status(err))
+					flog("delete_quiz: failed to delete quiz")
+					log.Println(err)
+				} else {
+					http.Redirect(w, r, "/admin", 302)
 				}
 			}
 		}
 	}
 }
 
-func grade_quiz(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseForm()
+type passage_page struct { // Data for passage.html
+	Passage functions.Passage
+	Error   string // Why the passage wasn't saved
+}
+
+func (s *server) list_passages(w http.ResponseWriter, r *http.Request) {
+	// GET lists every passage; POST adds one and goes on to it
+	session, err := store.Get(r, "login")
 	if err != nil {
-		http.Error(w, "failed to parse form", 500)
-		flog("grade_quiz: failed to parse form")
+		http.Error(w, "failed to retrieve session", 500)
+		flog("list_passages: failed to retrieve session")
 	} else {
-		vars := mux.Vars(r)
-		id, ok := vars["id"]
-		if !ok {
-			http.Error(w, "missing GET parameters", 404)
+		role, ok := session.Values["role"].(string)
+		if !ok || (role != "su" && role != "admin") {
+			http.Error(w, "failed to verify admin privileges.  are you logged in?", 500)
+		} else if r.Method != "POST" {
+			passages, err := s.db.RetrievePassages()
+			if err != nil {
+				http.Error(w, "failed to retrieve passages", db_status(err))
+				flog("list_passages: failed to retrieve passages")
+				log.Println(err)
+			} else {
+				t, _ := template.ParseFiles("templates/passages.html")
+				err = t.Execute(w, passages)
+				if err != nil {
+					http.Error(w, "failed to execute template", 500)
+					flog("list_passages: failed to execute template")
+				}
+			}
+		} else if err = r.ParseForm(); err != nil {
+			http.Error(w, "failed to parse form", 500)
+			flog("list_passages: failed to parse form")
 		} else {
-			quiz := new(functions.Quiz)
-			err = decoder.Decode(quiz, r.PostForm)
+			passage := functions.Passage{}
+			err = decoder.Decode(&passage, r.PostForm)
 			if err != nil {
 				http.Error(w, "failed to read form", 500)
-				flog("grade_quiz: failed to read form")
+				flog("list_passages: failed to read form")
 				log.Println(err)
+			} else if err = passage.Validate(); err != nil {
+				http.Error(w, err.Error(), 400)
 			} else {
-				quiz.Id = id
-				grade, err := quiz.Grade()
+				id, err := s.db.InsertPassage(passage)
 				if err != nil {
-					http.Error(w, "failed to grade quiz", 500)
-					flog("grade_quiz: failed to grade quiz")
+					http.Error(w, "failed to insert passage", db_status(err))
+					flog("list_passages: failed to insert passage")
+					log.Println(err)
 				} else {
-					session, err := store.Get(r, "login")
-					if err == nil {
-						login, ok := session.Values["username"]
-						if ok {
-							username, ok := login.(string)
-							if ok {
-								functions.UpdateScoreUsername(username, grade)
-							}
-						}
-					}
-					fmt.Fprintf(w, "Your grade is: %f%%", grade)
+					http.Redirect(w, r, "/passage/"+id, 302)
 				}
 			}
 		}
 	}
 }
 
-func view_score(w http.ResponseWriter, r *http.Request) {
+func (s *server) edit_passage(w http.ResponseWriter, r *http.Request) {
+	// GET shows the passage as students see it, with a form to change it; POST saves the form.  Every question using
+	// the passage, in any quiz, shows the change.
 	session, err := store.Get(r, "login")
 	if err != nil {
 		http.Error(w, "failed to retrieve session", 500)
-		flog("view_score: failed to retrieve session")
+		flog("edit_passage: failed to retrieve session")
 	} else {
-		login, ok := session.Values["username"]
-		if !ok {
-			http.Error(w, "You are not logged in", 500)
+		role, ok := session.Values["role"].(string)
+		if !ok || (role != "su" && role != "admin") {
+			http.Error(w, "failed to verify admin privileges.  are you logged in?", 500)
 		} else {
-			username, ok := login.(string)
-			if !ok {
-				http.Error(w, "internal server error", 500)
+			passage, err := s.db.RetrievePassage(mux.Vars(r)["id"])
+			if err != nil {
+				http.Error(w, "failed to retrieve passage", db_status(err))
+				flog("edit_passage: failed to retrieve passage")
+				log.Println(err)
+			} else if r.Method != "POST" {
+				show_passage(w, passage_page{Passage: passage})
+			} else if err = r.ParseForm(); err != nil {
+				http.Error(w, "failed to parse form", 500)
+				flog("edit_passage: failed to parse form")
 			} else {
-				user, err := functions.GetUser(username)
+				page := passage_page{Passage: passage}
+				err = decoder.Decode(&page.Passage, r.PostForm)
 				if err != nil {
-					http.Error(w, "failed to retrieve user data", 500)
-					flog("view_score: failed to retrieve user data")
+					http.Error(w, "failed to read form", 500)
+					flog("edit_passage: failed to read form")
+					log.Println(err)
+				} else if err = page.Passage.Validate(); err != nil {
+					// Back to the form with the admin's text, so nothing is lost
+					page.Error = err.Error()
+					w.WriteHeader(400)
+					show_passage(w, page)
+				} else if err = s.db.UpdatePassage(page.Passage); err != nil {
+					http.Error(w, "failed to update passage", db_status(err))
+					flog("edit_passage: failed to update passage")
+					log.Println(err)
 				} else {
-					fmt.Fprintf(w, "Your highest score is %f%%", user.MaxScore)
+					http.Redirect(w, r, "/passage/"+passage.Id, 302)
 				}
 			}
 		}
 	}
 }
 
-func get_all_quizzes(w http.ResponseWriter, r *http.Request) {
-	quizzes, err := functions.RetrieveQuizzes("")
+func show_passage(w http.ResponseWriter, page passage_page) {
+	t, _ := template.ParseFiles("templates/passage.html")
+	err := t.Execute(w, page)
 	if err != nil {
-		http.Error(w, "failed to retrieve quizzes", 500)
-		flog("get_all_quizzes: failed to retrieve quizzes")
-	} else {
-		t, _ := template.ParseFiles("templates/all_quizzes.html")
-		err = t.Execute(w, quizzes)
-		if err != nil {
-			http.Error(w, "failed to execute template", 500)
-			flog("get_all_quizzes: failed to execute template")
-		}
+		flog("edit_passage: failed to execute template")
+		log.Println(err)
 	}
 }
 
-func display_quiz(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	q_id, ok := vars["id"]
-	if !ok {
-		http.Error(w, "error: page not found--quiz page requires id parameter", 404)
+func (s *server) export_quiz(w http.ResponseWriter, r *http.Request) {
+	// Downloads the quiz and its passages as JSON, for import_quiz here or on another server
+	session, err := store.Get(r, "login")
+	if err != nil {
+		http.Error(w, "failed to retrieve session", 500)
+		flog("export_quiz: failed to retrieve session")
 	} else {
-		quiz, err := functions.RetrieveQuiz(q_id)
-		if err != nil {
-			http.Error(w, "failed to retrieve quiz", 500)
-			log.Println(err)
-			flog("display_quiz: failed to retrieve quiz")
+		role, ok := session.Values["role"].(string)
+		if !ok || (role != "su" && role != "admin") {
+			http.Error(w, "failed to verify admin privileges.  are you logged in?", 500)
 		} else {
-			t, err := template.ParseFiles("templates/quiz.html")
+			id, err := functions.ParseQuizID(mux.Vars(r)["id"])
 			if err != nil {
-				log.Println(err)
+				http.Error(w, "quiz not found", 404)
 			} else {
-				err = t.Execute(w, quiz.GetTmplQuiz())
+				export, err := functions.ExportQuiz(s.db, id)
 				if err != nil {
-					http.Error(w, "failed to execute template", 500)
-					flog("display_quiz: failed to execute template")
+					http.Error(w, "failed to export quiz", db_status(err))
+					flog("export_quiz: failed to export quiz")
 					log.Println(err)
+				} else {
+					w.Header().Set("Content-Type", "application/json")
+					w.Header().Set("Content-Disposition", `attachment; filename="quiz-`+id.String()+`.json"`)
+					err = json.NewEncoder(w).Encode(export)
+					if err != nil {
+						flog("export_quiz: failed to write quiz")
+						log.Println(err)
+					}
 				}
 			}
 		}
 	}
 }
 
-func create_account_get(w http.ResponseWriter, r *http.Request) {
-	t, err := template.ParseFiles("templates/acct_created.html")
-	err = t.Execute(w, functions.SuccessLogin{false, "", "", false})
+func (s *server) import_quiz(w http.ResponseWriter, r *http.Request) {
+	// Saves an uploaded export (form field "file") as a new unpublished quiz, then goes to it
+	session, err := store.Get(r, "login")
 	if err != nil {
-		http.Error(w, "failed to execute template", 500)
-		flog("create_account_get: failed to execute template")
+		http.Error(w, "failed to retrieve session", 500)
+		flog("import_quiz: failed to retrieve session")
+	} else {
+		role, ok := session.Values["role"].(string)
+		if !ok || (role != "su" && role != "admin") {
+			http.Error(w, "failed to verify admin privileges.  are you logged in?", 500)
+		} else {
+			file, _, err := r.FormFile("file")
+			if err != nil {
+				http.Error(w, "no file uploaded", 400)
+			} else {
+				defer file.Close()
+				export := functions.QuizExport{}
+				err = json.NewDecoder(io.LimitReader(file, maxImportSize)).Decode(&export)
+				if err != nil {
+					http.Error(w, "the file isn't a quiz export", 400)
+				} else if err = export.Validate(); err != nil {
+					http.Error(w, "can't import this quiz: "+err.Error(), 400)
+				} else {
+					author, _ := session.Values["username"].(string)
+					id, err := functions.ImportQuiz(s.db, export, author)
+					if err != nil {
+						http.Error(w, "failed to import quiz", db_status(err))
+						flog("import_quiz: failed to import quiz")
+						log.Println(err)
+					} else {
+						http.Redirect(w, r, "/addq/"+id.String(), 302)
+					}
+				}
+			}
+		}
 	}
 }
 
-func create_account_post(w http.ResponseWriter, r *http.Request) {
-	// Creates an account from a post request.  Password is hashed with bcrypt.
-	// If the request originator is logged in as an admin, role is set to the form value.  Otherwise, role is set to user.
-	err := r.ParseForm()
+func (s *server) admin_panel(w http.ResponseWriter, r *http.Request) {
+	session, err := store.Get(r, "login")
 	if err != nil {
-		http.Error(w, "failed to parse form", 500)
-		flog("create_account_post: failed to parse form")
+		http.Error(w, "failed to retrieve session", 500)
+		flog("admin_panel: failed to retrieve session")
 	} else {
-		result := new(functions.User)
-		err = decoder.Decode(result, r.PostForm)
-		if err != nil {
-			http.Error(w, "failed to read form", 500)
-			flog("create_account_post: failed to read form")
+		role, ok := session.Values["role"].(string)
+		if !ok || (role != "su" && role != "admin") {
+			http.Error(w, "failed to verify admin privileges.  are you logged in?", 500)
 		} else {
-			session, err := store.Get(r, "login")
+			query := functions.QuizQuery{}
+			err = query_decoder.Decode(&query, r.URL.Query())
 			if err != nil {
-				http.Error(w, "internal server error", 500)
+				http.Error(w, "invalid query parameters", 400)
 			} else {
-				if role, ok := session.Values["role"]; !ok || role.(string) != "admin" {
-					// Not logged in as admin: sets role to user
-					result.Role = "user"
-				}
-				t, _ := template.ParseFiles("templates/acct_created.html")
-				err = functions.CreateAccount(*result)
-				if err != nil && err.Error() == "user already exists" {
-					err = t.Execute(w, functions.SuccessLogin{false, result.Username, "", true})
-					if err != nil {
-						http.Error(w, "failed to execute template", 500)
-						flog("create_account_post: failed to execute template 1")
-					}
-				} else if err != nil {
-					http.Error(w, "internal server error", 500)
-					flog("create_account_post: create account failed")
+				page, err := s.db.RetrieveQuizzes(query)
+				if err != nil {
+					http.Error(w, "failed to retrieve quizzes", db_status(err))
+					flog("admin_panel: failed to retrieve quizzes")
 					log.Println(err)
 				} else {
-					err = t.Execute(w, functions.SuccessLogin{true, result.Username, result.Role, true})
+					t, _ := template.ParseFiles("templates/admin.html")
+					err := t.Execute(w, page)
 					if err != nil {
 						http.Error(w, "failed to execute template", 500)
-						flog("create_account_post: failed to execute template 2")
+						flog("admin_panel: failed to execute template")
 					}
 				}
 			}
@@ -429,25 +886,1515 @@ func create_account_post(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func post_login(w http.ResponseWriter, r *http.Request) {
-	// Handles login requests.  Currently set up for plaintext passwords.
+func (s *server) grade_quiz(w http.ResponseWriter, r *http.Request) {
+	// Answers come as q.<question id> values (see functions.Answers), graded against the quiz at the posted version.
+	// Logged-in students post the attempt display_quiz started instead; see grade_attempt.
 	err := r.ParseForm()
 	if err != nil {
-		http.Error(w, "failed to parse form", 500)
-		flog("post_login: failed to parse form")
+		http.Error(w, "failed to parse form", 400)
+		flog("grade_quiz: failed to parse form")
 	} else {
-		result := new(functions.User)
-		err = decoder.Decode(result, r.PostForm)
+		id, err := functions.ParseQuizID(mux.Vars(r)["id"])
 		if err != nil {
-			http.Error(w, "failed to read form", 500)
-			flog("post_login: failed to read form")
+			http.Error(w, "quiz not found", 404)
+		} else if r.PostFormValue("attempt") != "" {
+			s.grade_attempt(w, r, id)
 		} else {
-			account, err := functions.CheckLogin(*result)
-			if err != nil && err.Error() == "login failed" {
-				time.Sleep(3 * time.Second)
-				fmt.Fprintf(w, "invalid username or password")
-			} else if err != nil {
-				http.Error(w, "internal server error", 500)
+			posted := functions.Quiz{Id: id}
+			if r.PostFormValue("version") != "" {
+				posted.Version, err = strconv.Atoi(r.PostFormValue("version"))
+			}
+			answers := functions.ReadAnswers(r.PostForm)
+			if err != nil || posted.Version < 0 {
+				http.Error(w, "bad quiz version", 400)
+			} else {
+				role := ""
+				if session, err := store.Get(r, "login"); err == nil {
+					role, _ = session.Values["role"].(string)
+				}
+				admin := role == "su" || role == "admin"
+				current, err := s.db.RetrieveQuiz(id)
+				quiz := current
+				section := false
+				if err == nil {
+					section, err = s.test_section(id)
+				}
+				if err == nil {
+					quiz, err = posted.GradedAgainst(s.db)
+				}
+				if err != nil {
+					http.Error(w, "failed to grade quiz", db_status(err))
+					flog("grade_quiz: failed to retrieve quiz")
+					log.Println(err)
+				} else if !current.Published && !admin {
+					http.Error(w, "quiz not found", 404)
+				} else if section && !admin {
+					http.Error(w, "this quiz is a section of a full-length test.  start the test to take it.", 403)
+				} else if current.Timed() {
+					http.Error(w, "this quiz is timed.  open it again to start the clock.", 400)
+				} else if err = quiz.CheckAnswers(answers); err != nil {
+					http.Error(w, err.Error(), 400)
+				} else {
+					attempt := functions.Attempt{}
+					if session, err := store.Get(r, "login"); err == nil {
+						attempt.Username, _ = session.Values["username"].(string)
+					}
+					attempt, err = s.finish_attempt(quiz, answers, attempt)
+					if err != nil {
+						http.Error(w, "failed to save your answers", db_status(err))
+						flog("grade_quiz: failed to save attempt")
+						log.Println(err)
+					} else {
+						s.show_results(w, quiz, attempt)
+					}
+				}
+			}
+		}
+	}
+}
+
+func (s *server) grade_attempt(w http.ResponseWriter, r *http.Request, id functions.QuizID) {
+	// Finishes the attempt posted with the answers.  For timed attempts, answers that arrive after the deadline (and
+	// SubmitGrace) don't count: the attempt is graded on the ones save_answer stored while there was still time.
+	// A section of a full-length test goes back to its sitting rather than showing the section's results.
+	attempt, quiz, err := s.open_attempt(r, r.PostFormValue("attempt"))
+	answers := functions.ReadAnswers(r.PostForm)
+	role := ""
+	if session, err := store.Get(r, "login"); err == nil {
+		role, _ = session.Values["role"].(string)
+	}
+	section := false
+	if err == nil && attempt.Sitting == "" && role != "su" && role != "admin" {
+		// Only admins' previews take a test's section outside a sitting
+		section, err = s.test_section(id)
+	}
+	if err == functions.ErrConflict {
+		http.Error(w, "this attempt has already been submitted.", 409)
+	} else if err != nil {
+		http.Error(w, "failed to retrieve attempt", db_status(err))
+		flog("grade_attempt: failed to retrieve attempt")
+		log.Println(err)
+	} else if attempt.Quiz != id {
+		http.Error(w, "attempt not found", 404)
+	} else if section {
+		http.Error(w, "this quiz is a section of a full-length test.  start the test to take it.", 403)
+	} else {
+		if attempt.Expired(time.Now()) {
+			answers = attempt.Responses
+		}
+		if err = quiz.CheckAnswers(answers); err != nil {
+			http.Error(w, err.Error(), 400)
+		} else {
+			attempt, err = s.finish_attempt(quiz, answers, attempt)
+			if err != nil {
+				http.Error(w, "failed to save your answers", db_status(err))
+				flog("grade_attempt: failed to save attempt")
+				log.Println(err)
+			} else if attempt.Sitting != "" {
+				// view_sitting moves the sitting on to the next section
+				http.Redirect(w, r, "/sitting/"+attempt.Sitting, 303)
+			} else {
+				s.show_results(w, quiz, attempt)
+			}
+		}
+	}
+}
+
+func (s *server) finish_attempt(quiz functions.Quiz, answers functions.Answers, attempt functions.Attempt) (functions.Attempt, error) {
+	// Grades answers against quiz, the stored quiz they were given for, and records the attempt.  attempt is either
+	// one in progress on the server or just who took the quiz; nothing is recorded for students who aren't logged in.
+	submission := quiz.Submit(answers)
+	submission.Username = attempt.Username
+	err := s.db.CountAttempt(quiz.Id, quiz.Version)
+	if err != nil {
+		flog("finish_attempt: failed to count attempt")
+		log.Println(err)
+	}
+	err = nil
+	if !submission.Graded {
+		// Essays are scored by hand; the attempt's score is recorded when the last one is
+		submission.Id, err = s.db.InsertSubmission(submission)
+	}
+	if err == nil && attempt.Id != "" {
+		attempt = attempt.Finish(answers, submission, time.Now())
+		err = s.db.UpdateAttempt(attempt)
+	} else if err == nil {
+		attempt = functions.NewAttempt(quiz, answers, submission, attempt.Started)
+		if attempt.Username != "" {
+			attempt.Id, err = s.db.InsertAttempt(attempt)
+		}
+	}
+	return attempt, err
+}
+
+func (s *server) open_attempt(r *http.Request, id string) (functions.Attempt, functions.Quiz, error) {
+	// The logged-in student's attempt in progress with the given id, and the stored quiz it is taken against.
+	// ErrNotFound if it isn't theirs, ErrConflict if it has already finished.
+	username := ""
+	if session, err := store.Get(r, "login"); err == nil {
+		username, _ = session.Values["username"].(string)
+	}
+	attempt, err := s.db.RetrieveAttempt(id)
+	if err != nil {
+		return attempt, functions.Quiz{}, err
+	} else if username == "" || attempt.Username != username {
+		return functions.Attempt{}, functions.Quiz{}, functions.ErrNotFound
+	} else if !attempt.InProgress() {
+		return attempt, functions.Quiz{}, functions.ErrConflict
+	}
+	quiz, err := functions.Quiz{Id: attempt.Quiz, Version: attempt.Version}.GradedAgainst(s.db)
+	return attempt, quiz, err
+}
+
+func (s *server) start_attempt(username string, quiz functions.Quiz) (functions.Attempt, functions.Quiz, error) {
+	// The student's attempt in progress at the quiz and the stored quiz it is taken against, so their answers can be
+	// saved as they go.  Opening the quiz again, from any device, carries on with the same attempt, answers and
+	// deadline; once a timed one has run out, it is graded on its saved answers and a new one is started.  Students
+	// who aren't logged in get no attempt until they are graded.
+	if username == "" {
+		return functions.Attempt{}, quiz, nil
+	}
+	attempt, err := s.db.RetrieveOpenAttempt(username, quiz.Id)
+	if err == nil {
+		var shown functions.Quiz
+		shown, err = functions.Quiz{Id: quiz.Id, Version: attempt.Version}.GradedAgainst(s.db)
+		if err == nil && !attempt.Expired(time.Now()) {
+			return attempt, shown, nil
+		} else if err == nil {
+			_, err = s.finish_attempt(shown, attempt.Responses, attempt)
+		}
+	}
+	if err != nil && err != functions.ErrNotFound {
+		return attempt, quiz, err
+	}
+	attempt = functions.StartAttempt(quiz, username, time.Now())
+	attempt.Id, err = s.db.InsertAttempt(attempt)
+	return attempt, quiz, err
+}
+
+func (s *server) save_answer(w http.ResponseWriter, r *http.Request) {
+	// Saves one answer of the student's attempt in progress, posted by static/quiz.js whenever the question changes:
+	// its q.<question id> values, or none if it was cleared.  Timed attempts stop taking answers once time is up.
+	if r.Method != "POST" {
+		http.Error(w, "answers must be posted", 405)
+	} else if err := r.ParseForm(); err != nil {
+		http.Error(w, "failed to parse form", 400)
+		flog("save_answer: failed to parse form")
+	} else {
+		attempt, quiz, err := s.open_attempt(r, mux.Vars(r)["id"])
+		question := mux.Vars(r)["question"]
+		response := r.PostForm[functions.AnswerPrefix+question]
+		if err == functions.ErrConflict {
+			http.Error(w, "this attempt has already been submitted.", 409)
+		} else if err != nil {
+			http.Error(w, "failed to retrieve attempt", db_status(err))
+			flog("save_answer: failed to retrieve attempt")
+			log.Println(err)
+		} else if attempt.Expired(time.Now()) {
+			http.Error(w, "time is up for this attempt.", 409)
+		} else if err = quiz.CheckResponse(question, response); err == functions.ErrNotFound {
+			http.Error(w, "question not found", 404)
+		} else if err != nil {
+			http.Error(w, err.Error(), 400)
+		} else {
+			err = s.db.SaveResponse(attempt.Id, question, response)
+			if err == functions.ErrConflict {
+				http.Error(w, "this attempt has already been submitted.", 409)
+			} else if err != nil {
+				http.Error(w, "failed to save your answer", db_status(err))
+				flog("save_answer: failed to save answer")
+				log.Println(err)
+			} else {
+				w.WriteHeader(204)
+			}
+		}
+	}
+}
+
+type results_page struct { // Data for results.html
+	Quiz    functions.Quiz // As it is now, for its review settings
+	Taken   bool           // Whether this is a student's results, rather than just the answers
+	Attempt functions.Attempt
+	Pending int                    // Essays still to be graded by hand
+	Items   []functions.ReviewItem // Empty unless the quiz can be reviewed now
+}
+
+func (s *server) show_results(w http.ResponseWriter, graded functions.Quiz, attempt functions.Attempt) {
+	// The grade, and each question with the student's answer and the explanation if the quiz allows it yet.
+	// graded is the quiz as it was graded; the review settings are taken from the quiz as it is now.
+	page := results_page{Taken: true, Attempt: attempt}
+	quiz, err := s.db.RetrieveQuiz(graded.Id)
+	if err == nil {
+		page.Quiz = quiz
+		if quiz.ReviewOpen(time.Now()) {
+			page.Items = attempt.ScoredAgainst(graded).ReviewItems(attempt.Responses)
+		}
+	}
+	if err == nil && !attempt.Graded {
+		var submission functions.Submission
+		submission, err = s.db.RetrieveSubmission(attempt.Submission)
+		page.Pending = submission.Remaining()
+	}
+	if err != nil {
+		// The grade is already recorded, so still show it
+		flog("show_results: failed to load review")
+		log.Println(err)
+	}
+	t, _ := template.ParseFiles("templates/results.html")
+	err = t.Execute(w, page)
+	if err != nil {
+		flog("show_results: failed to execute template")
+		log.Println(err)
+	}
+}
+
+func (s *server) list_attempts(w http.ResponseWriter, r *http.Request) {
+	// The logged-in student's attempts, newest first.  Counselors and admins can list any student's with ?user=
+	session, err := store.Get(r, "login")
+	if err != nil {
+		http.Error(w, "failed to retrieve session", 500)
+		flog("list_attempts: failed to retrieve session")
+	} else {
+		username, _ := session.Values["username"].(string)
+		role, _ := session.Values["role"].(string)
+		student := r.URL.Query().Get("user")
+		if student == "" {
+			student = username
+		}
+		if username == "" {
+			http.Error(w, "You are not logged in", 403)
+		} else if student != username && !can_view_scores(role) {
+			http.Error(w, "only counselors can view other students' attempts.", 403)
+		} else {
+			attempts, err := s.db.RetrieveAttempts(student)
+			if err != nil {
+				http.Error(w, "failed to retrieve attempts", db_status(err))
+				flog("list_attempts: failed to retrieve attempts")
+				log.Println(err)
+			} else {
+				user, err := s.db.GetUser(student)
+				if err != nil {
+					http.Error(w, "failed to retrieve user data", db_status(err))
+					flog("list_attempts: failed to retrieve user data")
+				} else {
+					t, _ := template.ParseFiles("templates/attempts.html")
+					err = t.Execute(w, struct {
+						User     functions.User
+						Attempts []functions.Attempt
+					}{user, attempts})
+					if err != nil {
+						flog("list_attempts: failed to execute template")
+						log.Println(err)
+					}
+				}
+			}
+		}
+	}
+}
+
+func (s *server) view_attempt(w http.ResponseWriter, r *http.Request) {
+	// One attempt's results, for the student who made it and for staff.  Answers are shown when the quiz's review settings allow.
+	session, err := store.Get(r, "login")
+	if err != nil {
+		http.Error(w, "failed to retrieve session", 500)
+		flog("view_attempt: failed to retrieve session")
+	} else {
+		username, _ := session.Values["username"].(string)
+		role, _ := session.Values["role"].(string)
+		attempt, err := s.db.RetrieveAttempt(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "failed to retrieve attempt", db_status(err))
+			flog("view_attempt: failed to retrieve attempt")
+			log.Println(err)
+		} else if username == "" || (attempt.Username != username && !can_grade(role)) {
+			http.Error(w, "failed to retrieve attempt", 404)
+		} else if attempt.Legacy() {
+			http.Error(w, "this score was recorded before answers were kept.", 404)
+		} else if attempt.InProgress() {
+			http.Error(w, "this attempt is still in progress.", 404)
+		} else {
+			graded, err := functions.Quiz{Id: attempt.Quiz, Version: attempt.Version}.GradedAgainst(s.db)
+			if err != nil {
+				http.Error(w, "failed to retrieve quiz", db_status(err))
+				flog("view_attempt: failed to retrieve quiz")
+				log.Println(err)
+			} else {
+				s.show_results(w, graded, attempt)
+			}
+		}
+	}
+}
+
+func (s *server) review_quiz(w http.ResponseWriter, r *http.Request) {
+	// The answers and explanations for a quiz, to students who have finished an attempt at it once its review settings
+	// allow.  Counselors and admins can always see them.
+	id, err := functions.ParseQuizID(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "quiz not found", 404)
+	} else {
+		quiz, err := s.db.RetrieveQuiz(id)
+		username, role := "", ""
+		if session, err := store.Get(r, "login"); err == nil {
+			username, _ = session.Values["username"].(string)
+			role, _ = session.Values["role"].(string)
+		}
+		taken := false
+		if err == nil && username != "" && !can_view_scores(role) {
+			taken, err = s.finished_attempt(username, id)
+		}
+		if err != nil {
+			http.Error(w, "failed to retrieve quiz", db_status(err))
+			flog("review_quiz: failed to retrieve quiz")
+			log.Println(err)
+		} else if !can_view_scores(role) && (!taken || !quiz.ReviewOpen(time.Now())) {
+			http.Error(w, "the answers to this quiz aren't available.", 403)
+		} else {
+			t, _ := template.ParseFiles("templates/results.html")
+			err = t.Execute(w, results_page{Quiz: quiz, Items: quiz.ReviewItems(nil)})
+			if err != nil {
+				http.Error(w, "failed to execute template", 500)
+				flog("review_quiz: failed to execute template")
+			}
+		}
+	}
+}
+
+func (s *server) finished_attempt(username string, id functions.QuizID) (bool, error) {
+	// Whether the student has handed in an attempt at the quiz, on its own or as a test section
+	attempts, err := s.db.RetrieveAttempts(username)
+	if err != nil {
+		return false, err
+	}
+	for _, attempt := range attempts {
+		if attempt.Quiz == id && !attempt.InProgress() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func can_grade(role string) bool {
+	// Counselors can score essays, but can't edit quizzes
+	return role == "su" || role == "admin" || role == "counselor"
+}
+
+func can_view_scores(role string) bool {
+	// Counselors and admins can see any student's scores
+	return role == "su" || role == "admin" || role == "counselor"
+}
+
+func (s *server) grading_queue(w http.ResponseWriter, r *http.Request) {
+	// Submissions with essays still to score, oldest first
+	session, err := store.Get(r, "login")
+	if err != nil {
+		http.Error(w, "failed to retrieve session", 500)
+		flog("grading_queue: failed to retrieve session")
+	} else {
+		role, _ := session.Values["role"].(string)
+		if !can_grade(role) {
+			http.Error(w, "failed to verify grading privileges.  are you logged in?", 500)
+		} else {
+			submissions, err := s.db.RetrievePendingSubmissions()
+			if err != nil {
+				http.Error(w, "failed to retrieve submissions", db_status(err))
+				flog("grading_queue: failed to retrieve submissions")
+				log.Println(err)
+			} else {
+				t, _ := template.ParseFiles("templates/grading.html")
+				err = t.Execute(w, submissions)
+				if err != nil {
+					http.Error(w, "failed to execute template", 500)
+					flog("grading_queue: failed to execute template")
+				}
+			}
+		}
+	}
+}
+
+type submission_page struct { // Data for submission.html
+	Submission functions.Submission
+	Error      string // Why the last scores weren't saved
+}
+
+func (s *server) grade_submission(w http.ResponseWriter, r *http.Request) {
+	// GET shows a submission's essays with their rubrics; POST scores one (essay=index, points=one per rubric row).
+	// Scoring the last essay finalizes the score and records it for the student.
+	session, err := store.Get(r, "login")
+	if err != nil {
+		http.Error(w, "failed to retrieve session", 500)
+		flog("grade_submission: failed to retrieve session")
+	} else {
+		role, _ := session.Values["role"].(string)
+		if !can_grade(role) {
+			http.Error(w, "failed to verify grading privileges.  are you logged in?", 500)
+		} else {
+			submission, err := s.db.RetrieveSubmission(mux.Vars(r)["id"])
+			if err != nil {
+				http.Error(w, "failed to retrieve submission", db_status(err))
+				flog("grade_submission: failed to retrieve submission")
+				log.Println(err)
+			} else if r.Method != "POST" {
+				show_submission(w, submission_page{Submission: submission})
+			} else if err = r.ParseForm(); err != nil {
+				http.Error(w, "failed to parse form", 500)
+				flog("grade_submission: failed to parse form")
+			} else {
+				scores := struct {
+					Essay  int   `schema:"essay"`
+					Points []int `schema:"points"`
+				}{}
+				err = rows_decoder.Decode(&scores, r.PostForm)
+				grader, _ := session.Values["username"].(string)
+				if err != nil {
+					http.Error(w, "points must be whole numbers", 400)
+				} else if err = submission.ScoreEssay(scores.Essay, scores.Points, grader); err != nil {
+					w.WriteHeader(400)
+					show_submission(w, submission_page{Submission: submission, Error: err.Error()})
+				} else if submission, err = s.save_essay(submission, scores.Essay, scores.Points, grader); err == functions.ErrConflict {
+					http.Error(w, "someone else finished grading this submission first.", 409)
+				} else if err != nil {
+					http.Error(w, "failed to save scores", db_status(err))
+					flog("grade_submission: failed to update submission")
+					log.Println(err)
+				} else if !submission.Graded {
+					http.Redirect(w, r, "/grading/"+submission.Id, 302)
+				} else {
+					if submission.Username != "" {
+						err = s.db.FinishAttempt(submission.Id, submission.Score)
+						if err == functions.ErrNotFound {
+							// Submitted before attempts were kept
+							quiz := functions.Quiz{Id: submission.Quiz, Version: submission.Version, Title: submission.Title}
+							_, err = s.db.InsertAttempt(functions.NewAttempt(quiz, functions.Answers{}, submission, submission.Created))
+						}
+						if err != nil {
+							flog("grade_submission: failed to record score")
+							log.Println(err)
+						}
+					}
+					http.Redirect(w, r, "/grading", 302)
+				}
+			}
+		}
+	}
+}
+
+func (s *server) save_essay(submission functions.Submission, essay int, points []int, grader string) (functions.Submission, error) {
+	// Saves submission with one essay just scored.  If another grader saved it first, the score goes on their copy
+	// instead, so neither overwrites the other's essays.  ErrConflict once the submission is finished.
+	for {
+		err := s.db.UpdateSubmission(submission)
+		if err != functions.ErrConflict {
+			return submission, err
+		}
+		submission, err = s.db.RetrieveSubmission(submission.Id)
+		if err == nil && submission.Graded {
+			err = functions.ErrConflict
+		}
+		if err == nil {
+			err = submission.ScoreEssay(essay, points, grader)
+		}
+		if err != nil {
+			return submission, err
+		}
+	}
+}
+
+func show_submission(w http.ResponseWriter, page submission_page) {
+	t, _ := template.ParseFiles("templates/submission.html")
+	err := t.Execute(w, page)
+	if err != nil {
+		flog("grade_submission: failed to execute template")
+		log.Println(err)
+	}
+}
+
+func (s *server) view_score(w http.ResponseWriter, r *http.Request) {
+	// Best, latest and average scores by quiz and by subject, and the results of finished full-length tests.
+	// Counselors and admins can look up any student with ?user=
+	session, err := store.Get(r, "login")
+	if err != nil {
+		http.Error(w, "failed to retrieve session", 500)
+		flog("view_score: failed to retrieve session")
+	} else {
+		username, _ := session.Values["username"].(string)
+		role, _ := session.Values["role"].(string)
+		student := r.URL.Query().Get("user")
+		if student == "" {
+			student = username
+		}
+		if username == "" {
+			http.Error(w, "You are not logged in", 403)
+		} else if student != username && !can_view_scores(role) {
+			http.Error(w, "only counselors can view other students' scores.", 403)
+		} else {
+			_, err := s.db.GetUser(student)
+			if err != nil {
+				http.Error(w, "failed to retrieve user data", db_status(err))
+				flog("view_score: failed to retrieve user data")
+			} else {
+				attempts, err := s.db.RetrieveAttempts(student)
+				tests := []sitting_page{}
+				if err == nil {
+					tests, err = s.finished_tests(student)
+				}
+				if err != nil {
+					http.Error(w, "failed to retrieve attempts", db_status(err))
+					flog("view_score: failed to retrieve attempts")
+					log.Println(err)
+				} else {
+					t, _ := template.ParseFiles("templates/score.html")
+					err = t.Execute(w, struct {
+						Report    functions.ScoreReport
+						Tests     []sitting_page // Finished full-length tests, newest first
+						Counselor bool           // Show the form for looking up students
+						Own       bool
+					}{functions.NewScoreReport(student, attempts), tests, can_view_scores(role), student == username})
+					if err != nil {
+						flog("view_score: failed to execute template")
+						log.Println(err)
+					}
+				}
+			}
+		}
+	}
+}
+
+type tests_page struct { // Data for tests.html
+	Tests    []functions.Test
+	Sittings []functions.Sitting // The student's own, newest first
+	Admin    bool
+}
+
+func (s *server) list_tests(w http.ResponseWriter, r *http.Request) {
+	// GET lists the full-length tests (only published ones for students) and the student's sittings; POST, for admins,
+	// creates a test with the posted title and goes on to it to add sections
+	session, err := store.Get(r, "login")
+	if err != nil {
+		http.Error(w, "failed to retrieve session", 500)
+		flog("list_tests: failed to retrieve session")
+	} else {
+		username, _ := session.Values["username"].(string)
+		role, _ := session.Values["role"].(string)
+		admin := role == "su" || role == "admin"
+		if r.Method == "POST" && !admin {
+			http.Error(w, "failed to verify admin privileges.  are you logged in?", 500)
+		} else if r.Method == "POST" {
+			test := functions.Test{Title: strings.TrimSpace(r.PostFormValue("title")), Author: username}
+			if err = test.Validate(); err != nil {
+				http.Error(w, err.Error(), 400)
+			} else if id, err := s.db.InsertTest(test); err != nil {
+				http.Error(w, "failed to insert test", db_status(err))
+				flog("list_tests: failed to insert test")
+				log.Println(err)
+			} else {
+				http.Redirect(w, r, "/test/"+id, 302)
+			}
+		} else {
+			page := tests_page{Tests: []functions.Test{}, Sittings: []functions.Sitting{}, Admin: admin}
+			tests, err := s.db.RetrieveTests()
+			if err == nil && username != "" {
+				page.Sittings, err = s.db.RetrieveSittings(username)
+			}
+			if err != nil {
+				http.Error(w, "failed to retrieve tests", db_status(err))
+				flog("list_tests: failed to retrieve tests")
+				log.Println(err)
+			} else {
+				for _, test := range tests {
+					if test.Published || admin {
+						page.Tests = append(page.Tests, test)
+					}
+				}
+				t, _ := template.ParseFiles("templates/tests.html")
+				err = t.Execute(w, page)
+				if err != nil {
+					flog("list_tests: failed to execute template")
+					log.Println(err)
+				}
+			}
+		}
+	}
+}
+
+type test_page struct { // Data for test.html
+	Test     functions.Test
+	Admin    bool
+	Rows     []functions.TestSection // The sections, then blank rows to add more, for admins
+	Quizzes  []functions.Quiz        // To choose each section's quiz from
+	Sitting  string                  // The student's unfinished sitting of this test, if any
+	Error    string                  // Why the admin's changes weren't saved
+	Conflict bool                    // The test changed while the admin was editing it
+}
+
+func (page test_page) QuizTitle(id functions.QuizID) string {
+	for _, quiz := range page.Quizzes {
+		if quiz.Id == id {
+			return quiz.Title
+		}
+	}
+	return id.String()
+}
+
+func (s *server) all_quizzes() ([]functions.Quiz, error) {
+	// Every quiz, published or not, by title, a listing page at a time
+	quizzes := []functions.Quiz{}
+	query := functions.QuizQuery{Page: 1, PerPage: functions.MaxPerPage}
+	for {
+		page, err := s.db.RetrieveQuizzes(query)
+		if err != nil {
+			return nil, err
+		}
+		quizzes = append(quizzes, page.Quizzes...)
+		if !page.HasNext() || len(page.Quizzes) == 0 {
+			return quizzes, nil
+		}
+		query.Page++
+	}
+}
+
+func (s *server) view_test(w http.ResponseWriter, r *http.Request) {
+	// GET shows the test's sections: to students with a button to start, to admins as a form to change them.
+	// POST saves the admin's form (see functions.TestForm).
+	session, err := store.Get(r, "login")
+	if err != nil {
+		http.Error(w, "failed to retrieve session", 500)
+		flog("view_test: failed to retrieve session")
+	} else {
+		username, _ := session.Values["username"].(string)
+		role, _ := session.Values["role"].(string)
+		page := test_page{Admin: role == "su" || role == "admin"}
+		page.Test, err = s.db.RetrieveTest(mux.Vars(r)["id"])
+		if err == nil {
+			// Every quiz is offered, since sections needn't be published quizzes of their own
+			page.Quizzes, err = s.all_quizzes()
+		}
+		if err != nil {
+			http.Error(w, "failed to retrieve test", db_status(err))
+			flog("view_test: failed to retrieve test")
+			log.Println(err)
+		} else if !page.Admin && !page.Test.Published {
+			http.Error(w, "failed to retrieve test", 404)
+		} else if r.Method == "POST" && !page.Admin {
+			http.Error(w, "failed to verify admin privileges.  are you logged in?", 500)
+		} else if r.Method == "POST" {
+			form := functions.TestForm{}
+			err = r.ParseForm()
+			if err == nil {
+				err = rows_decoder.Decode(&form, r.PostForm)
+			}
+			if err != nil {
+				http.Error(w, "failed to read form", 400)
+				flog("view_test: failed to read form")
+				log.Println(err)
+			} else if err = form.Apply(&page.Test); err != nil {
+				page.Error = err.Error()
+				w.WriteHeader(400)
+				show_test(w, page)
+			} else {
+				page.Test.Version = form.Version
+				err = s.db.UpdateTest(page.Test)
+				if err == functions.ErrConflict {
+					http.Error(w, "this test was changed by someone else while you were editing it.  go back to the test to see the changes, then try again.", 409)
+				} else if err != nil {
+					http.Error(w, "failed to update test", db_status(err))
+					flog("view_test: failed to update test")
+					log.Println(err)
+				} else {
+					http.Redirect(w, r, "/test/"+page.Test.Id, 302)
+				}
+			}
+		} else {
+			sittings := []functions.Sitting{}
+			if username != "" {
+				sittings, err = s.db.RetrieveSittings(username)
+			}
+			for _, sitting := range sittings {
+				if sitting.Test == page.Test.Id && !sitting.Done() && page.Sitting == "" {
+					page.Sitting = sitting.Id
+				}
+			}
+			if err != nil {
+				http.Error(w, "failed to retrieve sittings", db_status(err))
+				flog("view_test: failed to retrieve sittings")
+				log.Println(err)
+			} else {
+				show_test(w, page)
+			}
+		}
+	}
+}
+
+func show_test(w http.ResponseWriter, page test_page) {
+	page.Rows = append([]functions.TestSection{}, page.Test.Sections...)
+	for len(page.Rows) < functions.MaxSections && len(page.Rows) < len(page.Test.Sections)+3 {
+		page.Rows = append(page.Rows, functions.TestSection{})
+	}
+	t, _ := template.ParseFiles("templates/test.html")
+	err := t.Execute(w, page)
+	if err != nil {
+		flog("show_test: failed to execute template")
+		log.Println(err)
+	}
+}
+
+type scale_page struct { // Data for scale.html
+	Test     functions.Test
+	Scale    functions.Scale        // The version shown in the form
+	Latest   int                    // The version a save follows on from
+	Rows     []functions.Conversion // The tables, then blank rows to add more
+	Versions []functions.Scale      // Every saved version, oldest first
+	Kinds    []string
+	Error    string // Why the admin's tables weren't saved
+}
+
+func (page scale_page) Join(numbers []int) string {
+	words := []string{}
+	for _, n := range numbers {
+		words = append(words, strconv.Itoa(n))
+	}
+	return strings.Join(words, " ")
+}
+
+func (s *server) edit_scale(w http.ResponseWriter, r *http.Request) {
+	// The test's raw-to-scaled conversion tables, for admins.  GET shows the latest version, or the one in ?version=
+	// so an earlier one can be saved again; POST saves the form as a new version.
+	session, err := store.Get(r, "login")
+	if err != nil {
+		http.Error(w, "failed to retrieve session", 500)
+		flog("edit_scale: failed to retrieve session")
+	} else {
+		username, _ := session.Values["username"].(string)
+		role, _ := session.Values["role"].(string)
+		page := scale_page{Kinds: []string{functions.SectionScore, functions.Subscore, functions.CrossTestScore}}
+		page.Test, err = s.db.RetrieveTest(mux.Vars(r)["id"])
+		if err == nil {
+			page.Versions, err = s.db.RetrieveScales(page.Test.Id)
+		}
+		if role != "su" && role != "admin" {
+			http.Error(w, "failed to verify admin privileges.  are you logged in?", 500)
+		} else if err != nil {
+			http.Error(w, "failed to retrieve test", db_status(err))
+			flog("edit_scale: failed to retrieve test")
+			log.Println(err)
+		} else if r.Method == "POST" {
+			form := functions.ScaleForm{}
+			err = r.ParseForm()
+			if err == nil {
+				err = rows_decoder.Decode(&form, r.PostForm)
+			}
+			page.Scale = functions.Scale{Test: page.Test.Id, Author: username}
+			page.Latest = form.Version
+			if err != nil {
+				http.Error(w, "failed to read form", 400)
+				flog("edit_scale: failed to read form")
+				log.Println(err)
+			} else if err = form.Apply(&page.Scale, len(page.Test.Sections)); err != nil {
+				page.Error = err.Error()
+				w.WriteHeader(400)
+				show_scale(w, page)
+			} else {
+				err = s.db.InsertScale(page.Scale)
+				if err == functions.ErrConflict {
+					http.Error(w, "these tables were changed by someone else while you were editing them.  go back to see the changes, then try again.", 409)
+				} else if err != nil {
+					http.Error(w, "failed to save tables", db_status(err))
+					flog("edit_scale: failed to insert scale")
+					log.Println(err)
+				} else {
+					http.Redirect(w, r, "/scale/"+page.Test.Id, 302)
+				}
+			}
+		} else {
+			page.Latest = len(page.Versions)
+			version, err := strconv.Atoi(r.URL.Query().Get("version"))
+			if err != nil || version < 1 || version > len(page.Versions) {
+				version = len(page.Versions)
+			}
+			if version > 0 {
+				page.Scale = page.Versions[version-1]
+			}
+			show_scale(w, page)
+		}
+	}
+}
+
+func show_scale(w http.ResponseWriter, page scale_page) {
+	page.Rows = append([]functions.Conversion{}, page.Scale.Tables...)
+	for len(page.Rows) < functions.MaxTables && len(page.Rows) < len(page.Scale.Tables)+3 {
+		page.Rows = append(page.Rows, functions.Conversion{})
+	}
+	t, _ := template.ParseFiles("templates/scale.html")
+	err := t.Execute(w, page)
+	if err != nil {
+		flog("show_scale: failed to execute template")
+		log.Println(err)
+	}
+}
+
+func (s *server) start_test(w http.ResponseWriter, r *http.Request) {
+	// Starts a sitting of the test for the logged-in student, or goes back to the one they haven't finished
+	session, err := store.Get(r, "login")
+	if err != nil {
+		http.Error(w, "failed to retrieve session", 500)
+		flog("start_test: failed to retrieve session")
+	} else if r.Method != "POST" {
+		http.Error(w, "tests are started from their page", 405)
+	} else {
+		username, _ := session.Values["username"].(string)
+		role, _ := session.Values["role"].(string)
+		test, err := s.db.RetrieveTest(mux.Vars(r)["id"])
+		sittings := []functions.Sitting{}
+		if err == nil && username != "" {
+			sittings, err = s.db.RetrieveSittings(username)
+		}
+		if username == "" {
+			http.Error(w, "You are not logged in", 403)
+		} else if err != nil {
+			http.Error(w, "failed to retrieve test", db_status(err))
+			flog("start_test: failed to retrieve test")
+			log.Println(err)
+		} else if !test.Published && role != "su" && role != "admin" {
+			http.Error(w, "failed to retrieve test", 404)
+		} else if len(test.Sections) == 0 {
+			http.Error(w, "this test has no sections yet.", 400)
+		} else {
+			id := ""
+			for _, sitting := range sittings {
+				if sitting.Test == test.Id && !sitting.Done() && id == "" {
+					id = sitting.Id
+				}
+			}
+			if id == "" {
+				// Scored with the conversion tables as they are now, whatever becomes of them later
+				sitting := functions.StartSitting(test, username, time.Now())
+				var scale functions.Scale
+				scale, err = s.db.RetrieveScale(test.Id, 0)
+				if err == nil {
+					sitting.Scale = scale.Version
+				}
+				if err == nil || err == functions.ErrNotFound {
+					id, err = s.db.InsertSitting(sitting)
+				}
+			}
+			if err != nil {
+				http.Error(w, "failed to start the test", db_status(err))
+				flog("start_test: failed to insert sitting")
+				log.Println(err)
+			} else {
+				http.Redirect(w, r, "/sitting/"+id, 303)
+			}
+		}
+	}
+}
+
+type sitting_page struct { // Data for sitting.html
+	Sitting functions.Sitting
+	Own     bool                 // Whether the logged-in user is the one taking it
+	Result  functions.TestResult // Once every section is handed in
+}
+
+func (s *server) view_sitting(w http.ResponseWriter, r *http.Request) {
+	// Where a sitting stands: which sections are handed in and which is next, then the combined result once all are.
+	// Counselors and admins can see any student's.
+	session, err := store.Get(r, "login")
+	if err != nil {
+		http.Error(w, "failed to retrieve session", 500)
+		flog("view_sitting: failed to retrieve session")
+	} else {
+		username, _ := session.Values["username"].(string)
+		role, _ := session.Values["role"].(string)
+		sitting, err := s.db.RetrieveSitting(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "failed to retrieve sitting", db_status(err))
+			flog("view_sitting: failed to retrieve sitting")
+			log.Println(err)
+		} else if username == "" || (sitting.Username != username && !can_view_scores(role)) {
+			http.Error(w, "failed to retrieve sitting", 404)
+		} else {
+			page := sitting_page{Own: sitting.Username == username}
+			page.Sitting, err = s.catch_up(sitting)
+			if err == nil && page.Sitting.Done() {
+				page.Result, err = s.test_result(page.Sitting)
+			}
+			if err != nil {
+				http.Error(w, "failed to retrieve sections", db_status(err))
+				flog("view_sitting: failed to retrieve sections")
+				log.Println(err)
+			} else {
+				t, _ := template.ParseFiles("templates/sitting.html")
+				err = t.Execute(w, page)
+				if err != nil {
+					flog("view_sitting: failed to execute template")
+					log.Println(err)
+				}
+			}
+		}
+	}
+}
+
+func (s *server) take_section(w http.ResponseWriter, r *http.Request) {
+	// The sitting's current section, started the first time it is opened.  Earlier sections are handed in and later
+	// ones wait their turn, so this is the only way into a test's sections.
+	session, err := store.Get(r, "login")
+	if err != nil {
+		http.Error(w, "failed to retrieve session", 500)
+		flog("take_section: failed to retrieve session")
+	} else {
+		username, _ := session.Values["username"].(string)
+		sitting, err := s.db.RetrieveSitting(mux.Vars(r)["id"])
+		if err == nil && username != "" && sitting.Username == username {
+			sitting, err = s.catch_up(sitting)
+		}
+		if err != nil {
+			http.Error(w, "failed to retrieve sitting", db_status(err))
+			flog("take_section: failed to retrieve sitting")
+			log.Println(err)
+		} else if username == "" || sitting.Username != username {
+			http.Error(w, "failed to retrieve sitting", 404)
+		} else if sitting.Done() {
+			http.Redirect(w, r, "/sitting/"+sitting.Id, 302)
+		} else if attempt, quiz, err := s.start_section(sitting); err != nil {
+			http.Error(w, "failed to start the section", db_status(err))
+			flog("take_section: failed to start section")
+			log.Println(err)
+		} else if passages, err := functions.QuizPassages(s.db, quiz); err != nil {
+			http.Error(w, "failed to retrieve passages", db_status(err))
+			flog("take_section: failed to retrieve passages")
+			log.Println(err)
+		} else {
+			section := sitting.Sections[sitting.Current]
+			page := quiz.GetTmplQuiz(passages, attempt.Responses)
+			page.Attempt = attempt.Id
+			page.Resumed = len(attempt.Responses) > 0
+			page.Timed = attempt.Timed()
+			page.Remaining = int(attempt.Remaining(time.Now()).Seconds())
+			page.Section = fmt.Sprintf("%s, section %d of %d: %s", sitting.Title, sitting.Current+1, len(sitting.Sections), section.Name)
+			t, _ := template.ParseFiles("templates/quiz.html")
+			err = t.Execute(w, page)
+			if err != nil {
+				flog("take_section: failed to execute template")
+				log.Println(err)
+			}
+		}
+	}
+}
+
+func (s *server) start_section(sitting functions.Sitting) (functions.Attempt, functions.Quiz, error) {
+	// The attempt at the sitting's current section and the stored quiz it is taken against, started if it hasn't been
+	if id := sitting.CurrentAttempt(); id != "" {
+		attempt, err := s.db.RetrieveAttempt(id)
+		if err != nil {
+			return attempt, functions.Quiz{}, err
+		}
+		quiz, err := functions.Quiz{Id: attempt.Quiz, Version: attempt.Version}.GradedAgainst(s.db)
+		return attempt, quiz, err
+	}
+	section := sitting.Sections[sitting.Current]
+	quiz, err := s.db.RetrieveQuiz(section.Quiz)
+	if err != nil {
+		return functions.Attempt{}, quiz, err
+	}
+	attempt := functions.StartAttempt(section.Timing(quiz), sitting.Username, time.Now())
+	attempt.Sitting = sitting.Id
+	attempt.Id, err = s.db.InsertAttempt(attempt)
+	if err == nil {
+		err = s.db.BeginSection(sitting, attempt.Id)
+	}
+	if err == functions.ErrConflict {
+		// Another request started the section first, so its attempt is the one taken and ours is left unfinished
+		var stored functions.Sitting
+		stored, err = s.db.RetrieveSitting(sitting.Id)
+		if err == nil && stored.Current == sitting.Current && stored.CurrentAttempt() != "" {
+			return s.start_section(stored)
+		} else if err == nil {
+			err = functions.ErrConflict
+		}
+	}
+	return attempt, quiz, err
+}
+
+func (s *server) catch_up(sitting functions.Sitting) (functions.Sitting, error) {
+	// Moves the sitting past its current section once that is handed in.  A section whose time ran out with the page
+	// closed is handed in here, graded on its saved answers.  A section whose quiz has been deleted can't be taken, so
+	// it is skipped, counting as no questions (see test_result).
+	if sitting.Done() {
+		return sitting, nil
+	}
+	id := sitting.CurrentAttempt()
+	if id == "" {
+		_, err := s.db.RetrieveQuiz(sitting.Sections[sitting.Current].Quiz)
+		if err == functions.ErrNotFound {
+			err = s.db.BeginSection(sitting, "")
+		} else {
+			return sitting, err
+		}
+		if err == functions.ErrConflict {
+			// Another request started or skipped it first
+			sitting, err = s.db.RetrieveSitting(sitting.Id)
+			if err != nil {
+				return sitting, err
+			}
+			return s.catch_up(sitting)
+		} else if err != nil {
+			return sitting, err
+		}
+		sitting = sitting.Begin("")
+	} else {
+		attempt, err := s.db.RetrieveAttempt(id)
+		if err != nil {
+			return sitting, err
+		} else if attempt.InProgress() {
+			quiz, err := functions.Quiz{Id: attempt.Quiz, Version: attempt.Version}.GradedAgainst(s.db)
+			if err == nil && attempt.Expired(time.Now()) {
+				attempt, err = s.finish_attempt(quiz, attempt.Responses, attempt)
+			}
+			if err == functions.ErrNotFound {
+				// Nothing left to grade it against; it stays unfinished
+			} else if err != nil || attempt.InProgress() {
+				return sitting, err
+			}
+		}
+	}
+	sitting, err := s.advance(sitting)
+	if err != nil {
+		return sitting, err
+	}
+	return s.catch_up(sitting)
+}
+
+func (s *server) advance(sitting functions.Sitting) (functions.Sitting, error) {
+	// Saves the sitting moved on from its current section, unless another request moved it on first
+	current := sitting.Current
+	sitting = sitting.Advance(time.Now())
+	if sitting.Done() && sitting.Scale == 0 {
+		// The test had no conversion tables when the sitting started, so it keeps the ones it is first scored with
+		scale, err := s.db.RetrieveScale(sitting.Test, 0)
+		if err == nil {
+			sitting.Scale = scale.Version
+		} else if err != functions.ErrNotFound {
+			return sitting, err
+		}
+	}
+	err := s.db.UpdateSitting(sitting, current)
+	if err == functions.ErrConflict {
+		return s.db.RetrieveSitting(sitting.Id)
+	}
+	return sitting, err
+}
+
+func (s *server) finished_tests(username string) ([]sitting_page, error) {
+	// The user's sittings with every section handed in, and their results
+	sittings, err := s.db.RetrieveSittings(username)
+	if err != nil {
+		return nil, err
+	}
+	result := []sitting_page{}
+	for _, sitting := range sittings {
+		if sitting.Done() {
+			page := sitting_page{Sitting: sitting}
+			page.Result, err = s.test_result(sitting)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, page)
+		}
+	}
+	return result, nil
+}
+
+func (s *server) test_result(sitting functions.Sitting) (functions.TestResult, error) {
+	// Each section's attempt and how many questions it had, for the combined result, and scaled scores if the test
+	// has conversion tables
+	sections := []functions.SectionResult{}
+	for i := 0; i < len(sitting.Sections); i++ {
+		section := functions.SectionResult{Number: i + 1, Section: sitting.Sections[i]}
+		if i < len(sitting.Attempts) && sitting.Attempts[i] != "" {
+			// A section skipped because its quiz was deleted has no attempt; one whose quiz went with nothing to
+			// grade it against counts the same, as a section with no questions
+			attempt, err := s.db.RetrieveAttempt(sitting.Attempts[i])
+			if err != nil {
+				return functions.TestResult{}, err
+			}
+			quiz, err := functions.Quiz{Id: attempt.Quiz, Version: attempt.Version}.GradedAgainst(s.db)
+			if err == nil {
+				section.Attempt = attempt
+				section.Questions = len(quiz.Questions)
+				section.Points = attempt.ScoredAgainst(quiz).Possible()
+			} else if err != functions.ErrNotFound {
+				return functions.TestResult{}, err
+			}
+		}
+		sections = append(sections, section)
+	}
+	result := functions.NewTestResult(sections)
+	scale, err := s.db.RetrieveScale(sitting.Test, sitting.Scale)
+	if err == nil {
+		result.Scaled = scale.Score(result)
+	} else if err != functions.ErrNotFound {
+		return result, err
+	}
+	return result, nil
+}
+
+func (s *server) get_all_quizzes(w http.ResponseWriter, r *http.Request) {
+	// Students only ever see published quizzes
+	query := functions.QuizQuery{}
+	err := query_decoder.Decode(&query, r.URL.Query())
+	if err != nil {
+		http.Error(w, "invalid query parameters", 400)
+	} else {
+		query.Published = "yes"
+		page, err := s.db.RetrieveQuizzes(query)
+		if err != nil {
+			http.Error(w, "failed to retrieve quizzes", db_status(err))
+			flog("get_all_quizzes: failed to retrieve quizzes")
+		} else {
+			t, _ := template.ParseFiles("templates/all_quizzes.html")
+			err = t.Execute(w, page)
+			if err != nil {
+				http.Error(w, "failed to execute template", 500)
+				flog("get_all_quizzes: failed to execute template")
+			}
+		}
+	}
+}
+
+type search_page struct { // Data for search.html
+	Query   functions.SearchQuery
+	Results []functions.SearchResult
+	Admin   bool // Admins see unpublished quizzes and get links to edit them
+}
+
+func (s *server) search_quizzes(w http.ResponseWriter, r *http.Request) {
+	// Searches quiz titles, questions and answers.  With no query, just shows the search form.
+	session, err := store.Get(r, "login")
+	if err != nil {
+		http.Error(w, "failed to retrieve session", 500)
+		flog("search_quizzes: failed to retrieve session")
+	} else {
+		page := search_page{}
+		err = query_decoder.Decode(&page.Query, r.URL.Query())
+		if err != nil {
+			http.Error(w, "invalid query parameters", 400)
+		} else {
+			role, _ := session.Values["role"].(string)
+			page.Admin = role == "su" || role == "admin"
+			page.Query.PublishedOnly = !page.Admin
+			page.Query = page.Query.Normalize()
+			if page.Query.Text != "" {
+				page.Results, err = s.db.SearchQuizzes(page.Query)
+			}
+			if err != nil {
+				http.Error(w, "failed to search quizzes", db_status(err))
+				flog("search_quizzes: failed to search quizzes")
+				log.Println(err)
+			} else {
+				t, _ := template.ParseFiles("templates/search.html")
+				err = t.Execute(w, page)
+				if err != nil {
+					http.Error(w, "failed to execute template", 500)
+					flog("search_quizzes: failed to execute template")
+				}
+			}
+		}
+	}
+}
+
+func (s *server) publish_quiz(w http.ResponseWriter, r *http.Request) {
+	// Publishes (published=yes) or hides (published=no) a quiz, then goes back to the admin panel
+	session, err := store.Get(r, "login")
+	if err != nil {
+		http.Error(w, "failed to retrieve session", 500)
+		flog("publish_quiz: failed to retrieve session")
+	} else {
+		role, ok := session.Values["role"].(string)
+		if !ok || (role != "su" && role != "admin") {
+			http.Error(w, "failed to verify admin privileges.  are you logged in?", 500)
+		} else {
+			id, err := functions.ParseQuizID(mux.Vars(r)["id"])
+			if err != nil {
+				http.Error(w, "quiz not found", 404)
+			} else {
+				quiz, err := s.db.RetrieveQuiz(id)
+				if err != nil {
+					http.Error(w, "failed to retrieve quiz", db_status(err))
+					flog("publish_quiz: failed to retrieve quiz")
+				} else {
+					quiz.Published = r.PostFormValue("published") == "yes"
+					quiz.Version, err = strconv.Atoi(r.PostFormValue("version")) // The version the admin saw, so a stale panel can't undo someone else's change
+					if err != nil {
+						http.Error(w, "missing quiz version", 400)
+					} else if err = s.db.UpdateQuiz(quiz); err == functions.ErrConflict {
+						http.Error(w, "this quiz was changed by someone else while you were looking at it.  reload the admin panel to see the changes, then try again.", 409)
+					} else if err != nil {
+						http.Error(w, "failed to update quiz", db_status(err))
+						flog("publish_quiz: failed to update quiz")
+					} else {
+						http.Redirect(w, r, "/admin", 302)
+					}
+				}
+			}
+		}
+	}
+}
+
+type revisions_page struct { // Data for revisions.html
+	Quiz      functions.Quiz
+	Revisions []functions.Revision // Newest first
+	From      functions.Revision   // The two revisions being compared, if Diff is set
+	To        functions.Revision
+	Changes   []functions.RevisionChange
+	Diff      bool
+}
+
+type revision_query struct { // Query string for /revisions/{id}: the versions to compare
+	From int `schema:"from"`
+	To   int `schema:"to"`
+}
+
+func (s *server) quiz_revisions(w http.ResponseWriter, r *http.Request) {
+	// Lists every version of a quiz, and with ?from=N&to=M shows what changed between two of them
+	session, err := store.Get(r, "login")
+	if err != nil {
+		http.Error(w, "failed to retrieve session", 500)
+		flog("quiz_revisions: failed to retrieve session")
+	} else {
+		role, ok := session.Values["role"].(string)
+		if !ok || (role != "su" && role != "admin") {
+			http.Error(w, "failed to verify admin privileges.  are you logged in?", 500)
+		} else {
+			id, err := functions.ParseQuizID(mux.Vars(r)["id"])
+			query := revision_query{}
+			if err != nil {
+				http.Error(w, "quiz not found", 404)
+			} else if err = query_decoder.Decode(&query, r.URL.Query()); err != nil {
+				http.Error(w, "invalid query parameters", 400)
+			} else {
+				page := revisions_page{}
+				page.Quiz, err = s.db.RetrieveQuiz(id)
+				if err == nil {
+					page.Revisions, err = s.db.RetrieveRevisions(id)
+				}
+				if err == nil && query.From > 0 && query.To > 0 {
+					page.Diff = true
+					page.From, err = s.db.RetrieveRevision(id, query.From)
+					if err == nil {
+						page.To, err = s.db.RetrieveRevision(id, query.To)
+					}
+					page.Changes = functions.DiffRevisions(page.From, page.To)
+				}
+				if err != nil {
+					http.Error(w, "failed to retrieve revisions", db_status(err))
+					flog("quiz_revisions: failed to retrieve revisions")
+					log.Println(err)
+				} else {
+					for i, j := 0, len(page.Revisions)-1; i < j; i, j = i+1, j-1 {
+						page.Revisions[i], page.Revisions[j] = page.Revisions[j], page.Revisions[i]
+					}
+					t, _ := template.ParseFiles("templates/revisions.html")
+					err = t.Execute(w, page)
+					if err != nil {
+						http.Error(w, "failed to execute template", 500)
+						flog("quiz_revisions: failed to execute template")
+					}
+				}
+			}
+		}
+	}
+}
+
+func (s *server) rollback_quiz(w http.ResponseWriter, r *http.Request) {
+	// Saves an old revision's title, subject, difficulty and questions as a new version.  History is kept; nothing is deleted.
+	session, err := store.Get(r, "login")
+	if err != nil {
+		http.Error(w, "failed to retrieve session", 500)
+		flog("rollback_quiz: failed to retrieve session")
+	} else {
+		role, ok := session.Values["role"].(string)
+		if !ok || (role != "su" && role != "admin") {
+			http.Error(w, "failed to verify admin privileges.  are you logged in?", 500)
+		} else {
+			id, err := functions.ParseQuizID(mux.Vars(r)["id"])
+			if err != nil {
+				http.Error(w, "quiz not found", 404)
+			} else {
+				to, to_err := strconv.Atoi(r.PostFormValue("to"))
+				version, err := strconv.Atoi(r.PostFormValue("version"))
+				if err != nil || to_err != nil {
+					http.Error(w, "missing revision or quiz version", 400)
+				} else {
+					revision, err := s.db.RetrieveRevision(id, to)
+					quiz := functions.Quiz{}
+					if err == nil {
+						quiz, err = s.db.RetrieveQuiz(id)
+					}
+					if err != nil {
+						http.Error(w, "failed to retrieve revision", db_status(err))
+						flog("rollback_quiz: failed to retrieve revision")
+						log.Println(err)
+					} else {
+						quiz = revision.Restore(quiz)
+						quiz.Version = version
+						err = s.db.UpdateQuiz(quiz)
+						if err == functions.ErrConflict {
+							http.Error(w, "this quiz was changed by someone else while you were looking at it.  reload its history to see the changes, then try again.", 409)
+						} else if err != nil {
+							http.Error(w, "failed to update quiz", db_status(err))
+							flog("rollback_quiz: failed to update quiz")
+							log.Println(err)
+						} else {
+							http.Redirect(w, r, "/revisions/"+id.String(), 302)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func (s *server) test_section(id functions.QuizID) (bool, error) {
+	// Whether the quiz is a section of a test, which students only take through a sitting (see take_section)
+	tests, err := s.db.RetrieveTests()
+	if err != nil {
+		return false, err
+	}
+	for _, test := range tests {
+		if test.Includes(id) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *server) display_quiz(w http.ResponseWriter, r *http.Request) {
+	q_id, err := functions.ParseQuizID(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "error: page not found--no quiz with that id", 404)
+	} else {
+		username, role := "", ""
+		if session, err := store.Get(r, "login"); err == nil {
+			username, _ = session.Values["username"].(string)
+			role, _ = session.Values["role"].(string)
+		}
+		admin := role == "su" || role == "admin"
+		quiz, err := s.db.RetrieveQuiz(q_id)
+		section := false
+		if err == nil {
+			section, err = s.test_section(q_id)
+		}
+		if err != nil {
+			http.Error(w, "failed to retrieve quiz", db_status(err))
+			log.Println(err)
+			flog("display_quiz: failed to retrieve quiz")
+		} else if !quiz.Published && !admin {
+			http.Error(w, "error: page not found--no quiz with that id", 404)
+		} else if section && !admin {
+			http.Error(w, "this quiz is a section of a full-length test.  start the test to take it.", 403)
+		} else {
+			t, err := template.ParseFiles("templates/quiz.html")
+			if err != nil {
+				log.Println(err)
+			} else if quiz.Timed() && username == "" {
+				http.Error(w, "this quiz is timed.  log in to take it.", 403)
+			} else if attempt, shown, err := s.start_attempt(username, quiz); err != nil {
+				http.Error(w, "failed to start the quiz", db_status(err))
+				flog("display_quiz: failed to start attempt")
+				log.Println(err)
+			} else if passages, err := functions.QuizPassages(s.db, shown); err != nil {
+				http.Error(w, "failed to retrieve passages", db_status(err))
+				flog("display_quiz: failed to retrieve passages")
+				log.Println(err)
+			} else {
+				page := shown.GetTmplQuiz(passages, attempt.Responses)
+				page.Attempt = attempt.Id
+				page.Resumed = len(attempt.Responses) > 0
+				page.Timed = attempt.Timed()
+				page.Remaining = int(attempt.Remaining(time.Now()).Seconds())
+				err = t.Execute(w, page)
+				if err != nil {
+					http.Error(w, "failed to execute template", 500)
+					flog("display_quiz: failed to execute template")
+					log.Println(err)
+				}
+			}
+		}
+	}
+}
+
+func (s *server) create_account_get(w http.ResponseWriter, r *http.Request) {
+	t, err := template.ParseFiles("templates/acct_created.html")
+	err = t.Execute(w, functions.SuccessLogin{false, "", "", false})
+	if err != nil {
+		http.Error(w, "failed to execute template", 500)
+		flog("create_account_get: failed to execute template")
+	}
+}
+
+func (s *server) create_account_post(w http.ResponseWriter, r *http.Request) {
+	// Creates an account from a post request.  Password is hashed with bcrypt.
+	// If the request originator is logged in as an admin, role is set to the form value.  Otherwise, role is set to user.
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "failed to parse form", 500)
+		flog("create_account_post: failed to parse form")
+	} else {
+		result := new(functions.User)
+		err = decoder.Decode(result, r.PostForm)
+		if err != nil {
+			http.Error(w, "failed to read form", 500)
+			flog("create_account_post: failed to read form")
+		} else {
+			session, err := store.Get(r, "login")
+			if err != nil {
+				http.Error(w, "internal server error", 500)
+			} else {
+				if role, ok := session.Values["role"]; !ok || role.(string) != "admin" {
+					// Not logged in as admin: sets role to user
+					result.Role = "user"
+				}
+				t, _ := template.ParseFiles("templates/acct_created.html")
+				err = s.db.CreateAccount(*result)
+				if err == functions.ErrUserExists {
+					err = t.Execute(w, functions.SuccessLogin{false, result.Username, "", true})
+					if err != nil {
+						http.Error(w, "failed to execute template", 500)
+						flog("create_account_post: failed to execute template 1")
+					}
+				} else if err != nil {
+					http.Error(w, "internal server error", db_status(err))
+					flog("create_account_post: create account failed")
+					log.Println(err)
+				} else {
+					err = t.Execute(w, functions.SuccessLogin{true, result.Username, result.Role, true})
+					if err != nil {
+						http.Error(w, "failed to execute template", 500)
+						flog("create_account_post: failed to execute template 2")
+					}
+				}
+			}
+		}
+	}
+}
+
+func (s *server) post_login(w http.ResponseWriter, r *http.Request) {
+	// Handles login requests.  Currently set up for plaintext passwords.
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "failed to parse form", 500)
+		flog("post_login: failed to parse form")
+	} else {
+		result := new(functions.User)
+		err = decoder.Decode(result, r.PostForm)
+		if err != nil {
+			http.Error(w, "failed to read form", 500)
+			flog("post_login: failed to read form")
+		} else {
+			account, err := s.db.CheckLogin(*result)
+			if err == functions.ErrLoginFailed {
+				time.Sleep(3 * time.Second)
+				fmt.Fprintf(w, "invalid username or password")
+			} else if err != nil {
+				http.Error(w, "internal server error", db_status(err))
 				flog("post_login: login failure")
 			} else {
 				session, err := store.Get(r, "login")
@@ -465,7 +2412,7 @@ func post_login(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func get_login(w http.ResponseWriter, r *http.Request) {
+func (s *server) get_login(w http.ResponseWriter, r *http.Request) {
 	// Retrieves login session
 	session, err := store.Get(r, "login")
 	if err != nil {
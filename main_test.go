@@ -0,0 +1,496 @@
+package main
+
+// Handler tests.  Each test gets its own server on an in-memory store, so they need no database or other process.
+
+import (
+	"functions"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func new_test_server(t *testing.T) (*server, *httptest.Server) {
+	// A server with an admin / admin account, like -store=memory
+	s := &server{db: functions.NewMemoryStore()}
+	err := s.db.CreateAccount(functions.User{Username: "admin", Password: "admin", Role: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s.router())
+	t.Cleanup(ts.Close)
+	return s, ts
+}
+
+type test_client struct { // A browser with its own cookies, which doesn't follow redirects
+	t    *testing.T
+	base string
+	http *http.Client
+}
+
+func new_client(t *testing.T, ts *httptest.Server) *test_client {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Jar: jar, CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	return &test_client{t: t, base: ts.URL, http: client}
+}
+
+func (c *test_client) do(method string, path string, form url.Values) (int, string) {
+	req, err := http.NewRequest(method, c.base+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func (c *test_client) http_url() *url.URL {
+	base, err := url.Parse(c.base)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return base
+}
+
+func (c *test_client) get(path string) (int, string) {
+	return c.do("GET", path, nil)
+}
+
+func (c *test_client) post(path string, form url.Values) (int, string) {
+	return c.do("POST", path, form)
+}
+
+func login(t *testing.T, ts *httptest.Server, username string, password string) *test_client {
+	c := new_client(t, ts)
+	status, _ := c.post("/login_post", url.Values{"username": {username}, "password": {password}})
+	if status != 302 {
+		t.Fatalf("logging in as %s: status %d", username, status)
+	}
+	return c
+}
+
+func register(t *testing.T, ts *httptest.Server, username string, password string) *test_client {
+	c := new_client(t, ts)
+	status, body := c.post("/create_acct", url.Values{"username": {username}, "password": {password}})
+	if status != 200 || !strings.Contains(body, "Successfully created account") {
+		t.Fatalf("registering %s: status %d: %s", username, status, body)
+	}
+	return login(t, ts, username, password)
+}
+
+func create_quiz(t *testing.T, s *server, admin *test_client, title string) functions.Quiz {
+	// create_quiz doesn't say the new quiz's ID, so it is found by title
+	status, body := admin.post("/create_quiz", url.Values{"title": {title}, "subject": {"math"}, "difficulty": {"easy"}})
+	if status != 200 {
+		t.Fatalf("creating quiz: status %d: %s", status, body)
+	}
+	page, err := s.db.RetrieveQuizzes(functions.QuizQuery{PerPage: functions.MaxPerPage})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, quiz := range page.Quizzes {
+		if quiz.Title == title {
+			return quiz
+		}
+	}
+	t.Fatalf("quiz %q wasn't created", title)
+	return functions.Quiz{}
+}
+
+func retrieve_quiz(t *testing.T, s *server, id functions.QuizID) functions.Quiz {
+	quiz, err := s.db.RetrieveQuiz(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return quiz
+}
+
+func published_quiz(t *testing.T, s *server, admin *test_client) functions.Quiz {
+	// A published quiz with one question, 2+2, whose answer is the second choice
+	quiz := create_quiz(t, s, admin, "Arithmetic")
+	status, body := admin.post("/add_question/"+quiz.Id.String(), url.Values{
+		"question": {"2+2"}, "answers": {"3", "4", "5"}, "correct": {"1"}, "version": {"1"},
+	})
+	if status != 302 {
+		t.Fatalf("adding question: status %d: %s", status, body)
+	}
+	status, body = admin.post("/publish/"+quiz.Id.String(), url.Values{"published": {"yes"}, "version": {"2"}})
+	if status != 302 {
+		t.Fatalf("publishing: status %d: %s", status, body)
+	}
+	return retrieve_quiz(t, s, quiz.Id)
+}
+
+func TestRegistrationAndLogin(t *testing.T) {
+	s, ts := new_test_server(t)
+	bob := register(t, ts, "bob", "pw")
+	_, body := bob.get("/login_get")
+	if !strings.Contains(body, "logged in as bob, and your role is user") {
+		t.Errorf("after login: %s", body)
+	}
+
+	// Usernames are taken once
+	_, body = new_client(t, ts).post("/create_acct", url.Values{"username": {"bob"}, "password": {"other"}})
+	if !strings.Contains(body, "already in use") {
+		t.Errorf("registering a taken username: %s", body)
+	}
+
+	// Only admins can give a new account a role
+	new_client(t, ts).post("/create_acct", url.Values{"username": {"mallory"}, "password": {"pw"}, "role": {"admin"}})
+	if user, err := s.db.GetUser("mallory"); err != nil || user.Role != "user" {
+		t.Errorf("self-registered role: %q, %v", user.Role, err)
+	}
+	admin := login(t, ts, "admin", "admin")
+	admin.post("/create_acct", url.Values{"username": {"carol"}, "password": {"pw"}, "role": {"counselor"}})
+	if user, err := s.db.GetUser("carol"); err != nil || user.Role != "counselor" {
+		t.Errorf("admin-created role: %q, %v", user.Role, err)
+	}
+
+	anonymous := new_client(t, ts)
+	status, body := anonymous.post("/login_post", url.Values{"username": {"bob"}, "password": {"wrong"}})
+	if status != 200 || !strings.Contains(body, "invalid username or password") {
+		t.Errorf("wrong password: status %d: %s", status, body)
+	}
+	_, body = anonymous.get("/login_get")
+	if !strings.Contains(body, "not logged in") {
+		t.Errorf("after a failed login: %s", body)
+	}
+}
+
+func TestQuizCRUD(t *testing.T) {
+	s, ts := new_test_server(t)
+	admin := login(t, ts, "admin", "admin")
+	quiz := create_quiz(t, s, admin, "Algebra")
+	id := quiz.Id.String()
+	if quiz.Author != "admin" || quiz.Version != 1 || quiz.Published {
+		t.Errorf("new quiz: %+v", quiz)
+	}
+
+	status, body := admin.post("/add_question/"+id, url.Values{"question": {"x+1=3"}, "answers": {"1", "2"}, "correct": {"1"}, "version": {"1"}})
+	if status != 302 {
+		t.Fatalf("adding question: status %d: %s", status, body)
+	}
+	quiz = retrieve_quiz(t, s, quiz.Id)
+	if len(quiz.Questions) != 1 || quiz.Questions[0].CorrectIndex != 1 {
+		t.Fatalf("after adding a question: %+v", quiz.Questions)
+	}
+	question := quiz.Questions[0].Id
+
+	// Invalid questions and stale versions are refused
+	status, _ = admin.post("/add_question/"+id, url.Values{"question": {"blank"}, "answers": {"1", ""}, "correct": {"0"}, "version": {"2"}})
+	if status != 400 {
+		t.Errorf("adding a question with an empty choice: status %d", status)
+	}
+	status, _ = admin.post("/add_question/"+id, url.Values{"question": {"late"}, "answers": {"1", "2"}, "correct": {"0"}, "version": {"1"}})
+	if status != 409 {
+		t.Errorf("adding a question to a stale version: status %d", status)
+	}
+
+	status, _ = admin.post("/edit_question/"+id+"/"+question, url.Values{"question": {"x+2=3"}, "answers": {"1", "2"}, "correct": {"0"}, "version": {"2"}})
+	if status != 302 {
+		t.Errorf("editing question: status %d", status)
+	}
+	status, _ = admin.post("/rename_quiz/"+id, url.Values{"title": {"Linear equations"}, "version": {"3"}})
+	if status != 302 {
+		t.Errorf("renaming: status %d", status)
+	}
+	quiz = retrieve_quiz(t, s, quiz.Id)
+	if quiz.Title != "Linear equations" || quiz.Questions[0].Id != question || quiz.Questions[0].Question != "x+2=3" || quiz.Version != 4 {
+		t.Errorf("after editing: %+v", quiz)
+	}
+
+	status, _ = admin.post("/publish/"+id, url.Values{"published": {"yes"}, "version": {"4"}})
+	if status != 302 || !retrieve_quiz(t, s, quiz.Id).Published {
+		t.Errorf("publishing: status %d", status)
+	}
+	_, body = new_client(t, ts).get("/quizzes")
+	if !strings.Contains(body, "Linear equations") {
+		t.Errorf("published quiz not listed: %s", body)
+	}
+
+	status, _ = admin.post("/delete_quiz/"+id, url.Values{"version": {"4"}})
+	if status != 409 {
+		t.Errorf("deleting a stale version: status %d", status)
+	}
+	status, _ = admin.post("/delete_quiz/"+id, url.Values{"version": {"5"}})
+	if status != 302 {
+		t.Errorf("deleting: status %d", status)
+	}
+	if _, err := s.db.RetrieveQuiz(quiz.Id); err != functions.ErrNotFound {
+		t.Errorf("deleted quiz: %v", err)
+	}
+	status, _ = admin.get("/quiz/" + id)
+	if status != 404 {
+		t.Errorf("opening a deleted quiz: status %d", status)
+	}
+}
+
+func TestGrading(t *testing.T) {
+	s, ts := new_test_server(t)
+	admin := login(t, ts, "admin", "admin")
+	quiz := published_quiz(t, s, admin)
+	id := quiz.Id.String()
+	answer := functions.AnswerPrefix + quiz.Questions[0].Id
+
+	// Students who aren't logged in are graded, but nothing is recorded
+	status, body := new_client(t, ts).post("/grade/"+id, url.Values{answer: {"1"}})
+	if status != 200 || !strings.Contains(body, "Your grade is: 100.0%") {
+		t.Errorf("anonymous grading: status %d: %s", status, body)
+	}
+
+	// Logged-in students' attempts are started when the quiz is opened and recorded when it is graded
+	bob := register(t, ts, "bob", "pw")
+	status, _ = bob.get("/quiz/" + id)
+	if status != 200 {
+		t.Fatalf("opening quiz: status %d", status)
+	}
+	attempt, err := s.db.RetrieveOpenAttempt("bob", quiz.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, body = bob.post("/grade/"+id, url.Values{"attempt": {attempt.Id}, answer: {"0"}})
+	if status != 200 || !strings.Contains(body, "Your grade is: 0.0%") {
+		t.Errorf("grading attempt: status %d: %s", status, body)
+	}
+	attempt, err = s.db.RetrieveAttempt(attempt.Id)
+	if err != nil || attempt.InProgress() || !attempt.Graded || attempt.Score != 0 {
+		t.Errorf("graded attempt: %+v, %v", attempt, err)
+	}
+	status, _ = bob.post("/grade/"+id, url.Values{"attempt": {attempt.Id}, answer: {"1"}})
+	if status != 409 {
+		t.Errorf("grading an attempt twice: status %d", status)
+	}
+
+	// Someone else's attempt can't be graded
+	bob.get("/quiz/" + id)
+	attempt, err = s.db.RetrieveOpenAttempt("bob", quiz.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, _ = register(t, ts, "eve", "pw").post("/grade/"+id, url.Values{"attempt": {attempt.Id}, answer: {"1"}})
+	if status != 404 {
+		t.Errorf("grading someone else's attempt: status %d", status)
+	}
+
+	status, _ = bob.post("/grade/"+id, url.Values{answer: {"7"}})
+	if status != 400 {
+		t.Errorf("grading an answer that isn't a choice: status %d", status)
+	}
+	attempts, err := s.db.RetrieveAttempts("bob")
+	if err != nil || len(attempts) != 2 {
+		t.Errorf("bob's attempts: %d, %v", len(attempts), err)
+	}
+}
+
+func TestRoleChecks(t *testing.T) {
+	s, ts := new_test_server(t)
+	admin := login(t, ts, "admin", "admin")
+	quiz := published_quiz(t, s, admin)
+	id := quiz.Id.String()
+	question := quiz.Questions[0].Id
+	if err := s.db.CreateAccount(functions.User{Username: "carol", Password: "pw", Role: "counselor"}); err != nil {
+		t.Fatal(err)
+	}
+	clients := map[string]*test_client{
+		"anonymous": new_client(t, ts),
+		"student":   register(t, ts, "bob", "pw"),
+		"counselor": login(t, ts, "carol", "pw"),
+	}
+	admin_only := []struct {
+		method string
+		path   string
+		form   url.Values
+	}{
+		{"GET", "/admin", nil},
+		{"POST", "/create_quiz", url.Values{"title": {"Sneaky"}}},
+		{"GET", "/addq/" + id, nil},
+		{"POST", "/add_question/" + id, url.Values{"question": {"q"}, "answers": {"a", "b"}, "correct": {"0"}, "version": {"3"}}},
+		{"POST", "/edit_question/" + id + "/" + question, url.Values{"question": {"q"}, "answers": {"a", "b"}, "correct": {"0"}, "version": {"3"}}},
+		{"POST", "/delete_question/" + id + "/" + question, url.Values{"version": {"3"}}},
+		{"POST", "/rename_quiz/" + id, url.Values{"title": {"Renamed"}, "version": {"3"}}},
+		{"POST", "/publish/" + id, url.Values{"published": {"no"}, "version": {"3"}}},
+		{"POST", "/delete_quiz/" + id, url.Values{"version": {"3"}}},
+		{"GET", "/export/" + id, nil},
+		{"GET", "/revisions/" + id, nil},
+		{"POST", "/passages", url.Values{"title": {"P"}, "text": {"T"}}},
+	}
+	for name, client := range clients {
+		for _, route := range admin_only {
+			status, _ := client.do(route.method, route.path, route.form)
+			if status < 400 {
+				t.Errorf("%s %s as %s: status %d", route.method, route.path, name, status)
+			}
+		}
+	}
+	if after := retrieve_quiz(t, s, quiz.Id); after.Version != quiz.Version || after.Title != quiz.Title || !after.Published {
+		t.Errorf("quiz changed by non-admins: %+v", after)
+	}
+
+	// Counselors grade essays and see students' scores, which students can't
+	for _, path := range []string{"/grading", "/attempts?user=bob"} {
+		if status, _ := clients["counselor"].get(path); status != 200 {
+			t.Errorf("%s as counselor: status %d", path, status)
+		}
+	}
+	for _, path := range []string{"/grading", "/attempts?user=carol"} {
+		if status, _ := clients["student"].get(path); status < 400 {
+			t.Errorf("%s as student: status %d", path, status)
+		}
+	}
+	for _, path := range []string{"/admin", "/addq/" + id, "/revisions/" + id} {
+		if status, _ := admin.get(path); status != 200 {
+			t.Errorf("%s as admin: status %d", path, status)
+		}
+	}
+}
+
+func TestUnknownIds(t *testing.T) {
+	// Every route that takes an {id}, with an id that can't be one and with one that could but doesn't exist, is a 404
+	s, ts := new_test_server(t)
+	admin := login(t, ts, "admin", "admin")
+	cookies := admin.http.Jar.Cookies(admin.http_url())
+	version := url.Values{"version": {"1"}} // Edits check for a version before looking for the quiz
+	routes := []struct {
+		method string
+		path   string // With {id} for the id and {question} for a question's
+		form   url.Values
+	}{
+		{"GET", "/quiz/{id}", nil},
+		{"POST", "/grade/{id}", version},
+		{"POST", "/save_answer/{id}/{question}", version},
+		{"GET", "/attempt/{id}", nil},
+		{"GET", "/addq/{id}", nil},
+		{"POST", "/add_question/{id}", version},
+		{"POST", "/publish/{id}", version},
+		{"GET", "/revisions/{id}", nil},
+		{"POST", "/rollback/{id}", url.Values{"version": {"1"}, "to": {"1"}}},
+		{"POST", "/edit_question/{id}/{question}", version},
+		{"POST", "/delete_question/{id}/{question}", version},
+		{"POST", "/move_question/{id}/{question}", version},
+		{"POST", "/rename_quiz/{id}", version},
+		{"GET", "/delete_quiz/{id}", nil},
+		{"POST", "/delete_quiz/{id}", version},
+		{"GET", "/passage/{id}", nil},
+		{"GET", "/export/{id}", nil},
+		{"GET", "/review/{id}", nil},
+		{"POST", "/review_settings/{id}", version},
+		{"POST", "/time_limit/{id}", version},
+		{"POST", "/scoring/{id}", version},
+		{"GET", "/grading/{id}", nil},
+		{"GET", "/test/{id}", nil},
+		{"POST", "/start_test/{id}", version},
+		{"GET", "/sitting/{id}", nil},
+		{"GET", "/sitting/{id}/section", nil},
+		{"GET", "/scale/{id}", nil},
+	}
+	for _, route := range routes {
+		for _, id := range []string{"not-an-id", functions.NewQuizID().String()} {
+			path := strings.NewReplacer("{id}", id, "{question}", functions.NewQuestionID()).Replace(route.path)
+			req := httptest.NewRequest(route.method, path, strings.NewReader(route.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			for _, cookie := range cookies {
+				req.AddCookie(cookie)
+			}
+			w := httptest.NewRecorder()
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.Errorf("%s %s panicked: %v", route.method, path, r)
+					}
+				}()
+				s.router().ServeHTTP(w, req)
+			}()
+			if w.Code != 404 {
+				t.Errorf("%s %s: status %d: %s", route.method, path, w.Code, strings.TrimSpace(w.Body.String()))
+			}
+		}
+	}
+}
+
+func TestDeletedSection(t *testing.T) {
+	// A quiz can't be deleted while a test uses it.  Once the test drops it, sittings that had it carry on: a section
+	// already taken keeps its score and one not yet started is skipped.
+	s, ts := new_test_server(t)
+	admin := login(t, ts, "admin", "admin")
+	taken := published_quiz(t, s, admin)
+	skipped := create_quiz(t, s, admin, "Geometry")
+	test_id, err := s.db.InsertTest(functions.Test{Title: "Practice", Published: true, Sections: []functions.TestSection{
+		{Name: "Arithmetic", Quiz: taken.Id}, {Name: "Geometry", Quiz: skipped.Id},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete_form := func(quiz functions.Quiz) url.Values {
+		return url.Values{"version": {strconv.Itoa(retrieve_quiz(t, s, quiz.Id).Version)}}
+	}
+	if status, _ := admin.post("/delete_quiz/"+skipped.Id.String(), delete_form(skipped)); status != 409 {
+		t.Errorf("deleting a test's section: status %d", status)
+	}
+
+	bob := register(t, ts, "bob", "pw")
+	if status, _ := bob.post("/start_test/"+test_id, nil); status != 303 {
+		t.Fatalf("starting the test: status %d", status)
+	}
+	sittings, err := s.db.RetrieveSittings("bob")
+	if err != nil || len(sittings) != 1 {
+		t.Fatalf("bob's sittings: %d, %v", len(sittings), err)
+	}
+	sitting_id := sittings[0].Id
+	if status, _ := bob.get("/sitting/" + sitting_id + "/section"); status != 200 {
+		t.Fatalf("starting the first section: status %d", status)
+	}
+	sitting, err := s.db.RetrieveSitting(sitting_id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer := functions.AnswerPrefix + taken.Questions[0].Id
+	if status, _ := bob.post("/grade/"+taken.Id.String(), url.Values{"attempt": {sitting.CurrentAttempt()}, answer: {"1"}}); status != 303 {
+		t.Fatalf("handing in the first section: status %d", status)
+	}
+
+	test, err := s.db.RetrieveTest(test_id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	test.Sections = []functions.TestSection{}
+	if err = s.db.UpdateTest(test); err != nil {
+		t.Fatal(err)
+	}
+	for _, quiz := range []functions.Quiz{taken, skipped} {
+		if status, _ := admin.post("/delete_quiz/"+quiz.Id.String(), delete_form(quiz)); status != 302 {
+			t.Errorf("deleting %s once the test dropped it: status %d", quiz.Title, status)
+		}
+	}
+
+	if status, body := bob.get("/sitting/" + sitting_id); status != 200 {
+		t.Fatalf("viewing the sitting: status %d: %s", status, body)
+	}
+	sitting, err = s.db.RetrieveSitting(sitting_id)
+	if err != nil || !sitting.Done() {
+		t.Fatalf("sitting after its last quiz was deleted: %+v, %v", sitting, err)
+	}
+	result, err := s.test_result(sitting)
+	if err != nil || result.Questions != 1 || result.Score != 100 {
+		t.Errorf("result: %+v, %v", result, err)
+	}
+	if status, _ := bob.get("/score"); status != 200 {
+		t.Errorf("scores page: status %d", status)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+/* Command-line entry point for the MongoDB schema migrations in functions/migrate.go.
+	./server -migrate status                 shows the version the data is at
+	./server -migrate up [-migrate-to N]     migrates to the latest version (or N)
+	./server -migrate down -migrate-to N     rolls back to version N
+Add -dry-run to print what would change without touching the data.
+*/
+
+import (
+	"fmt"
+	"functions"
+	"os"
+)
+
+func run_migrations(db *functions.MongoStore, direction string, target int, dryRun bool) error {
+	current, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	switch direction {
+	case "status":
+		fmt.Printf("Schema version %d of %d\n", current, functions.LatestSchemaVersion())
+		return nil
+	case "up":
+		if target < 0 {
+			target = functions.LatestSchemaVersion()
+		}
+		if target < current {
+			return fmt.Errorf("version %d is older than the current version %d; use -migrate down", target, current)
+		}
+	case "down":
+		if target < 0 {
+			return fmt.Errorf("-migrate down needs -migrate-to")
+		}
+		if target > current {
+			return fmt.Errorf("version %d is newer than the current version %d; use -migrate up", target, current)
+		}
+	default:
+		return fmt.Errorf("unknown -migrate action %q (expected status, up or down)", direction)
+	}
+	return db.Migrate(target, dryRun, os.Stdout)
+}
@@ -1,24 +1,56 @@
-var base_string = "<br /><br /><input type=text name=\"Questions.N.Question\" placeholder=\"Question N Text\" /><br />\
-			<input type=text name=\"Questions.N.Answers.0\" placeholder=\"Answer 1\" /><br />\
-			<input type=text name=\"Questions.N.Answers.1\" placeholder=\"Answer 2\" /><br />\
-			<input type=text name=\"Questions.N.Answers.2\" placeholder=\"Answer 3\" /><br />\
-			<input type=text name=\"Questions.N.Answers.3\" placeholder=\"Answer 4\" /><br />\
-			<label for=\"Questions.N.correct\">Which answer is correct? </label>\
-			<select name=\"Questions.N.correct\"><option value=0>1</option><option value=1>2</option><option value=2>3</option><option value=3>4</option></select><br />";
+// Answer choices for the question forms on /addq/{id}.
+// Each form keeps its choices in a .choices element; every choice is a text input, and for multiple choice a radio
+// button (is this the correct answer?) whose value is the choice's position, so they are renumbered after every change.
+// Select-all-that-apply questions use checkboxes instead of radio buttons.  Grid-in and ordering questions use the
+// same markup with no buttons at all, and essay rubrics add a points input to each row.
 
-var q_n = 0;
+var renumber = function(choices) {
+	var marks = choices.querySelectorAll("input[type=radio], input[type=checkbox]");
+	var inputs = choices.querySelectorAll("input[type=text]");
+	var label = choices.getAttribute("data-label");
+	for (var i = 0; i < inputs.length; i++) {
+		if (i < marks.length) {
+			marks[i].value = i;
+		}
+		inputs[i].placeholder = label + " " + (i + 1);
+	}
+}
 
-var do_formatting = function() {
-	q_n += 1;
-	var new_string = base_string;
-	new_string = new_string.replace("N", q_n);
-	new_string = new_string.replace("N", q_n + 1);
-	for (var i = 0; i < 6; i++) {
-		new_string = new_string.replace("N", q_n);
+var add_choice = function(button) {
+	var choices = button.form.querySelector(".choices");
+	var max = parseInt(choices.getAttribute("data-max"), 10);
+	if (choices.children.length >= max) {
+		return;
 	}
-	return new_string;
+	var choice = choices.children[0].cloneNode(true);
+	var mark = choice.querySelector("input[type=radio], input[type=checkbox]");
+	if (mark) {
+		mark.checked = false;
+	}
+	choice.querySelector("input[type=text]").value = "";
+	choices.appendChild(choice);
+	renumber(choices);
 }
 
-var addq = function() {
-	document.getElementById("questions").innerHTML += do_formatting();
+var remove_choice = function(button) {
+	var choice = button.parentNode;
+	var choices = choice.parentNode;
+	var min = parseInt(choices.getAttribute("data-min"), 10);
+	if (choices.children.length <= min) {
+		return;
+	}
+	var radio = choice.querySelector("input[type=radio]");
+	var was_correct = radio && radio.checked;
+	choices.removeChild(choice);
+	if (was_correct) {
+		choices.querySelector("input[type=radio]").checked = true;
+	}
+	renumber(choices);
 }
+
+document.addEventListener("DOMContentLoaded", function() {
+	var all = document.querySelectorAll(".choices");
+	for (var i = 0; i < all.length; i++) {
+		renumber(all[i]);
+	}
+});
@@ -0,0 +1,65 @@
+// Quizzes on /quiz/{id}, for logged-in students.
+// The form carries the attempt display_quiz started.  Each question's answer is posted to
+// /save_answer/{attempt}/{question id} whenever it changes, so the student can close the page and carry on later.
+// Timed attempts also carry the seconds they had left: the form is submitted when the countdown reaches zero, and
+// once time is up only saved answers count.  The server keeps the real deadline; this clock is only a guide.
+
+var save_delays = {}; // Field name -> pending save, while the student is typing
+
+var save_answer = function(form, name) {
+	// Posts every value of the question's field, or none if it was cleared
+	var question = name.slice("q.".length);
+	var values = new URLSearchParams();
+	var all = new FormData(form).getAll(name);
+	for (var i = 0; i < all.length; i++) {
+		values.append(name, all[i]);
+	}
+	var request = new XMLHttpRequest();
+	request.open("POST", "/save_answer/" + form.getAttribute("data-attempt") + "/" + question);
+	request.setRequestHeader("Content-Type", "application/x-www-form-urlencoded");
+	request.send(values.toString());
+}
+
+var save_soon = function(form, name) {
+	// Typing saves once the student pauses, rather than on every key
+	clearTimeout(save_delays[name]);
+	save_delays[name] = setTimeout(function() { save_answer(form, name); }, 1000);
+}
+
+var start_countdown = function(form) {
+	var clock = document.getElementById("countdown");
+	var deadline = Date.now() + 1000 * parseInt(form.getAttribute("data-remaining"), 10);
+	var tick = function() {
+		var left = Math.max(0, Math.round((deadline - Date.now()) / 1000));
+		clock.textContent = Math.floor(left / 60) + ":" + ("0" + left % 60).slice(-2);
+		if (left == 0) {
+			clearInterval(timer);
+			form.submit();
+		}
+	};
+	var timer = setInterval(tick, 1000);
+	tick();
+}
+
+document.addEventListener("DOMContentLoaded", function() {
+	var form = document.getElementById("attempt");
+	if (!form) {
+		return;
+	}
+	form.addEventListener("change", function(event) {
+		var name = event.target.name;
+		if (name && name.indexOf("q.") == 0) {
+			clearTimeout(save_delays[name]);
+			save_answer(form, name);
+		}
+	});
+	form.addEventListener("input", function(event) {
+		var name = event.target.name;
+		if (name && name.indexOf("q.") == 0) {
+			save_soon(form, name);
+		}
+	});
+	if (form.hasAttribute("data-remaining")) {
+		start_countdown(form);
+	}
+});